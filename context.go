@@ -48,6 +48,32 @@ func From(ctx context.Context) Context {
 	return &contextT{ctx: ctx}
 }
 
+// NewContext returns a context based on ctx with the key/value pairs
+// attached. Any key/value pairs already attached to ctx are kept, and
+// follow keyvals in the list returned by FromContext, so a child context
+// adds to the pairs of its parent rather than replacing them. It is
+// equivalent to From(ctx).With(keyvals...), but returns the new context
+// without the Context wrapper.
+//
+// Each call allocates a new list containing the pairs of the parent
+// context, and the parent context is not modified. If keyvals is empty,
+// ctx is returned unchanged. If ctx is nil, context.Background is used.
+func NewContext(ctx context.Context, keyvals ...interface{}) context.Context {
+	return newContext(ctx, keyvals)
+}
+
+// FromContext returns the key/value pairs attached to ctx by NewContext
+// or Context.With, including those attached to its parent contexts. The
+// most recently attached pairs come first. If ctx is nil, or has no
+// key/value pairs attached, FromContext returns an empty list.
+//
+// FromContext does not allocate: the list shares memory with the context.
+// Its capacity is limited to its length, so appending to the list does
+// not modify the pairs attached to the context.
+func FromContext(ctx context.Context) List {
+	return List(fromContext(ctx))
+}
+
 // Deadline implements the context.Context interface.
 func (c *contextT) Deadline() (deadline time.Time, ok bool) {
 	return c.ctx.Deadline()
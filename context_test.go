@@ -3,6 +3,7 @@ package kv
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -97,3 +98,62 @@ func TestContextFormat(t *testing.T) {
 		t.Errorf("\n got=%v\nwant=%v", got, want)
 	}
 }
+
+func TestNewContext(t *testing.T) {
+	parent := NewContext(context.Background(), "a", 1, "b", 2)
+	child1 := NewContext(parent, "c", 3)
+	child2 := NewContext(parent, List{"d", 4}, "a", 5)
+	wrapped := From(child1).With("e", 6)
+
+	tests := []struct {
+		ctx  context.Context
+		want List
+	}{
+		{
+			ctx:  context.Background(),
+			want: List{},
+		},
+		{
+			ctx:  nil,
+			want: List{},
+		},
+		{
+			ctx:  NewContext(nil),
+			want: List{},
+		},
+		{
+			ctx:  parent,
+			want: List{"a", 1, "b", 2},
+		},
+		{ // child pairs precede the pairs of the parent
+			ctx:  child1,
+			want: List{"c", 3, "a", 1, "b", 2},
+		},
+		{ // siblings do not share pairs, and keys are not shadowed
+			ctx:  child2,
+			want: List{"d", 4, "a", 5, "a", 1, "b", 2},
+		},
+		{
+			ctx:  wrapped,
+			want: List{"e", 6, "c", 3, "a", 1, "b", 2},
+		},
+		{ // unrelated values do not hide the pairs
+			ctx:  context.WithValue(child1, ctxKeyT("other"), 1),
+			want: List{"c", 3, "a", 1, "b", 2},
+		},
+	}
+
+	for tn, tt := range tests {
+		got := FromContext(tt.ctx)
+		if len(got) != len(tt.want) || len(got) > 0 && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d:\n got=%v\nwant=%v", tn, got, tt.want)
+		}
+	}
+
+	// appending to the list does not modify the context
+	list := append(FromContext(parent), "x", 1)
+	list[0] = "y"
+	if got, want := FromContext(parent), (List{"a", 1, "b", 2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got=%v\nwant=%v", got, want)
+	}
+}
@@ -2,6 +2,9 @@ package kv
 
 import (
 	"context"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/jjeffery/kv/internal/pool"
@@ -15,6 +18,25 @@ type Error interface {
 	// With returns a new error based on this error
 	// with the key/value pairs attached.
 	With(keyvals ...interface{}) Error
+
+	// Keyvals returns the key/value pairs of the error merged
+	// with those of the errors it wraps.
+	Keyvals() List
+}
+
+// StackTracer is implemented by errors that can return the call stack
+// recorded when they were created. The errors created by this package
+// implement StackTracer, but the call stack is only recorded by
+// NewErrorWithStack and WrapWithStack.
+//
+//	var st kv.StackTracer
+//	if errors.As(err, &st) {
+//		for _, frame := range st.StackTrace() {
+//			fmt.Printf("%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+//		}
+//	}
+type StackTracer interface {
+	StackTrace() []runtime.Frame
 }
 
 type errorT struct {
@@ -22,9 +44,13 @@ type errorT struct {
 	list    List
 	ctxlist List
 	err     error
+	stack   []uintptr // program counters, or nil
 }
 
-var _ Error = &errorT{}
+var (
+	_ Error       = &errorT{}
+	_ StackTracer = &errorT{}
+)
 
 // NewError returns an error that formats as the given text.
 func NewError(text string) Error {
@@ -37,6 +63,37 @@ func Wrap(err error, text ...string) Error {
 	return causer(newError(nil, err, text...))
 }
 
+// NewErrorWithStack is like NewError, except that the call stack is
+// recorded. The file name and line number of the caller is included
+// in the error message with the key "caller", and the call stack is
+// available from the StackTrace method (see StackTracer). Recording the
+// call stack has a cost, so it is not recorded by NewError.
+func NewErrorWithStack(text string) Error {
+	e := newError(nil, nil, text)
+	e.stack = callers()
+	return e
+}
+
+// WrapWithStack is like Wrap, except that the call stack is
+// recorded. See NewErrorWithStack.
+func WrapWithStack(err error, text ...string) Error {
+	e := newError(nil, err, text...)
+	e.stack = callers()
+	return causer(e)
+}
+
+// maxStackDepth is the maximum number of frames recorded
+// by NewErrorWithStack and WrapWithStack.
+const maxStackDepth = 32
+
+// callers returns the program counters of the function calling
+// the function that called callers.
+func callers() []uintptr {
+	var pcs [maxStackDepth]uintptr
+	n := runtime.Callers(3, pcs[:])
+	return append([]uintptr(nil), pcs[:n]...)
+}
+
 func newError(ctx context.Context, err error, text ...string) *errorT {
 	e := &errorT{
 		text: strings.Join(text, " "),
@@ -54,35 +111,9 @@ func newError(ctx context.Context, err error, text ...string) *errorT {
 // The resulting string can be parsed with the Parse function.
 func (e *errorT) Error() string {
 	var (
-		text     = strings.TrimSpace(e.text)
-		prevText []byte
-		prevList List
+		text               = strings.TrimSpace(e.text)
+		prevText, prevList = parseCause(e.err)
 	)
-	if e.err != nil {
-		input := []byte(e.err.Error())
-		prevText, prevList = Parse(input)
-		if len(prevText) == 0 && len(prevList) > 0 {
-			// The previous message consists only of key/value
-			// pairs. Search for a key indicating the message.
-			i := 0
-			newLen := len(prevList)
-			for ; i < len(prevList); i += 2 {
-				key, _ := prevList[i].(string)
-				if key == "msg" {
-					if value, ok := prevList[i+1].(string); ok {
-						prevText = []byte(value)
-						newLen -= 2
-						break
-					}
-				}
-			}
-			for ; i < len(prevList)-2; i += 2 {
-				prevList[i] = prevList[i+2]
-				prevList[i+1] = prevList[i+3]
-			}
-			prevList = prevList[:newLen]
-		}
-	}
 
 	buf := pool.AllocBuffer()
 	defer pool.ReleaseBuffer(buf)
@@ -95,7 +126,7 @@ func (e *errorT) Error() string {
 	}
 	buf.Write(prevText)
 
-	list := dedup(e.list, prevList, e.ctxlist)
+	list := dedup(e.list, prevList, e.ctxlist, e.callerList())
 	if len(list) > 0 {
 		if buf.Len() > 0 {
 			buf.WriteRune(' ')
@@ -106,17 +137,117 @@ func (e *errorT) Error() string {
 	return buf.String()
 }
 
+// parseCause returns the message text and key/value pairs
+// of a wrapped error, or nil if err is nil.
+func parseCause(err error) (text []byte, list List) {
+	if err == nil {
+		return nil, nil
+	}
+	text, list = Parse([]byte(err.Error()))
+	if len(text) == 0 && len(list) > 0 {
+		// The previous message consists only of key/value
+		// pairs. Search for a key indicating the message.
+		i := 0
+		newLen := len(list)
+		for ; i < len(list); i += 2 {
+			key, _ := list[i].(string)
+			if key == "msg" {
+				if value, ok := list[i+1].(string); ok {
+					text = []byte(value)
+					newLen -= 2
+					break
+				}
+			}
+		}
+		for ; i < len(list)-2; i += 2 {
+			list[i] = list[i+2]
+			list[i+1] = list[i+3]
+		}
+		list = list[:newLen]
+	}
+	return text, list
+}
+
+// Keyvals returns the key/value pairs of the error merged with those of
+// the errors it wraps, which are the pairs printed by the Error method.
+// The pairs attached to this error come first, followed by the pairs
+// of the wrapped errors, outermost first, and then any pairs from this
+// error's context and caller. Key/value pairs of wrapped errors created by
+// this package keep the type of their values. Other wrapped errors are
+// parsed, so their values are strings.
+//
+// If the same key appears at more than one level with the same value, only
+// the first pair is kept. If the values differ, all pairs are kept, so the
+// value attached to the outermost error is the first one with that key.
+//
+// The Error method prints the same pairs after the error text, so a
+// kvlog.Writer that expands error values (see kvlog.Writer.ExpandErrors)
+// displays the merged pairs of the whole chain.
+//
+// Keyvals returns a List rather than []interface{}. A value with a
+// Keyvals() []interface{} method is flattened into its key/value pairs
+// wherever it appears in a key/value list, so an error passed as the
+// value in kv.With("error", err) would lose its text.
+func (e *errorT) Keyvals() List {
+	var prevList List
+	if cause, ok := e.err.(Error); ok {
+		prevList = cause.Keyvals()
+	} else {
+		_, prevList = parseCause(e.err)
+	}
+	list := dedup(e.list, prevList, e.ctxlist, e.callerList())
+	return list[:len(list):len(list)]
+}
+
 func (e *errorT) With(keyvals ...interface{}) Error {
 	return causer(&errorT{
 		text:    e.text,
 		list:    e.list.With(keyvals...),
 		ctxlist: e.ctxlist,
 		err:     e.err,
+		stack:   e.stack,
 	})
 }
 
-// Unwrap implements the Wrapper interface.
-// See golang.org/x/exp/errors.
+// StackTrace implements the StackTracer interface. It returns the call
+// stack recorded when the error was created, starting with the caller of
+// NewErrorWithStack or WrapWithStack. If the call stack was not recorded,
+// it returns the call stack of the wrapped error, or nil if the wrapped
+// error is not a StackTracer. This way the stack is available from the
+// outermost error when a recorded error is wrapped by Wrap.
+func (e *errorT) StackTrace() []runtime.Frame {
+	if len(e.stack) == 0 {
+		if st, ok := e.err.(StackTracer); ok {
+			return st.StackTrace()
+		}
+		return nil
+	}
+	var stack []runtime.Frame
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		frame, more := frames.Next()
+		stack = append(stack, frame)
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// callerList returns a list containing the file name and line number
+// of the caller that created the error, or nil if the call stack was
+// not recorded.
+func (e *errorT) callerList() List {
+	if len(e.stack) == 0 {
+		return nil
+	}
+	frame, _ := runtime.CallersFrames(e.stack[:1]).Next()
+	caller := filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+	return List{"caller", caller}
+}
+
+// Unwrap returns the wrapped error, or nil if there is no wrapped error.
+// This allows errors.Is and errors.As to examine the wrapped errors.
 func (e *errorT) Unwrap() error {
 	return e.err
 }
@@ -0,0 +1,89 @@
+//go:build go1.13
+// +build go1.13
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	tests := []struct {
+		err    error
+		target error
+		want   bool
+	}{
+		{
+			err:    Wrap(sentinel, "one layer"),
+			target: sentinel,
+			want:   true,
+		},
+		{
+			err:    Wrap(Wrap(sentinel, "inner"), "outer"),
+			target: sentinel,
+			want:   true,
+		},
+		{
+			err:    Wrap(sentinel).With("a", 1).With("b", 2),
+			target: sentinel,
+			want:   true,
+		},
+		{
+			err:    With("a", 1).Wrap(context.DeadlineExceeded, "timed out"),
+			target: context.DeadlineExceeded,
+			want:   true,
+		},
+		{
+			err:    Wrap(Wrap(sentinel), "outer"),
+			target: context.Canceled,
+			want:   false,
+		},
+		{
+			err:    NewError("no cause"),
+			target: sentinel,
+			want:   false,
+		},
+	}
+	for tn, tt := range tests {
+		if got, want := errors.Is(tt.err, tt.target), tt.want; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
+		}
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	opErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	err := Wrap(Wrap(opErr, "cannot connect").With("host", "db1"), "cannot start")
+
+	var netErr *net.OpError
+	if !errors.As(err, &netErr) {
+		t.Fatalf("errors.As(*net.OpError): got=false, want=true")
+	}
+	if netErr != opErr {
+		t.Errorf("got=%v, want=%v", netErr, opErr)
+	}
+
+	var kvErr Error
+	if !errors.As(err, &kvErr) {
+		t.Fatalf("errors.As(Error): got=false, want=true")
+	}
+	if got, want := kvErr.Error(), err.Error(); got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestErrorsAsStackTracer(t *testing.T) {
+	err := Wrap(WrapWithStack(errors.New("not found"), "cannot load"), "cannot start")
+
+	var st StackTracer
+	if !errors.As(err, &st) {
+		t.Fatalf("errors.As(StackTracer): got=false, want=true")
+	}
+	if len(st.StackTrace()) == 0 {
+		t.Errorf("got no stack trace, want stack trace")
+	}
+}
@@ -3,6 +3,10 @@ package kv
 import (
 	"context"
 	"errors"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -133,7 +137,6 @@ func TestError(t *testing.T) {
 	}
 }
 
-/*
 func TestUnwrap(t *testing.T) {
 	err1 := errors.New("error 1")
 	tests := []struct {
@@ -170,4 +173,106 @@ func TestUnwrap(t *testing.T) {
 		}
 	}
 }
-*/
+
+func TestErrorKeyvals(t *testing.T) {
+	tests := []struct {
+		err  Error
+		want List
+	}{
+		{
+			err:  NewError("not found").With("id", 1),
+			want: List{"id", 1},
+		},
+		{ // two levels, outer pairs first
+			err:  Wrap(NewError("not found").With("id", 1, "table", "users"), "cannot load").With("user", "alice"),
+			want: List{"user", "alice", "id", 1, "table", "users"},
+		},
+		{ // same key and value at both levels
+			err:  Wrap(NewError("not found").With("id", 1), "cannot load").With("id", 1),
+			want: List{"id", 1},
+		},
+		{ // same key with different values
+			err:  Wrap(NewError("not found").With("id", 1), "cannot load").With("id", 2),
+			want: List{"id", 2, "id", 1},
+		},
+		{ // other errors are parsed
+			err:  Wrap(errors.New("not found id=1"), "cannot load").With("user", "alice"),
+			want: List{"user", "alice", "id", "1"},
+		},
+		{
+			err:  Wrap(errors.New("not found"), "cannot load"),
+			want: List{},
+		},
+	}
+	for tn, tt := range tests {
+		got := tt.err.Keyvals()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d:\n got=%v\nwant=%v", tn, got, tt.want)
+		}
+
+		// errors are not flattened when passed as values
+		if got, want := With("error", tt.err).String(), "error="+strconv.Quote(tt.err.Error()); got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestErrorWithStack(t *testing.T) {
+	err1, line1 := NewErrorWithStack("not found"), lineNumber()
+	err2, line2 := WrapWithStack(err1, "cannot load").With("id", 1), lineNumber()
+
+	tests := []struct {
+		err      error
+		text     string
+		function string
+	}{
+		{
+			err:      err1,
+			text:     `not found caller="error_test.go:` + strconv.Itoa(line1) + `"`,
+			function: "TestErrorWithStack",
+		},
+		{
+			err:      err2,
+			text:     `cannot load: not found id=1 caller="error_test.go:` + strconv.Itoa(line1) + `" caller="error_test.go:` + strconv.Itoa(line2) + `"`,
+			function: "TestErrorWithStack",
+		},
+		{ // the stack of a wrapped error is returned
+			err:      Wrap(err1, "cannot start"),
+			text:     `cannot start: not found caller="error_test.go:` + strconv.Itoa(line1) + `"`,
+			function: "TestErrorWithStack",
+		},
+		{
+			err:  NewError("no stack"),
+			text: "no stack",
+		},
+		{
+			err:  Wrap(errors.New("not found"), "no stack"),
+			text: "no stack: not found",
+		},
+	}
+	for tn, tt := range tests {
+		if got, want := tt.err.Error(), tt.text; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+		stack := tt.err.(StackTracer).StackTrace()
+		if tt.function == "" {
+			if stack != nil {
+				t.Errorf("%d: got=%v, want=nil", tn, stack)
+			}
+			continue
+		}
+		if len(stack) == 0 {
+			t.Errorf("%d: no stack trace", tn)
+			continue
+		}
+		if got, want := stack[0].Function, tt.function; !strings.HasSuffix(got, "."+want) {
+			t.Errorf("%d: got=%q, want=%q", tn, got, want)
+		}
+	}
+}
+
+// lineNumber returns the line number of its caller.
+func lineNumber() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
@@ -1,14 +1,40 @@
 package kv
 
 import (
+	"fmt"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type keyvalser interface {
 	Keyvals() []interface{}
 }
 
+// MissingValue, if not nil, is the value given to a key that does not have
+// a value, which happens when the last item of a list with an odd number of
+// items is a string that can be a key, such as "b" in With("a", 1, "b"). The
+// result is "a=1 b=MISSING" if MissingValue is Missing.
+//
+// If MissingValue is nil, which is the default, the item is treated as a
+// value with a missing key, and the result is "a=1 msg=b". Set MissingValue
+// during program initialization, before any lists are created.
+var MissingValue interface{}
+
+// Missing is a placeholder for a missing value, which is printed as MISSING.
+// See MissingValue.
+var Missing fmt.Stringer = missingValueT{}
+
+// missingValueT is the type of Missing.
+type missingValueT struct{}
+
+func (missingValueT) String() string {
+	return "MISSING"
+}
+
 // flattenFix accepts a keyvals slice and "flattens" it into a slice
 // of alternating key/value pairs. See the examples.
 //
@@ -51,6 +77,13 @@ func flattenFix(keyvals []interface{}) []interface{} {
 			// additional memory allocation, so use a conservative guess
 			requiresFlattening = true
 			estimatedLen += 16
+		case map[string]interface{}:
+			if isEven(i) {
+				requiresFlattening = true
+				estimatedLen += len(v) * 2
+			} else {
+				estimatedLen++
+			}
 		case string:
 			if v == keyMsg {
 				// Remember that we already have a "msg" key, which
@@ -173,8 +206,16 @@ func flatten(
 			// key/value list, so flatten and fix it as if this slice
 			// had been passed to the flattenFix function in the first place.
 			output = flatten(output, v.Keyvals(), missingKeyName)
+		case []interface{}:
+			// A slice of key/value pairs in the position of a key,
+			// which is flattened in the same way as a keyvalser.
+			output = flatten(output, v, missingKeyName)
+		case map[string]interface{}:
+			output = appendMap(output, "", v)
 		default:
-			//panic("cannot happen")
+			if fields := structFields(v); fields != nil {
+				output = appendStruct(output, "", v, fields)
+			}
 		}
 
 		input = input[1:]
@@ -185,17 +226,112 @@ func flatten(
 
 // countScalars returns the count of items in input up to but
 // not including the first non-scalar item. A scalar is a single
-// value item, ie not a keyvalser.
+// value item, ie not a keyvalser, and not a []interface{},
+// map[string]interface{} or struct with kv tags in the position
+// of a key. These types are scalars in the position of a value,
+// so that they can be logged as values.
 func countScalars(input []interface{}) int {
 	for i := 0; i < len(input); i++ {
 		switch input[i].(type) {
 		case keyvalser:
 			return i
+		case []interface{}, map[string]interface{}:
+			if isEven(i) {
+				return i
+			}
+		default:
+			if isEven(i) && structFields(input[i]) != nil {
+				return i
+			}
 		}
 	}
 	return len(input)
 }
 
+// appendMap appends the key/value pairs in m to output, sorted by key
+// so that the order is deterministic. Each key is prefixed with prefix.
+func appendMap(output []interface{}, prefix string, m map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		output = appendField(output, prefix+key, m[key])
+	}
+	return output
+}
+
+// appendStruct appends the fields of struct v to output, in the order
+// they are declared. Each key is prefixed with prefix.
+func appendStruct(output []interface{}, prefix string, v interface{}, fields []structField) []interface{} {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for _, f := range fields {
+		output = appendField(output, prefix+f.name, rv.Field(f.index).Interface())
+	}
+	return output
+}
+
+// appendField appends a key/value pair to output. If the value is a map
+// or a struct with kv tags, its contents are appended instead, with keys
+// that are prefixed with key and a dot.
+func appendField(output []interface{}, key string, value interface{}) []interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		return appendMap(output, key+".", m)
+	}
+	if fields := structFields(value); fields != nil {
+		return appendStruct(output, key+".", value, fields)
+	}
+	return append(output, key, value)
+}
+
+// structField is a struct field that is flattened into a key/value pair.
+type structField struct {
+	index int    // index of the field in the struct
+	name  string // key name
+}
+
+// structFieldsCache maps a reflect.Type to a []structField.
+var structFieldsCache sync.Map
+
+// structFields returns the fields of v that are flattened into key/value
+// pairs, or nil if v is not a struct, or a non-nil pointer to a struct, with
+// kv tags. A field is flattened if it is exported and has a kv tag. The tag
+// is the key name, or if it is empty, the field name is used. Fields with
+// the tag `kv:"-"` are ignored.
+func structFields(v interface{}) []structField {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		if reflect.ValueOf(v).IsNil() {
+			return nil
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := f.Tag.Lookup("kv")
+		if !ok || name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{index: i, name: strings.TrimSpace(name)})
+	}
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
 // flattenScalars adjusts a list of items, none of which are keyvalsers.
 //
 // Ideally the list will have an even number of items, with strings in the
@@ -205,6 +341,13 @@ func flattenScalars(
 	input []interface{},
 	missingKeyName func() interface{},
 ) []interface{} {
+	if isOdd(len(input)) && MissingValue != nil {
+		if key, ok := input[len(input)-1].(string); ok && possibleKeyRE.MatchString(key) {
+			// the capacity is limited, so input is copied
+			input = append(input[:len(input):len(input)], MissingValue)
+		}
+	}
+
 	for len(input) > 0 {
 		var needsFixing bool
 
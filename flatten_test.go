@@ -71,10 +71,58 @@ func TestFlatten(t *testing.T) {
 			v:    []interface{}{List{"a", 1, "b", 2}},
 			want: []interface{}{"a", 1, "b", 2},
 		},
+		{
+			v:    []interface{}{"a", 1, List{"b", 2, List{"c", 3, "d", 4}}},
+			want: []interface{}{"a", 1, "b", 2, "c", 3, "d", 4},
+		},
+		{
+			v:    []interface{}{"a", 1, []interface{}{"b", 2, []interface{}{"c", 3}}, "d", 4},
+			want: []interface{}{"a", 1, "b", 2, "c", 3, "d", 4},
+		},
+		{ // odd-length nested lists have missing keys
+			v:    []interface{}{"msg", "message", List{"b", 2, List{"c", 3, 4}}},
+			want: []interface{}{"msg", "message", "b", 2, "c", 3, "_p1", 4},
+		},
+		{
+			v:    []interface{}{"a", 1, []interface{}{"b", 2, "not found"}},
+			want: []interface{}{"a", 1, "b", 2, "msg", "not found"},
+		},
+		{ // a slice in the position of a value is not flattened
+			v:    []interface{}{"tags", []interface{}{"x", "y"}, "a", 1},
+			want: []interface{}{"tags", []interface{}{"x", "y"}, "a", 1},
+		},
+		{
+			v:    []interface{}{List{"a", 1}, "tags", []interface{}{"x", "y"}},
+			want: []interface{}{"a", 1, "tags", []interface{}{"x", "y"}},
+		},
 		{
 			v:    []interface{}{testKeyvalser{}, "5", 6},
 			want: []interface{}{"1", "2", "3", "4", "5", 6},
 		},
+		{ // maps are flattened in key order
+			v:    []interface{}{map[string]interface{}{"b": 2, "a": 1}, "c", 3},
+			want: []interface{}{"a", 1, "b", 2, "c", 3},
+		},
+		{ // nested maps have dotted keys
+			v:    []interface{}{map[string]interface{}{"user": map[string]interface{}{"id": 1, "name": "x"}}},
+			want: []interface{}{"user.id", 1, "user.name", "x"},
+		},
+		{ // a map in the position of a value is not flattened
+			v:    []interface{}{"m", map[string]interface{}{"a": 1}},
+			want: []interface{}{"m", map[string]interface{}{"a": 1}},
+		},
+		{
+			v:    []interface{}{testStruct{ID: 1, Name: "x", Ignored: 2, Inner: testInner{Code: 3}}},
+			want: []interface{}{"id", 1, "Name", "x", "inner.code", 3},
+		},
+		{
+			v:    []interface{}{&testInner{Code: 3}, "a", 1},
+			want: []interface{}{"code", 3, "a", 1},
+		},
+		{ // a nil pointer to a struct is not flattened
+			v:    []interface{}{(*testInner)(nil)},
+			want: []interface{}{"_p1", (*testInner)(nil)},
+		},
 	}
 
 	for i, tt := range tests {
@@ -85,8 +133,62 @@ func TestFlatten(t *testing.T) {
 	}
 }
 
+func TestFlattenMissingValue(t *testing.T) {
+	defer func(v interface{}) { MissingValue = v }(MissingValue)
+	MissingValue = Missing
+
+	tests := []struct {
+		v    []interface{}
+		want []interface{}
+	}{
+		{
+			v:    []interface{}{"key"},
+			want: []interface{}{"key", Missing},
+		},
+		{
+			v:    []interface{}{"a", 1, "b"},
+			want: []interface{}{"a", 1, "b", Missing},
+		},
+		{
+			v:    []interface{}{List{"a", 1, "b"}, "c", 2},
+			want: []interface{}{"a", 1, "b", Missing, "c", 2},
+		},
+		{ // not a key
+			v:    []interface{}{"a", 1, "not found"},
+			want: []interface{}{"a", 1, "msg", "not found"},
+		},
+		{ // not a string
+			v:    []interface{}{"a", 1, 2},
+			want: []interface{}{"a", 1, "_p1", 2},
+		},
+	}
+
+	for i, tt := range tests {
+		got := flattenFix(tt.v)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: want %v, got %v", i, tt.want, got)
+		}
+	}
+
+	if got, want := With("a", 1, "b").String(), "a=1 b=MISSING"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
 type testKeyvalser struct{}
 
 func (tkv testKeyvalser) Keyvals() []interface{} {
 	return []interface{}{"1", "2", "3", "4"}
 }
+
+type testStruct struct {
+	ID      int       `kv:"id"`
+	Name    string    `kv:""`
+	Ignored int       `kv:"-"`
+	Other   int       // not flattened, no tag
+	Inner   testInner `kv:"inner"`
+}
+
+type testInner struct {
+	Code int `kv:"code"`
+}
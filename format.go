@@ -0,0 +1,53 @@
+package kv
+
+import (
+	"reflect"
+
+	"github.com/jjeffery/kv/internal/logfmt"
+)
+
+// RegisterFormatter registers a function that formats values of type t
+// when they are rendered as text, for example by List.String and
+// Error.Error. It replaces any function previously registered for t,
+// and a nil format removes it.
+//
+// Values of type time.Time are formatted in RFC 3339 format, and values of
+// type time.Duration use their compact String representation, unless a
+// function is registered for them.
+//
+//	kv.RegisterFormatter(reflect.TypeOf(Money{}), func(v interface{}) string {
+//		m := v.(Money)
+//		return fmt.Sprintf("%s%d.%02d", m.Currency, m.Cents/100, m.Cents%100)
+//	})
+//
+// RegisterFormatter is typically called during program initialization.
+// It is safe to call concurrently with formatting.
+func RegisterFormatter(t reflect.Type, format func(v interface{}) string) {
+	logfmt.RegisterFormatter(t, format)
+}
+
+// BytesFormat specifies how values of type []byte, and of other byte slice
+// types, are formatted. See SetBytesFormat.
+type BytesFormat int
+
+const (
+	// BytesText formats byte slices that are valid UTF-8 as text, quoted
+	// if necessary, and other byte slices in hexadecimal. This is the default.
+	BytesText BytesFormat = iota
+
+	// BytesHex formats all byte slices in hexadecimal.
+	BytesHex
+)
+
+// SetBytesFormat sets how byte slice values are formatted when they are
+// rendered as text, for example by List.String and Error.Error. An empty
+// byte slice is formatted as "", and a nil byte slice as null. Messages
+// logged with key/value pairs are formatted before they reach a kvlog
+// writer, so the writer's options, such as MaxValueWidth, apply to the
+// formatted value.
+//
+// SetBytesFormat is typically called during program initialization.
+// It is safe to call concurrently with formatting.
+func SetBytesFormat(f BytesFormat) {
+	logfmt.SetBytesHex(f == BytesHex)
+}
@@ -0,0 +1,62 @@
+package kv
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testMoney struct {
+	Currency string
+	Cents    int
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	typ := reflect.TypeOf(testMoney{})
+	RegisterFormatter(typ, func(v interface{}) string {
+		m := v.(testMoney)
+		return m.Currency + strconv.Itoa(m.Cents)
+	})
+	defer RegisterFormatter(typ, nil)
+
+	tm := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+	list := List{
+		"price", testMoney{Currency: "AUD", Cents: 300},
+		"time", tm,
+		"elapsed", 1500 * time.Millisecond,
+	}
+	want := `price=AUD300 time="2009-11-10T23:00:00Z" elapsed="1.5s"`
+	if got := list.String(); got != want {
+		t.Errorf("\n got=%s\nwant=%s", got, want)
+	}
+	if got, want := list.NewError("msg").Error(), "msg "+want; got != want {
+		t.Errorf("\n got=%s\nwant=%s", got, want)
+	}
+}
+
+func TestSetBytesFormat(t *testing.T) {
+	defer SetBytesFormat(BytesText)
+	tests := []struct {
+		format BytesFormat
+		list   List
+		want   string
+	}{
+		{
+			format: BytesText,
+			list:   List{"a", []byte("hello world"), "b", []byte{}, "c", []byte{0xca, 0xfe}},
+			want:   `a="hello world" b="" c=cafe`,
+		},
+		{
+			format: BytesHex,
+			list:   List{"a", []byte("hello world"), "b", []byte{}, "c", []byte{0xca, 0xfe}},
+			want:   `a=68656c6c6f20776f726c64 b="" c=cafe`,
+		},
+	}
+	for tn, tt := range tests {
+		SetBytesFormat(tt.format)
+		if got, want := tt.list.String(), tt.want; got != want {
+			t.Errorf("%d:\n got=%s\nwant=%s", tn, got, want)
+		}
+	}
+}
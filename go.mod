@@ -1,3 +1,8 @@
 module github.com/jjeffery/kv
 
-require golang.org/x/sys v0.0.0-20181221143128-b4a75ba826a6
+go 1.12
+
+require (
+	golang.org/x/sys v0.20.0
+	golang.org/x/text v0.22.0
+)
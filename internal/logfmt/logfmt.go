@@ -5,9 +5,13 @@ package logfmt
 import (
 	"bytes"
 	"encoding"
+	"encoding/hex"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -29,6 +33,67 @@ var (
 	}
 )
 
+// formatters contains a map of reflect.Type to func(interface{}) string,
+// and is replaced, never modified, when a formatter is registered.
+var (
+	formatters     atomic.Value
+	formattersLock sync.Mutex
+)
+
+// RegisterFormatter registers a function that formats values of type t.
+// It replaces any function previously registered for t. If format is
+// nil, any function previously registered for t is removed.
+func RegisterFormatter(t reflect.Type, format func(interface{}) string) {
+	formattersLock.Lock()
+	defer formattersLock.Unlock()
+	prev, _ := formatters.Load().(map[reflect.Type]func(interface{}) string)
+	m := make(map[reflect.Type]func(interface{}) string, len(prev)+1)
+	for k, v := range prev {
+		m[k] = v
+	}
+	if format == nil {
+		delete(m, t)
+	} else {
+		m[t] = format
+	}
+	formatters.Store(m)
+}
+
+// bytesHex is non-zero if byte slice values are always written in hex.
+var bytesHex int32
+
+// SetBytesHex determines whether byte slice values are always written
+// in hexadecimal. If hex is false, which is the default, byte slices that
+// are valid UTF-8 are written as text, and other byte slices in hex.
+func SetBytesHex(hex bool) {
+	var v int32
+	if hex {
+		v = 1
+	}
+	atomic.StoreInt32(&bytesHex, v)
+}
+
+// Formatter returns the function registered to format value,
+// or nil if there is none.
+func Formatter(value interface{}) func(interface{}) string {
+	m, _ := formatters.Load().(map[reflect.Type]func(interface{}) string)
+	if len(m) == 0 || value == nil {
+		return nil
+	}
+	return m[reflect.TypeOf(value)]
+}
+
+// Format calls format, a function registered by RegisterFormatter,
+// to format value. It returns "PANIC" if format panics.
+func Format(format func(interface{}) string, value interface{}) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = string(bytesPanic)
+		}
+	}()
+	return format(value)
+}
+
 // Writer is an interface implemented by both bytes.Buffer and strings.Builder
 type Writer interface {
 	Write(p []byte) (n int, err error)
@@ -43,6 +108,32 @@ func WriteKeyValue(buf Writer, key, value interface{}) {
 	WriteValue(buf, value)
 }
 
+// AppendKeyValue appends the key and value to list as text. The text
+// is the same as the key and value parsed from the output of WriteKeyValue,
+// so it is not quoted and does not contain escape sequences.
+func AppendKeyValue(list [][]byte, key, value interface{}) [][]byte {
+	var buf bytes.Buffer
+	writeKey(&buf, key)
+	n := buf.Len()
+	WriteValue(unquoted{&buf}, value)
+	b := buf.Bytes()
+	return append(list, b[:n:n], b[n:])
+}
+
+// unquoted is a writer for values that are not quoted or escaped.
+type unquoted struct {
+	*bytes.Buffer
+}
+
+// WriteBytesKeyValue writes a key/value pair to the writer. It is
+// equivalent to WriteKeyValue, but avoids the memory allocation
+// required to convert the byte slices to interface values.
+func WriteBytesKeyValue(buf Writer, key, value []byte) {
+	writeBytesKey(buf, key)
+	buf.WriteRune('=')
+	writeBytesValue(buf, value)
+}
+
 func writeKey(buf Writer, value interface{}) {
 	switch v := value.(type) {
 	case nil:
@@ -57,23 +148,21 @@ func writeKey(buf Writer, value interface{}) {
 	case bool, byte, int8, int16, uint16, int32, uint32, int64, uint64, int, uint, uintptr, float32, float64, complex64, complex128:
 		fmt.Fprint(buf, v)
 		return
+	}
+	if IsNilPointer(value) {
+		buf.Write(bytesNull)
+		return
+	}
+	switch v := value.(type) {
 	case encoding.TextMarshaler:
 		writeTextMarshalerKey(buf, v)
-		return
 	case error:
 		writeStringKey(buf, v.Error())
-		return
 	case fmt.Stringer:
 		writeStringKey(buf, v.String())
-		return
 	default:
 		// handle pointer to any of the above
-		rv := reflect.ValueOf(value)
-		if rv.Kind() == reflect.Ptr {
-			if rv.IsNil() {
-				buf.Write(bytesNull)
-				return
-			}
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr {
 			writeKey(buf, rv.Elem().Interface())
 			return
 		}
@@ -146,12 +235,16 @@ func recoverFromPanic(buf Writer) {
 
 // WriteValue writes the value to the writer.
 func WriteValue(buf Writer, value interface{}) {
+	if format := Formatter(value); format != nil {
+		writeFormattedValue(buf, format, value)
+		return
+	}
 	switch v := value.(type) {
 	case nil:
 		writeBytesValue(buf, bytesNull)
 		return
 	case []byte:
-		writeBytesValue(buf, v)
+		writeByteSliceValue(buf, v)
 		return
 	case string:
 		writeStringValue(buf, v)
@@ -159,35 +252,73 @@ func WriteValue(buf Writer, value interface{}) {
 	case bool, byte, int8, int16, uint16, int32, uint32, int64, uint64, int, uint, uintptr, float32, float64, complex64, complex128:
 		fmt.Fprint(buf, v)
 		return
+	case time.Time:
+		// RFC 3339 without the monotonic clock reading
+		writeStringValue(buf, v.Format(time.RFC3339Nano))
+		return
+	case time.Duration:
+		writeStringValue(buf, v.String())
+		return
+	}
+	if IsNilPointer(value) {
+		buf.Write(bytesNull)
+		return
+	}
+	switch v := value.(type) {
 	case encoding.TextMarshaler:
 		writeTextMarshalerValue(buf, v)
-		return
 	case error:
 		writeStringValue(buf, v.Error())
-		return
 	case fmt.Stringer:
 		writeStringValue(buf, v.String())
-		return
 	default:
-		// handle pointer to any of the above
 		rv := reflect.ValueOf(value)
-		if rv.Kind() == reflect.Ptr {
-			if rv.IsNil() {
-				buf.Write(bytesNull)
-				return
-			}
+		switch {
+		case rv.Kind() == reflect.Ptr:
+			// handle pointer to any of the above
 			WriteValue(buf, rv.Elem().Interface())
-			return
+		case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+			// named byte slice type, which fmt prints as "[104 105]"
+			writeByteSliceValue(buf, rv.Bytes())
+		default:
+			writeStringValue(buf, fmt.Sprint(value))
 		}
-		writeStringValue(buf, fmt.Sprint(value))
 	}
 }
 
+// IsNilPointer reports whether value is a nil pointer. Nil pointers are
+// written as null, so that methods are not called with a nil receiver.
+func IsNilPointer(value interface{}) bool {
+	rv := reflect.ValueOf(value)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// IsHexBytes reports whether the byte slice value b is written in hex,
+// which is the case if it is not valid UTF-8, or if byte slices are always
+// written in hex (see SetBytesHex). Empty byte slices are never written in hex.
+func IsHexBytes(b []byte) bool {
+	return len(b) > 0 && (atomic.LoadInt32(&bytesHex) != 0 || !utf8.Valid(b))
+}
+
+// writeByteSliceValue writes a byte slice value as text if it is valid
+// UTF-8, otherwise in hex. See SetBytesHex.
+func writeByteSliceValue(buf Writer, b []byte) {
+	if !IsHexBytes(b) {
+		writeBytesValue(buf, b)
+		return
+	}
+	buf.WriteString(hex.EncodeToString(b))
+}
+
 func writeBytesValue(buf Writer, b []byte) {
 	if b == nil {
 		buf.Write(bytesNull)
 		return
 	}
+	if _, ok := buf.(unquoted); ok {
+		buf.Write(b)
+		return
+	}
 	if len(b) == 0 {
 		buf.Write(bytesEmptyV)
 		return
@@ -220,6 +351,10 @@ func writeBytesValue(buf Writer, b []byte) {
 }
 
 func writeStringValue(buf Writer, s string) {
+	if _, ok := buf.(unquoted); ok {
+		buf.WriteString(s)
+		return
+	}
 	if s == "" {
 		buf.Write(bytesEmptyV)
 		return
@@ -251,6 +386,10 @@ func writeStringValue(buf Writer, s string) {
 	buf.WriteRune('"')
 }
 
+func writeFormattedValue(buf Writer, format func(interface{}) string, value interface{}) {
+	writeStringValue(buf, Format(format, value))
+}
+
 func writeTextMarshalerValue(buf Writer, t encoding.TextMarshaler) {
 	defer recoverFromPanic(buf)
 	b, err := t.MarshalText()
@@ -3,7 +3,11 @@ package logfmt
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestWriteKeyValue(t *testing.T) {
@@ -112,6 +116,41 @@ func TestWriteKeyValue(t *testing.T) {
 			value: func() *string { return nil }(),
 			want:  "null=null",
 		},
+		{ // value methods are not called with a nil receiver
+			key:   (*testStringer)(nil),
+			value: (*testTextMarshaler)(nil),
+			want:  "null=null",
+		},
+		{
+			key:   "key",
+			value: fmt.Stringer((*testStringer)(nil)),
+			want:  "key=null",
+		},
+		{ // other nil values are formatted by fmt
+			key:   "key",
+			value: (func())(nil),
+			want:  `key="<nil>"`,
+		},
+		{
+			key:   "key",
+			value: (chan int)(nil),
+			want:  `key="<nil>"`,
+		},
+		{
+			key:   "key",
+			value: map[string]int(nil),
+			want:  `key="map[]"`,
+		},
+		{
+			key:   "key",
+			value: []int(nil),
+			want:  `key="[]"`,
+		},
+		{
+			key:   "key",
+			value: true,
+			want:  "key=true",
+		},
 		{
 			key:   struct{ v int }{v: 25},
 			value: struct{ v int }{v: 17},
@@ -122,6 +161,16 @@ func TestWriteKeyValue(t *testing.T) {
 			value: "value:",
 			want:  `key="value:"`,
 		},
+		{
+			key:   "key",
+			value: time.Date(2009, 11, 10, 23, 0, 0, 5e6, time.UTC),
+			want:  `key="2009-11-10T23:00:00.005Z"`,
+		},
+		{
+			key:   "key",
+			value: 90 * time.Second,
+			want:  "key=1m30s",
+		},
 	}
 	for i, tt := range tests {
 		doTest := func(key interface{}, value interface{}, want string) {
@@ -146,6 +195,119 @@ func TestWriteKeyValue(t *testing.T) {
 			value := []byte(s)
 			doTest(tt.key, value, tt.want)
 		}
+		if key, ok := tt.key.(string); ok {
+			if value, ok := tt.value.(string); ok {
+				var buf bytes.Buffer
+				WriteBytesKeyValue(&buf, []byte(key), []byte(value))
+				if got, want := buf.String(), tt.want; got != want {
+					t.Errorf("%d: got `%s` want `%s`", i, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteValueTime(t *testing.T) {
+	var buf bytes.Buffer
+	WriteValue(&buf, time.Now())
+	if got := buf.String(); strings.Contains(got, "m=") {
+		t.Errorf("monotonic clock reading in %s", got)
+	}
+}
+
+func TestWriteValueBytes(t *testing.T) {
+	type raw []byte
+	defer SetBytesHex(false)
+	tests := []struct {
+		hex   bool
+		value interface{}
+		want  string
+	}{
+		{value: []byte("hi"), want: "hi"},
+		{value: []byte("hi there"), want: `"hi there"`},
+		{value: []byte{}, want: `""`},
+		{value: []byte(nil), want: "null"},
+		{value: []byte{0xff, 0x00, 0x68}, want: "ff0068"},
+		{value: raw("hi"), want: "hi"},
+		{value: raw{0xff, 0xfe}, want: "fffe"},
+		{value: &[]byte{0xbe, 0xef, 0xff}, want: "beefff"},
+		{hex: true, value: []byte("hi"), want: "6869"},
+		{hex: true, value: raw("hi"), want: "6869"},
+		{hex: true, value: []byte{}, want: `""`},
+		{hex: true, value: []byte(nil), want: "null"},
+	}
+	for tn, tt := range tests {
+		SetBytesHex(tt.hex)
+		var buf bytes.Buffer
+		WriteValue(&buf, tt.value)
+		if got, want := buf.String(), tt.want; got != want {
+			t.Errorf("%d: got `%s` want `%s`", tn, got, want)
+		}
+	}
+}
+
+func TestAppendKeyValue(t *testing.T) {
+	tests := []struct {
+		key   interface{}
+		value interface{}
+		want  []string
+	}{
+		{key: "key", value: "value", want: []string{"key", "value"}},
+		{key: "the key", value: "the \"value\"\n", want: []string{"the_key", "the \"value\"\n"}},
+		{key: "", value: "", want: []string{"EMPTY", ""}},
+		{key: nil, value: nil, want: []string{"null", "null"}},
+		{key: 17, value: 2.5, want: []string{"17", "2.5"}},
+		{key: "b", value: []byte("hi there"), want: []string{"b", "hi there"}},
+		{key: "b", value: []byte{0xff, 0x00}, want: []string{"b", "ff00"}},
+		{key: "d", value: time.Second, want: []string{"d", "1s"}},
+		{key: "e", value: errors.New("the error"), want: []string{"e", "the error"}},
+	}
+	for tn, tt := range tests {
+		list := AppendKeyValue([][]byte{[]byte("a"), []byte("1")}, tt.key, tt.value)
+		if got, want := len(list), 4; got != want {
+			t.Errorf("%d: got len=%d want %d", tn, got, want)
+			continue
+		}
+		if got, want := string(list[2]), tt.want[0]; got != want {
+			t.Errorf("%d: got key `%s` want `%s`", tn, got, want)
+		}
+		if got, want := string(list[3]), tt.want[1]; got != want {
+			t.Errorf("%d: got value `%s` want `%s`", tn, got, want)
+		}
+		if list[3] == nil {
+			t.Errorf("%d: got nil value", tn)
+		}
+	}
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	typ := reflect.TypeOf(testStringer(""))
+	defer RegisterFormatter(typ, nil)
+
+	tests := []struct {
+		format func(interface{}) string
+		want   string
+	}{
+		{
+			format: nil,
+			want:   "key=value",
+		},
+		{
+			format: func(v interface{}) string { return strings.ToUpper(string(v.(testStringer))) },
+			want:   "key=VALUE",
+		},
+		{
+			format: func(v interface{}) string { panic("formatter") },
+			want:   "key=PANIC",
+		},
+	}
+	for tn, tt := range tests {
+		RegisterFormatter(typ, tt.format)
+		var buf bytes.Buffer
+		WriteKeyValue(&buf, testStringer("key"), testStringer("value"))
+		if got, want := buf.String(), tt.want; got != want {
+			t.Errorf("%d: got `%s` want `%s`", tn, got, want)
+		}
 	}
 }
 
@@ -25,6 +25,7 @@ type lexer struct {
 	end   int    // end position of current lexeme
 	pos   int    // current position
 	token int    // current token
+	size  int    // size of the last rune read
 }
 
 func (lex *lexer) rewind() {
@@ -32,6 +33,7 @@ func (lex *lexer) rewind() {
 	lex.end = 0
 	lex.pos = 0
 	lex.token = 0
+	lex.size = 0
 	lex.next()
 }
 
@@ -41,6 +43,7 @@ func (lex *lexer) lexeme() []byte {
 
 func (lex *lexer) readRune() (rune, error) {
 	ch, size := utf8.DecodeRune(lex.input[lex.pos:])
+	lex.size = size
 	if size == 0 {
 		return 0, errEOF
 	}
@@ -51,11 +54,12 @@ func (lex *lexer) readRune() (rune, error) {
 	return ch, nil
 }
 
+// unreadRune moves back to the beginning of the last rune read. The size
+// of the rune is remembered, because decoding backwards from the current
+// position does not give the same result if the input is not valid UTF-8.
 func (lex *lexer) unreadRune() {
-	if lex.pos > 0 {
-		_, size := utf8.DecodeLastRune(lex.input[:lex.pos])
-		lex.pos -= size
-	}
+	lex.pos -= lex.size
+	lex.size = 0
 }
 
 func (lex *lexer) next() {
@@ -10,7 +10,8 @@ var (
 	messagePool = sync.Pool{
 		New: func() interface{} {
 			return &Message{
-				List: make([][]byte, 0, 16),
+				List:   make([][]byte, 0, 16),
+				Quoted: make([]bool, 0, 16),
 			}
 		},
 	}
@@ -19,9 +20,10 @@ var (
 // Message represents a message with text and any assocated
 // key/value pairs.
 type Message struct {
-	Text []byte   // message text
-	List [][]byte // key/value pairs
-	buf  [80]byte // for unquoting values
+	Text   []byte   // message text
+	List   [][]byte // key/value pairs
+	Quoted []bool   // reports whether each item in List was quoted
+	buf    [80]byte // for unquoting values
 }
 
 func newMessage() *Message {
@@ -33,6 +35,7 @@ func (m *Message) Release() {
 	if m != nil {
 		m.Text = nil
 		m.List = m.List[:0]
+		m.Quoted = m.Quoted[:0]
 		messagePool.Put(m)
 	}
 }
@@ -93,6 +96,9 @@ func Bytes(input []byte) *Message {
 		if cap(message.List) < kvCount {
 			message.List = make([][]byte, 0, kvCount)
 		}
+		if cap(message.Quoted) < kvCount {
+			message.Quoted = make([]bool, 0, kvCount)
+		}
 		var pos int
 		for lex.pos < firstKeyPos {
 			pos = lex.pos
@@ -102,9 +108,11 @@ func Bytes(input []byte) *Message {
 		for lex.match(tokKey, tokQuotedKey) {
 			if lex.token == tokKey {
 				message.List = append(message.List, lex.lexeme())
+				message.Quoted = append(message.Quoted, false)
 			} else {
 				unquoted, unquoteBuf = unquote(lex.lexeme(), unquoteBuf)
 				message.List = append(message.List, unquoted)
+				message.Quoted = append(message.Quoted, true)
 			}
 			lex.next()
 
@@ -112,8 +120,10 @@ func Bytes(input []byte) *Message {
 			case tokQuoted:
 				unquoted, unquoteBuf = unquote(lex.lexeme(), unquoteBuf)
 				message.List = append(message.List, unquoted)
+				message.Quoted = append(message.Quoted, true)
 			default:
 				message.List = append(message.List, lex.lexeme())
+				message.Quoted = append(message.Quoted, false)
 			}
 
 			lex.next()
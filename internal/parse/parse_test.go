@@ -3,6 +3,7 @@ package parse
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/jjeffery/kv/internal/logfmt"
@@ -162,6 +163,77 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
+		{ // keys can contain dots, slashes, dashes and colons
+			input: `request http.status=200 user/id=alice x-request-id=abc ns:key=1`,
+			msg: Message{
+				Text: b("request"),
+				List: [][]byte{
+					b("http.status"), b("200"),
+					b("user/id"), b("alice"),
+					b("x-request-id"), b("abc"),
+					b("ns:key"), b("1"),
+				},
+			},
+		},
+		{
+			input: `a.b=c x/y=z`,
+			msg: Message{
+				List: [][]byte{
+					b("a.b"), b("c"),
+					b("x/y"), b("z"),
+				},
+			},
+		},
+		{ // quoted values containing separators do not split the key
+			input: `msg http.url="/a/b?c=d e" db.query="select 1" "user.full name"=alice`,
+			msg: Message{
+				Text: b("msg"),
+				List: [][]byte{
+					b("http.url"), b("/a/b?c=d e"),
+					b("db.query"), b("select 1"),
+					b("user.full name"), b("alice"),
+				},
+			},
+		},
+		{ // quoted values are opaque until the closing quote
+			input: `search query="a=b&c=d" filter="x=\"y z\"" n=1`,
+			msg: Message{
+				Text: b("search"),
+				List: [][]byte{
+					b("query"), b("a=b&c=d"),
+					b("filter"), b(`x="y z"`),
+					b("n"), b("1"),
+				},
+			},
+		},
+		{ // an unterminated quote continues to the end of the input
+			input: `msg a="unterminated rest b=2`,
+			msg: Message{
+				Text: b("msg"),
+				List: [][]byte{
+					b("a"), b("unterminated rest b=2"),
+				},
+			},
+		},
+		{ // invalid escape sequences are left as-is
+			input: `msg a="x\q" b="\"unterminated\\\"`,
+			msg: Message{
+				Text: b("msg"),
+				List: [][]byte{
+					b("a"), b(`x\q`),
+					b("b"), b(`"unterminated\"`),
+				},
+			},
+		},
+		{
+			input: `msg a="`,
+			msg: Message{
+				Text: b("msg"),
+				List: [][]byte{
+					b("a"), b(""),
+				},
+			},
+		},
 		{ // empty input
 			input: ``,
 			msg:   Message{},
@@ -243,9 +315,9 @@ func TestUnquote(t *testing.T) {
 		},
 		{
 			input:    b(`"invalid\"`),
-			unquoted: "???",
+			unquoted: `invalid"`,
 			before:   8,
-			after:    8,
+			after:    0,
 		},
 	}
 	for tn, tt := range tests {
@@ -302,3 +374,52 @@ func benchmarkParseBytes(input []byte, b *testing.B) {
 		msg.Release()
 	}
 }
+
+func TestQuotedRoundTrip(t *testing.T) {
+	tests := []string{
+		``,
+		`a=b&c=d`,
+		`a="b"`,
+		`"quoted"`,
+		`\"escaped\"`,
+		`back\slash\`,
+		`trailing=`,
+		`==`,
+		`nested "a=\"b\"" quotes`,
+		"tab\tand\nnewline",
+		`key: value`,
+	}
+
+	// add values generated from characters that are significant to the parser
+	const alphabet = "ab =\"\\:\t\nü"
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		var value []rune
+		for n := rnd.Intn(12); n >= 0; n-- {
+			value = append(value, []rune(alphabet)[rnd.Intn(len([]rune(alphabet)))])
+		}
+		tests = append(tests, string(value))
+	}
+
+	for tn, value := range tests {
+		var buf bytes.Buffer
+		buf.WriteString("text ")
+		logfmt.WriteKeyValue(&buf, b("query"), b(value))
+		buf.WriteRune(' ')
+		logfmt.WriteKeyValue(&buf, b("next"), b("1"))
+		input := buf.String()
+
+		msg := Bytes(buf.Bytes())
+		want := &Message{
+			Text: b("text"),
+			List: [][]byte{
+				b("query"), b(value),
+				b("next"), b("1"),
+			},
+		}
+		if !msgEqual(msg, want) {
+			t.Errorf("%d: input=%q\n got=%v\nwant=%v", tn, input, msg, want)
+		}
+		msg.Release()
+	}
+}
@@ -0,0 +1,69 @@
+package parse
+
+import "strconv"
+
+// Typed returns the int, float64 or bool represented by v, or v as
+// a string if it does not represent one of these types. The rules
+// are documented in kv.ParseTyped.
+func Typed(v []byte) interface{} {
+	s := string(v)
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	n := digits(s[i:])
+	if n == 0 || (n > 1 && s[i] == '0') {
+		return s
+	}
+	i += n
+	if i == len(s) {
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+		return s
+	}
+	if s[i] != '.' {
+		return s
+	}
+	i++
+	n = digits(s[i:])
+	if n == 0 {
+		return s
+	}
+	i += n
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		n = digits(s[i:])
+		if n == 0 {
+			return s
+		}
+		i += n
+	}
+	if i != len(s) {
+		return s
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		// out of range
+		return s
+	}
+	return f
+}
+
+// digits returns the number of decimal digits at the start of s.
+func digits(s string) int {
+	var n int
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
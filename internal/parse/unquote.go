@@ -10,17 +10,20 @@ import (
 // unquote the input. If possible the unquoted value points to the same
 // backing array as input. Otherwise it points to buf. The remainder is
 // the unused portion of buf.
+//
+// If the input is not terminated by a quote, the rest of the input after
+// the opening quote is unquoted. If the input contains an invalid escape
+// sequence, the unquoted value is the input without quotes, unchanged.
 func unquote(input []byte, buf []byte) (unquoted []byte, remainder []byte) {
 	var (
 		errorIndicator = []byte("???")
 	)
-	if len(input) < 2 {
+	if len(input) == 0 {
 		return errorIndicator, buf
-
 	}
 	quote := input[0]
 	input = input[1:]
-	if input[len(input)-1] == quote {
+	if terminated(input, quote) {
 		input = input[:len(input)-1]
 	}
 	index := bytes.IndexRune(input, '\\')
@@ -37,7 +40,7 @@ func unquote(input []byte, buf []byte) (unquoted []byte, remainder []byte) {
 	for len(strinput) > 0 {
 		r, mb, tail, err := strconv.UnquoteChar(strinput, quote)
 		if err != nil {
-			return errorIndicator, buf
+			return input, buf
 		}
 		strinput = tail
 		if mb {
@@ -63,6 +66,20 @@ func unquote(input []byte, buf []byte) (unquoted []byte, remainder []byte) {
 	return unquoted, remainder
 }
 
+// terminated reports whether input ends with a quote
+// that is not escaped by a backslash.
+func terminated(input []byte, quote byte) bool {
+	n := len(input)
+	if n == 0 || input[n-1] != quote {
+		return false
+	}
+	var backslashes int
+	for i := n - 2; i >= 0 && input[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 0
+}
+
 func toString(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
 }
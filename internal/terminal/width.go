@@ -0,0 +1,86 @@
+package terminal
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/width"
+)
+
+// RuneWidth returns the number of columns used to display
+// the rune on a terminal. Wide and full-width runes, such as
+// CJK ideographs, occupy two columns. Combining marks and
+// format characters, such as the zero width joiner, occupy none.
+func RuneWidth(r rune) int {
+	if r < utf8.RuneSelf {
+		return 1
+	}
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// Width returns the number of columns used to display b on a terminal.
+// ANSI escape sequences, such as those that set colors, occupy no columns.
+func Width(b []byte) int {
+	var c counter
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		c.add(r)
+		b = b[size:]
+	}
+	return c.n
+}
+
+// StringWidth returns the number of columns used to display s on a terminal.
+// ANSI escape sequences, such as those that set colors, occupy no columns.
+func StringWidth(s string) int {
+	var c counter
+	for _, r := range s {
+		c.add(r)
+	}
+	return c.n
+}
+
+// counter counts the columns used to display a sequence of runes,
+// skipping over escape sequences.
+type counter struct {
+	n     int
+	state int // escNone, escStart or escCSI
+}
+
+const (
+	escNone  = iota // not in an escape sequence
+	escStart        // after the escape character
+	escCSI          // inside a control sequence, eg "\x1b[0;31m"
+)
+
+func (c *counter) add(r rune) {
+	switch c.state {
+	case escStart:
+		if r == '[' {
+			c.state = escCSI
+		} else {
+			// two character escape sequence
+			c.state = escNone
+		}
+		return
+	case escCSI:
+		// parameter and intermediate bytes continue the
+		// sequence until the final byte
+		if r >= 0x40 && r <= 0x7e {
+			c.state = escNone
+		}
+		return
+	}
+	if r == 0x1b {
+		c.state = escStart
+		return
+	}
+	c.n += RuneWidth(r)
+}
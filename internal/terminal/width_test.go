@@ -0,0 +1,31 @@
+package terminal
+
+import "testing"
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{s: "", want: 0},
+		{s: "hello", want: 5},
+		{s: "€250.00", want: 7},
+		{s: "日本語", want: 6},
+		{s: "ｈｅｌｌｏ", want: 10},
+		{s: "\U0001f600", want: 2},
+		{s: "e\u0301", want: 1},
+		{s: "a\u200db", want: 2},
+		{s: "\x1b[0;31merror\x1b[0m", want: 5},
+		{s: "\x1b[7m日本\x1b[27m語", want: 6},
+		{s: "\x1b[38;5;196mx", want: 1},
+		{s: "\x1b7a\x1b8", want: 1},
+	}
+	for tn, tt := range tests {
+		if got, want := StringWidth(tt.s), tt.want; got != want {
+			t.Errorf("%d: %q: got=%v, want=%v", tn, tt.s, got, want)
+		}
+		if got, want := Width([]byte(tt.s)), tt.want; got != want {
+			t.Errorf("%d: %q: got=%v, want=%v", tn, tt.s, got, want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package wrap prints text and key/value pairs on lines of limited width.
+// It is shared by the kvlog terminal printer and kv.List.Pretty, so that
+// both wrap lines in the same way.
+package wrap
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jjeffery/kv/internal/terminal"
+)
+
+// Printer prints on lines of limited width. It keeps track of the
+// current column, and of the indent for continuation lines.
+type Printer interface {
+	// Column returns the current column.
+	Column() int
+
+	// LineStart reports whether nothing has been printed
+	// on the current line, other than a header or indent.
+	LineStart() bool
+
+	// CanWrap reports whether starting a new line would leave more
+	// room for the next item. This is false at the beginning of a line,
+	// which ensures that at least one item is printed on each line.
+	CanWrap() bool
+
+	// TabWidth returns the distance between tab stops,
+	// or zero if tabs are printed as a single space.
+	TabWidth() int
+
+	// Newline starts a continuation line.
+	Newline()
+
+	// Space prints n columns of white space.
+	Space(n int)
+
+	// Word prints a word.
+	Word(b []byte)
+
+	// Punct prints a punctuation rune that ends a word.
+	Punct(r rune)
+
+	// Escape prints an escape sequence that occupies no columns.
+	Escape(b []byte)
+
+	// PairWidth returns the number of columns used
+	// on the current line to print a key/value pair.
+	PairWidth(key, value []byte) int
+
+	// Pair prints a key/value pair.
+	Pair(key, value []byte)
+}
+
+// Text prints text, wrapping lines that would be wider than width.
+// Text is wrapped at white space, or after a comma or other punctuation
+// that ends a very long word.
+func Text(p Printer, text []byte, width int) {
+	// white space before an escape sequence that is not part of a word,
+	// which is printed before the next word instead
+	var pendingWS []byte
+
+	for in := text; len(in) > 0; {
+		var wsLen, bsLen, punctLen int
+		ws := in[:scan(in, isWhiteSpace)]
+		in = in[len(ws):]
+
+		// Embedded newlines are hard line breaks. Only the white space
+		// following the last newline is printed, which preserves the
+		// indentation of lines in stack traces and the like.
+		var breaks int
+		if i := bytes.LastIndexByte(ws, '\n'); i >= 0 {
+			breaks = bytes.Count(ws, []byte{'\n'})
+			ws = ws[i+1:]
+		}
+
+		bs := in[:scan(in, isBlackSpace)]
+		if len(bs) > 0 {
+			in = in[len(bs):]
+			bsLen = terminal.Width(bs)
+		}
+
+		// The black space scan will terminate before punctuation to handle very long
+		// strings with no spaces but possibly punctuation. Detect if it has terminated
+		// before punctuation, and if so include the run of punctuation on the same line,
+		// so that a sequence like ")," or ",." is never split across lines.
+		punct := in[:scanPunct(in)]
+		hasPunct := len(punct) > 0
+		if hasPunct {
+			in = in[len(punct):]
+			punctLen = terminal.Width(punct)
+		}
+
+		if len(bs) == 0 && !hasPunct {
+			// trailing white space
+			continue
+		}
+
+		for i := 0; i < breaks; i++ {
+			p.Newline()
+		}
+		if bsLen == 0 && !hasPunct && bytes.IndexByte(bs, 0x1b) >= 0 {
+			// An escape sequence, such as a color, that was in the
+			// message text. It occupies no columns, so it is printed
+			// as-is, and never causes a line break.
+			p.Escape(bs)
+			if len(ws) > 0 {
+				pendingWS = ws
+			}
+			continue
+		}
+		if len(ws) == 0 {
+			ws = pendingWS
+		}
+		pendingWS = nil
+		if len(ws) > 0 {
+			wsLen = spaceWidth(ws, p.Column(), p.TabWidth())
+		}
+
+		if bsLen+wsLen+punctLen+p.Column() > width && p.CanWrap() {
+			p.Newline()
+		} else if wsLen > 0 {
+			p.Space(wsLen)
+		}
+		p.Word(bs)
+		for _, r := range string(punct) {
+			p.Punct(r)
+		}
+	}
+}
+
+// Pairs prints key/value pairs separated by a space, wrapping lines
+// that would be wider than width. A key/value pair is never split
+// across lines.
+func Pairs(p Printer, list [][]byte, width int) {
+	for i := 0; i+1 < len(list); i += 2 {
+		key, value := list[i], list[i+1]
+		var wsLen int
+		if !p.LineStart() {
+			wsLen = 1
+		}
+		if p.PairWidth(key, value)+wsLen+p.Column() > width && p.CanWrap() {
+			p.Newline()
+			wsLen = 0
+		}
+		if wsLen > 0 {
+			p.Space(wsLen)
+		}
+		p.Pair(key, value)
+	}
+}
+
+// spaceWidth returns the number of columns used to print the white
+// space ws at column col. White space is collapsed to a single space,
+// unless it contains tabs and tabWidth is positive, in which case each
+// tab is expanded to the next tab stop.
+func spaceWidth(ws []byte, col int, tabWidth int) int {
+	if tabWidth <= 0 || bytes.IndexByte(ws, '\t') < 0 {
+		return 1
+	}
+	start := col
+	for _, c := range string(ws) {
+		if c == '\t' {
+			col += tabWidth - col%tabWidth
+		} else {
+			col++
+		}
+	}
+	return col - start
+}
+
+// scan returns the length of the longest prefix of b
+// consisting of bytes for which fn reports true.
+func scan(b []byte, fn func(byte) bool) int {
+	for i, c := range b {
+		if !fn(c) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// scanPunct returns the length of the run of punctuation at the start of b
+// that ends the preceding word. This is a comma, full stop or other terminal
+// punctuation, or a closing bracket or quote. Opening brackets and quotes,
+// and other punctuation, are part of the following word.
+func scanPunct(b []byte) int {
+	var n int
+	for n < len(b) {
+		r, size := utf8.DecodeRune(b[n:])
+		if !isClosingPunct(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// isClosingPunct reports whether r is punctuation that ends a word.
+func isClosingPunct(r rune) bool {
+	switch r {
+	case ',', '.', ';', ':', '!', '?':
+		return true
+	}
+	return unicode.In(r, unicode.Pe, unicode.Pf)
+}
+
+// isWhiteSpace reports whether c is white space. This matches the
+// `\s` character class in regular expressions, which is restricted
+// to ASCII, so it is safe to scan UTF-8 text one byte at a time.
+func isWhiteSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// isBlackSpace reports whether c is part of a word for the purposes
+// of line wrapping. Words are terminated by white space or a comma.
+func isBlackSpace(c byte) bool {
+	return c != ',' && !isWhiteSpace(c)
+}
@@ -0,0 +1,79 @@
+package wrap
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	// scanning must match the regular expressions it replaced
+	whiteSpaceRE := regexp.MustCompile(`^\s+`)
+	blackSpaceRE := regexp.MustCompile(`^[^\s,]+`)
+	inputs := []string{
+		"",
+		"word",
+		"  \t\r\n\fword",
+		"word, more",
+		",,,",
+		"\v\u00a0\u0085\u2003 word",
+		"日本語 words",
+		"€250.00,\tnext",
+	}
+	for tn, input := range inputs {
+		for in := []byte(input); len(in) > 0; in = in[1:] {
+			if got, want := scan(in, isWhiteSpace), len(whiteSpaceRE.Find(in)); got != want {
+				t.Errorf("%d: %q: got=%v, want=%v", tn, in, got, want)
+			}
+			if got, want := scan(in, isBlackSpace), len(blackSpaceRE.Find(in)); got != want {
+				t.Errorf("%d: %q: got=%v, want=%v", tn, in, got, want)
+			}
+		}
+	}
+}
+
+func TestScanPunct(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"", 0},
+		{"word", 0},
+		{" ,", 0},
+		{",", 1},
+		{",.", 2},
+		{")),", 3},
+		{",)x", 2},
+		{",)1", 2},
+		{", more", 1},
+		{",\x1b[0m", 1},
+		{`,"quoted"`, 1},
+		{",(x)", 1},
+		{",/path", 1},
+		{",»", 3},
+	}
+	for tn, tt := range tests {
+		if got, want := scanPunct([]byte(tt.input)), tt.want; got != want {
+			t.Errorf("%d: %q: got=%v, want=%v", tn, tt.input, got, want)
+		}
+	}
+}
+
+func TestSpaceWidth(t *testing.T) {
+	tests := []struct {
+		ws       string
+		col      int
+		tabWidth int
+		want     int
+	}{
+		{ws: "   ", col: 0, tabWidth: 8, want: 1},
+		{ws: "\t", col: 0, tabWidth: 0, want: 1},
+		{ws: "\t", col: 0, tabWidth: 8, want: 8},
+		{ws: "\t", col: 5, tabWidth: 8, want: 3},
+		{ws: " \t\t", col: 7, tabWidth: 4, want: 9},
+	}
+	for tn, tt := range tests {
+		if got, want := spaceWidth([]byte(tt.ws), tt.col, tt.tabWidth), tt.want; got != want {
+			t.Errorf("%d: got=%d, want=%d", tn, got, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package kvlog
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Default values for a buffered writer.
+const (
+	defaultBufferSize    = 32 * 1024
+	defaultFlushInterval = time.Second
+)
+
+// BufferedWriter is a Writer that accumulates printed messages in memory,
+// and writes them to the output writer in batches. This reduces the number
+// of write calls, which is useful when printing a large number of messages
+// to a network connection or a file.
+//
+// The buffered messages are written when adding a message would exceed the
+// buffer size, when the flush interval has elapsed since the first message
+// was buffered, and when Flush or Close is called. Each line is written to
+// the output writer in its entirety: a line is never split between two
+// writes.
+type BufferedWriter struct {
+	*Writer
+	buf *lineBuffer
+}
+
+// NewBufferedWriter returns a buffered writer that prints messages using w,
+// and buffers the output that would have been written to w's output writer.
+// Options set on w continue to apply. The buffer holds size bytes, and is
+// flushed interval after the first message is buffered. If size or interval
+// is zero, a default value is used (32KiB and one second respectively). If
+// interval is negative, the buffer is only flushed when full, or when Flush
+// or Close is called.
+//
+// Messages printed to w after calling NewBufferedWriter are buffered,
+// including after the output writer is changed with SetOutput, which
+// flushes any buffered messages to the previous output writer.
+func NewBufferedWriter(w *Writer, size int, interval time.Duration) *BufferedWriter {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	if interval == 0 {
+		interval = defaultFlushInterval
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	b := &lineBuffer{
+		out:      w.out,
+		size:     size,
+		interval: interval,
+	}
+	w.out = b
+	w.setPrinter()
+	return &BufferedWriter{
+		Writer: w,
+		buf:    b,
+	}
+}
+
+// Flush writes any buffered messages to the output writer.
+func (w *BufferedWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// lineBuffer is an io.Writer that accumulates the lines printed
+// by a writer's printer. Each call to Write is kept intact, so
+// it is never split across writes to the output writer.
+type lineBuffer struct {
+	mutex    sync.Mutex    // controls exclusive access
+	out      io.Writer     // output writer
+	buf      []byte        // buffered lines
+	size     int           // buffer size
+	interval time.Duration // time to wait before flushing, or negative
+	timer    *time.Timer   // flushes after interval, or nil
+	err      error         // error from a timed flush, reported by the next write
+}
+
+func (b *lineBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err := b.err
+	b.err = nil
+	if len(b.buf) > 0 && len(b.buf)+len(p) > b.size {
+		if ferr := b.flush(); err == nil {
+			err = ferr
+		}
+	}
+	if len(p) >= b.size {
+		// too large to buffer
+		if _, werr := b.out.Write(p); err == nil {
+			err = werr
+		}
+		return len(p), err
+	}
+	b.buf = append(b.buf, p...)
+	if b.timer == nil && b.interval > 0 {
+		b.timer = time.AfterFunc(b.interval, b.timedFlush)
+	}
+	return len(p), err
+}
+
+// Flush writes any buffered lines to the output writer.
+func (b *lineBuffer) Flush() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err := b.flush()
+	if err == nil {
+		err = b.err
+	}
+	b.err = nil
+	return err
+}
+
+// Close flushes any buffered lines, and closes the output writer.
+func (b *lineBuffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err := b.flush()
+	if cerr := closeOutput(b.out); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Unwrap returns the output writer, so that IsTerminal
+// reports whether the output writer is a terminal.
+func (b *lineBuffer) Unwrap() io.Writer {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.out
+}
+
+func (b *lineBuffer) setOutput(out io.Writer) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.err = b.flush()
+	b.out = out
+}
+
+func (b *lineBuffer) timedFlush() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.flush(); err != nil {
+		b.err = err
+	}
+}
+
+// flush is called with the mutex locked.
+func (b *lineBuffer) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.buf) == 0 {
+		return nil
+	}
+	_, err := b.out.Write(b.buf)
+	b.buf = b.buf[:0]
+	return err
+}
@@ -0,0 +1,219 @@
+package kvlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chunkWriter records each write to the output writer.
+type chunkWriter struct {
+	mutex  sync.Mutex
+	chunks []string
+	closed bool
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.chunks = append(w.chunks, string(p))
+	return len(p), nil
+}
+
+func (w *chunkWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *chunkWriter) Chunks() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]string(nil), w.chunks...)
+}
+
+func TestBufferedWriter(t *testing.T) {
+	tests := []struct {
+		size   int
+		input  []string
+		chunks []string
+	}{
+		{
+			size:   100,
+			input:  []string{"one", "two a=1", "three"},
+			chunks: []string{"one\ntwo a=1\nthree\n"},
+		},
+		{
+			size:   10,
+			input:  []string{"one", "two", "three", "four"},
+			chunks: []string{"one\ntwo\n", "three\n", "four\n"},
+		},
+		{ // lines larger than the buffer are written immediately
+			size:   10,
+			input:  []string{"one", "message longer than buffer", "two"},
+			chunks: []string{"one\n", "message longer than buffer\n", "two\n"},
+		},
+	}
+
+	for tn, tt := range tests {
+		var out chunkWriter
+		w := NewBufferedWriter(NewWriter(&out), tt.size, -1)
+		logger := log.New(ioutil.Discard, "", 0)
+		w.Attach(logger)
+		for _, s := range tt.input {
+			logger.Println(s)
+		}
+		if err := w.Flush(); err != nil {
+			t.Errorf("%d: %v", tn, err)
+		}
+		if got, want := strings.Join(out.Chunks(), "|"), strings.Join(tt.chunks, "|"); got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestBufferedWriterInterval(t *testing.T) {
+	var out chunkWriter
+	w := NewBufferedWriter(NewWriter(&out), 0, 10*time.Millisecond)
+	logger := log.New(ioutil.Discard, "", 0)
+	w.Attach(logger)
+	logger.Println("message a=1")
+
+	for deadline := time.Now().Add(5 * time.Second); len(out.Chunks()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("buffer not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := strings.Join(out.Chunks(), "|"), "message a=1\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestBufferedWriterClose(t *testing.T) {
+	var out chunkWriter
+	w := NewBufferedWriter(NewWriter(&out), 0, -1)
+	logger := log.New(ioutil.Discard, "", 0)
+	w.Attach(logger)
+	logger.Println("message")
+	if got := out.Chunks(); len(got) != 0 {
+		t.Errorf("got=%q, want nothing", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(out.Chunks(), "|"), "message\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+	if !out.closed {
+		t.Error("output writer not closed")
+	}
+}
+
+func TestBufferedWriterSetOutput(t *testing.T) {
+	var out1, out2 chunkWriter
+	base := NewWriter(&out1)
+	w := NewBufferedWriter(base, 0, -1)
+	logger := log.New(ioutil.Discard, "", 0)
+	w.Attach(logger)
+	logger.Println("one")
+
+	// setting the output of the base writer flushes the buffer,
+	// and subsequent messages continue to be buffered
+	base.SetOutput(&out2)
+	logger.Println("two")
+	logger.Println("three")
+	if got, want := strings.Join(out1.Chunks(), "|"), "one\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+	if got := out2.Chunks(); len(got) != 0 {
+		t.Errorf("got=%q, want nothing", got)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(out2.Chunks(), "|"), "two\nthree\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestBufferedWriterConcurrent(t *testing.T) {
+	var out chunkWriter
+	w := NewBufferedWriter(NewWriter(&out), 256, time.Millisecond)
+	logger := log.New(ioutil.Discard, "", 0)
+	w.Attach(logger)
+
+	const goroutines, messages = 8, 100
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				logger.Printf("message g=%d i=%d", g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines int
+	for _, chunk := range out.Chunks() {
+		if !strings.HasSuffix(chunk, "\n") {
+			t.Errorf("line split across writes: %q", chunk)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(chunk, "\n"), "\n") {
+			var g, i int
+			if _, err := fmt.Sscanf(line, "message g=%d i=%d", &g, &i); err != nil {
+				t.Errorf("unexpected line %q: %v", line, err)
+			}
+			lines++
+		}
+	}
+	if got, want := lines, goroutines*messages; got != want {
+		t.Errorf("got=%d lines, want=%d", got, want)
+	}
+}
+
+func BenchmarkBufferedWriter(b *testing.B) {
+	const burst = 10000
+	input := []byte("2099/12/31 12:34:56 info: message a=1 b=\"value 2\" c=3\n")
+	benchmarks := []struct {
+		name     string
+		buffered bool
+	}{
+		{"unbuffered", false},
+		{"buffered", true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			if err != nil {
+				b.Skip(err)
+			}
+			defer out.Close()
+			w := NewWriter(out)
+			w.NoWrap()
+			flush := func() error { return nil }
+			if bm.buffered {
+				flush = NewBufferedWriter(w, 0, -1).Flush
+			}
+			lw := newLogWriter(w, log.New(ioutil.Discard, "", log.LstdFlags))
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				for i := 0; i < burst; i++ {
+					lw.Write(input)
+				}
+				flush()
+			}
+		})
+	}
+}
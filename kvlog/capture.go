@@ -0,0 +1,59 @@
+package kvlog
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Capture is a Writer that prints messages to an in-memory buffer. It is
+// intended for tests that verify the output of code that logs messages.
+//
+// Messages are formatted as if printed to a terminal with a fixed
+// width and without color, so the output is the same regardless of
+// the environment in which the test is run.
+type Capture struct {
+	*Writer
+	buf bytes.Buffer
+}
+
+// NewCapture returns a capture writer that formats messages for
+// a terminal that is width columns wide. Use the Attach method
+// to set the capture writer as the output of a logger.
+func NewCapture(width int) *Capture {
+	c := &Capture{}
+	c.Writer = NewWriter(&c.buf)
+	c.Writer.mutex.Lock()
+	c.Writer.opts.color = colorNever
+	c.Writer.opts.profile = ProfileTrueColor
+	c.Writer.opts.width = width
+	c.Writer.setPrinter()
+	c.Writer.mutex.Unlock()
+	return c
+}
+
+// String returns the text printed since the capture writer was
+// created, or since the Reset method was last called.
+func (c *Capture) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.buf.String()
+}
+
+// Lines returns the lines printed since the capture writer was
+// created, or since the Reset method was last called. A message
+// that is wrapped over multiple lines results in multiple lines.
+// Line endings are not included.
+func (c *Capture) Lines() []string {
+	text := strings.TrimSuffix(c.String(), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// Reset discards any captured text.
+func (c *Capture) Reset() {
+	c.mutex.Lock()
+	c.buf.Reset()
+	c.mutex.Unlock()
+}
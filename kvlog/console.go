@@ -0,0 +1,174 @@
+package kvlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsoleWriter is a Writer that accepts messages in JSON format, such
+// as those written by the zerolog and zap logging packages, and prints
+// them in the same format as messages from a standard library logger.
+// This provides a consistent, human-readable format for programs that
+// use a mixture of logging packages.
+//
+// Each line written is expected to be a JSON object. The message text
+// is taken from the "message" or "msg" field, the level from the
+// "level" or "lvl" field, the time from the "time", "ts" or "timestamp"
+// field, and the file name and line number from the "caller" field.
+// All other fields are printed as key/value pairs, in the order in
+// which they appear. Lines that are not JSON objects are printed as
+// if they were written by a standard library logger without a prefix
+// or flags.
+type ConsoleWriter struct {
+	*Writer
+}
+
+// NewConsoleWriter returns a console writer that prints messages to w.
+func NewConsoleWriter(w io.Writer) *ConsoleWriter {
+	return &ConsoleWriter{
+		Writer: NewWriter(w),
+	}
+}
+
+// consoleLevels maps levels used by other logging packages
+// to the equivalent default level.
+var consoleLevels = map[string]string{
+	"warn":   "warning",
+	"dpanic": "fatal",
+	"panic":  "fatal",
+}
+
+// Write implements the io.Writer interface. Each line in p is
+// printed as a separate message. If a line cannot be printed, Write
+// returns the error and the number of bytes in the lines before it.
+func (c *ConsoleWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	var n int // length of the lines printed
+	for n < len(p) {
+		line := p[n:]
+		size := len(line)
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, size = line[:i], i+1
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			hdr := &logEntry{Timestamp: now}
+			text, ok := parseConsoleLine(line, hdr)
+			if !ok {
+				text = line
+			}
+			c.mutex.Lock()
+			err := c.writeEntry(hdr, text, nil)
+			c.mutex.Unlock()
+			if err != nil {
+				return n, err
+			}
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// parseConsoleLine parses a line containing a JSON object. It returns
+// the message text, including any level, and populates the header
+// with the time, caller, and the remaining fields as key/value pairs.
+// It reports false if the line is not a JSON object.
+func parseConsoleLine(line []byte, hdr *logEntry) (text []byte, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+	var level, msg string
+	var list [][]byte
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, false
+		}
+		value := consoleValue(raw)
+		switch key {
+		case "message", "msg":
+			if msg == "" {
+				msg = string(value)
+				continue
+			}
+		case "level", "lvl":
+			if level == "" {
+				level = strings.ToLower(string(value))
+				continue
+			}
+		case "time", "ts", "timestamp":
+			if tm, ok := consoleTime(raw); ok && hdr.Time == nil {
+				hdr.Timestamp = tm
+				hdr.Time = []byte(tm.Format("15:04:05"))
+				continue
+			}
+		case "caller":
+			if hdr.File == nil {
+				hdr.File = value
+				continue
+			}
+		}
+		list = append(list, []byte(key), value)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, false
+	}
+	if l, ok := consoleLevels[level]; ok {
+		level = l
+	}
+	if level != "" {
+		msg = level + ": " + msg
+	}
+	hdr.List = list
+	return []byte(msg), true
+}
+
+// consoleValue returns the value of a JSON field. Strings are unquoted,
+// and other values, including objects and arrays, are returned as JSON.
+func consoleValue(raw json.RawMessage) []byte {
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return []byte(s)
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
+
+// consoleTime returns the time represented by a JSON value, which can be
+// a string in RFC 3339 format, or a number of seconds since the Unix epoch.
+// The time is returned in the local time zone.
+func consoleTime(raw json.RawMessage) (time.Time, bool) {
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return time.Time{}, false
+		}
+		tm, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return tm.Local(), true
+	}
+	secs, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil || math.IsInf(secs, 0) || math.IsNaN(secs) {
+		return time.Time{}, false
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))).Local(), true
+}
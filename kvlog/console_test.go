@@ -0,0 +1,83 @@
+package kvlog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestConsoleWriter(t *testing.T) {
+	tm := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+	hms := tm.Local().Format("15:04:05")
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{ // zerolog
+			input:  `{"level":"info","service":"api","port":8080,"time":"2009-11-10T23:00:00Z","message":"server started"}` + "\n",
+			output: hms + " info: server started service=api port=8080\n",
+		},
+		{ // zap
+			input:  `{"level":"warn","ts":1257894000,"caller":"main.go:23","msg":"slow request","elapsed":1.5,"ok":false}`,
+			output: hms + " main.go:23: warning: slow request elapsed=1.5 ok=false\n",
+		},
+		{
+			input:  `{"level":"error","error":"not found","tags":["a", "b"],"user":{"id": 1},"message":"cannot load"}`,
+			output: "error: cannot load error=not found tags=[\"a\",\"b\"] user={\"id\":1}\n",
+		},
+		{ // no message text
+			input:  `{"level":"debug","event":"startup"}`,
+			output: "debug: event=startup\n",
+		},
+		{ // multiple lines
+			input:  "{\"message\":\"one\"}\n{\"message\":\"two\",\"a\":null}\n",
+			output: "one\ntwo a=null\n",
+		},
+		{ // not JSON
+			input:  "plain text a=1",
+			output: "plain text a=1\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewConsoleWriter(&buf)
+		output.SetVerbose(true)
+		output.printer = &terminalPrinter{
+			w:       &buf,
+			width:   func() int { return 120 },
+			nocolor: true,
+		}
+		n, err := output.Write([]byte(tt.input))
+		if err != nil {
+			t.Errorf("%d: %v", tn, err)
+		}
+		if got, want := n, len(tt.input); got != want {
+			t.Errorf("%d: got=%d, want=%d", tn, got, want)
+		}
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestConsoleWriterClosed(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewConsoleWriter(&buf)
+	output.Handle(&testHandler{handle: func(msg *Message) {
+		// close the writer after the first message, without
+		// locking the mutex, which is held while handling
+		output.closed = true
+	}})
+	input := "{\"message\":\"one\"}\n\n{\"message\":\"two\"}\n"
+	n, err := output.Write([]byte(input))
+	if got, want := err, ErrClosed; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+	if got, want := n, len("{\"message\":\"one\"}\n\n"); got != want {
+		t.Errorf("got=%d, want=%d", got, want)
+	}
+	if got, want := buf.String(), "one\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
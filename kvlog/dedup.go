@@ -0,0 +1,137 @@
+package kvlog
+
+import (
+	"strconv"
+	"time"
+)
+
+// maxDedupEntries is the maximum number of distinct messages
+// remembered by a deduper. When the limit is reached, the oldest
+// message is forgotten to make room.
+const maxDedupEntries = 256
+
+// deduper collapses identical messages written within a time window.
+// It is only accessed while the writer's mutex is locked.
+type deduper struct {
+	window  time.Duration
+	entries map[string]*dedupEntry
+	order   []string    // keys in the order they were first seen
+	timeout func()      // called by the timer when the oldest window closes
+	timer   *time.Timer // expires the oldest message, or nil
+}
+
+// dedupEntry is a message that has been printed, and the number
+// of times it has been repeated since.
+type dedupEntry struct {
+	ent     logEntry  // copy of the most recent repeat
+	count   int       // number of repeats not printed
+	expires time.Time // end of the window
+}
+
+func newDeduper(window time.Duration, timeout func()) *deduper {
+	return &deduper{
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+		timeout: timeout,
+	}
+}
+
+// repeat reports whether the message identified by key has been seen
+// within the window, in which case it should not be printed. The entry
+// is remembered so that a summary can be printed when the window closes.
+func (d *deduper) repeat(key string, ent *logEntry, print func(*logEntry)) bool {
+	if e, ok := d.entries[key]; ok {
+		e.count++
+		e.ent = copyEntry(ent)
+		return true
+	}
+	if len(d.order) >= maxDedupEntries {
+		d.remove(0, print)
+	}
+	d.entries[key] = &dedupEntry{expires: ent.Timestamp.Add(d.window)}
+	d.order = append(d.order, key)
+	if len(d.order) == 1 {
+		// the timer is pending whenever there are messages
+		d.schedule()
+	}
+	return false
+}
+
+// schedule starts the timer for the end of the oldest message's window.
+// Messages are kept in the order they were first seen, so no other
+// window closes before it.
+func (d *deduper) schedule() {
+	if len(d.order) == 0 {
+		return
+	}
+	wait := time.Until(d.entries[d.order[0]].expires)
+	if d.timer == nil {
+		d.timer = time.AfterFunc(wait, d.timeout)
+	} else {
+		d.timer.Reset(wait)
+	}
+}
+
+// stop removes all messages, printing a summary for each message
+// that was repeated, and stops the timer.
+func (d *deduper) stop(print func(*logEntry)) {
+	d.expire(time.Time{}, print)
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// expire removes any messages whose window has closed before now,
+// printing a summary for each message that was repeated. If now is
+// the zero time, all messages are removed.
+func (d *deduper) expire(now time.Time, print func(*logEntry)) {
+	for i := 0; i < len(d.order); {
+		e := d.entries[d.order[i]]
+		if now.IsZero() || !now.Before(e.expires) {
+			d.remove(i, print)
+			continue
+		}
+		i++
+	}
+}
+
+// remove the i'th message, printing a summary if it was repeated.
+func (d *deduper) remove(i int, print func(*logEntry)) {
+	key := d.order[i]
+	e := d.entries[key]
+	delete(d.entries, key)
+	d.order = append(d.order[:i], d.order[i+1:]...)
+	if e.count > 0 {
+		e.ent.List = append(e.ent.List, []byte("repeated"), []byte(strconv.Itoa(e.count)))
+		if e.ent.Quoted != nil {
+			e.ent.Quoted = append(e.ent.Quoted, false, false)
+		}
+		print(&e.ent)
+	}
+}
+
+// copyEntry returns a copy of ent that does not share memory
+// with the logger's output buffer.
+func copyEntry(ent *logEntry) logEntry {
+	c := *ent
+	c.Date = copyBytes(ent.Date)
+	c.Time = copyBytes(ent.Time)
+	c.File = copyBytes(ent.File)
+	c.Text = copyBytes(ent.Text)
+	c.List = make([][]byte, len(ent.List), len(ent.List)+2)
+	for i, v := range ent.List {
+		c.List[i] = copyBytes(v)
+	}
+	if ent.Quoted != nil {
+		c.Quoted = make([]bool, len(ent.Quoted), len(ent.Quoted)+2)
+		copy(c.Quoted, ent.Quoted)
+	}
+	return c
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
@@ -1,6 +1,8 @@
 package kvlog_test
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 
 	"github.com/jjeffery/kv/kvlog"
@@ -16,3 +18,18 @@ func Example() {
 
 	log.Println("program started")
 }
+
+func ExampleCapture() {
+	capture := kvlog.NewCapture(40)
+	logger := log.New(ioutil.Discard, "", 0)
+	capture.Attach(logger)
+
+	logger.Println("a message that is long enough to wrap", "id=1", "name=alice")
+	for _, line := range capture.Lines() {
+		fmt.Printf("%q\n", line)
+	}
+
+	// Output:
+	// "a message that is long enough to wrap"
+	// "    id=1 name=alice"
+}
@@ -0,0 +1,144 @@
+package kvlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jjeffery/kv/internal/pool"
+)
+
+// NewGELFWriter returns a writer that prints messages to conn in
+// GELF (Graylog Extended Log Format) version 1.1. Each message is a
+// JSON object containing the message text as "short_message", the
+// time of the message as "timestamp", and the level as a syslog
+// severity. As for NewSyslogWriter, the level at the beginning of the
+// message text is used, otherwise the value of the level key (see
+// LevelKey). The key/value pairs are additional fields, with keys
+// prefixed by an underscore. The host is reported as the source
+// of each message. If host is empty, the host name is used.
+//
+// If conn is a packet connection, such as a UDP connection, each
+// message is written as a single datagram. Otherwise each message is
+// terminated with a null byte, which is the framing used by GELF over
+// TCP. Messages are not compressed or chunked, so messages sent over
+// UDP must fit in a single datagram.
+func NewGELFWriter(conn io.Writer, host string) *Writer {
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	w := NewWriter(conn)
+	w.mutex.Lock()
+	w.gelfHost = host
+	w.gelf = true
+	w.setPrinter()
+	w.mutex.Unlock()
+	return w
+}
+
+// gelfPrinter prints messages in GELF format.
+type gelfPrinter struct {
+	w        io.Writer
+	host     string
+	levelKey []byte
+	packet   bool // do not terminate messages with a null byte
+}
+
+func newGELFPrinter(w io.Writer, host string, levelKey []byte) *gelfPrinter {
+	_, packet := w.(net.PacketConn)
+	return &gelfPrinter{
+		w:        w,
+		host:     host,
+		levelKey: levelKey,
+		packet:   packet,
+	}
+}
+
+func (p *gelfPrinter) Print(msg *logEntry) {
+	buf := pool.AllocBuffer()
+	buf.WriteString(`{"version":"1.1","host":`)
+	writeJSONString(buf, p.host)
+	buf.WriteString(`,"short_message":`)
+	text := msg.Text
+	if len(text) == 0 {
+		// short_message is mandatory and cannot be empty
+		text = []byte("-")
+	}
+	writeJSONString(buf, string(text))
+	buf.WriteString(`,"timestamp":`)
+	buf.WriteString(gelfTimestamp(msg))
+	buf.WriteString(`,"level":`)
+	buf.WriteString(strconv.Itoa(severity(entryLevel(msg, p.levelKey))))
+	if prefix := strings.TrimSpace(msg.Prefix); prefix != "" {
+		buf.WriteString(`,"_prefix":`)
+		writeJSONString(buf, prefix)
+	}
+	if msg.Label != "" {
+		buf.WriteString(`,"_service":`)
+		writeJSONString(buf, msg.Label)
+	}
+	if len(msg.File) > 0 {
+		buf.WriteString(`,"_file":`)
+		writeJSONString(buf, string(msg.File))
+	}
+	for i := 0; i < len(msg.List); i += 2 {
+		buf.WriteRune(',')
+		writeJSONString(buf, gelfField(msg.List[i]))
+		buf.WriteRune(':')
+		writeJSONString(buf, string(msg.List[i+1]))
+	}
+	buf.WriteRune('}')
+	if !p.packet {
+		buf.WriteByte(0)
+	}
+	p.w.Write(buf.Bytes())
+	pool.ReleaseBuffer(buf)
+}
+
+// gelfTimestamp returns the time of the message as seconds since
+// the Unix epoch. The date and time printed by the logger are used
+// if possible, otherwise the time the message was written is used.
+func gelfTimestamp(msg *logEntry) string {
+	secs := float64(entryTime(msg).UnixNano()) / float64(time.Second)
+	return strconv.FormatFloat(secs, 'f', 6, 64)
+}
+
+// entryTime returns the date and time printed by the logger,
+// or the time the message was written if they are not known.
+func entryTime(msg *logEntry) time.Time {
+	if len(msg.Date) > 0 || len(msg.Time) > 0 {
+		if t, ok := parseLogTime(msg.Date, msg.Time, msg.Timestamp); ok {
+			return t
+		}
+	}
+	return msg.Timestamp
+}
+
+// gelfField returns the name of the additional field for key. Additional
+// field names start with an underscore, and can only contain letters,
+// digits, underscores, dashes and dots. The name "_id" is reserved.
+func gelfField(key []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('_')
+	for _, c := range string(key) {
+		if c == '_' || c == '-' || c == '.' || (c < 128 && (isDigit(byte(c)) || isLetter(byte(c)))) {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	if sb.Len() == 1 || sb.String() == "_id" {
+		sb.WriteByte('_')
+	}
+	return sb.String()
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
@@ -0,0 +1,136 @@
+package kvlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGELFWriter(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		flags    int
+		levelKey string
+		input    string
+		want     map[string]interface{}
+	}{
+		{
+			flags: log.LstdFlags | log.LUTC,
+			input: "2009/11/10 23:00:00 error: cannot connect host=db1 id=1 user.name=alice \"a b\"=c",
+			want: map[string]interface{}{
+				"version":       "1.1",
+				"host":          "server1",
+				"short_message": "cannot connect",
+				"timestamp":     1257894000.0,
+				"level":         3.0,
+				"_host":         "db1",
+				"_id_":          "1",
+				"_user.name":    "alice",
+				"_a_b":          "c",
+			},
+		},
+		{
+			prefix: "prog: ",
+			flags:  log.Lshortfile,
+			input:  "prog: file.go:23: debug: a=1",
+			want: map[string]interface{}{
+				"version":       "1.1",
+				"host":          "server1",
+				"short_message": "-",
+				"level":         7.0,
+				"_prefix":       "prog:",
+				"_file":         "file.go:23",
+				"_a":            "1",
+			},
+		},
+		{ // level key
+			input: "request slow level=warn",
+			want: map[string]interface{}{
+				"version":       "1.1",
+				"host":          "server1",
+				"short_message": "request slow",
+				"level":         4.0,
+				"_level":        "warn",
+			},
+		},
+		{
+			levelKey: "severity",
+			input:    "request failed severity=error",
+			want: map[string]interface{}{
+				"version":       "1.1",
+				"host":          "server1",
+				"short_message": "request failed",
+				"level":         3.0,
+				"_severity":     "error",
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewGELFWriter(&buf, "server1")
+		if tt.levelKey != "" {
+			output.LevelKey(tt.levelKey)
+		}
+		logger := log.New(ioutil.Discard, tt.prefix, tt.flags)
+		writer := newLogWriter(output, logger)
+		start := time.Now()
+		writer.Write([]byte(tt.input))
+		b := buf.Bytes()
+		if len(b) == 0 || b[len(b)-1] != 0 {
+			t.Fatalf("%d: missing null terminator: %q", tn, b)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(b[:len(b)-1], &got); err != nil {
+			t.Fatalf("%d: %v", tn, err)
+		}
+		if _, ok := tt.want["timestamp"]; !ok {
+			// no date or time from the logger, so the time written is used
+			ts, _ := got["timestamp"].(float64)
+			if ts < float64(start.Unix()) || ts > float64(time.Now().Unix()+1) {
+				t.Errorf("%d: unexpected timestamp %v", tn, got["timestamp"])
+			}
+			delete(got, "timestamp")
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d:\n got=%v\nwant=%v", tn, got, tt.want)
+		}
+	}
+}
+
+func TestGELFWriterPacket(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	output := NewGELFWriter(conn, "server1")
+	logger := log.New(ioutil.Discard, "", 0)
+	output.Attach(logger)
+	logger.Println("message a=1")
+
+	b := make([]byte, 8192)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b[:n], &got); err != nil {
+		t.Fatalf("%v: %q", err, b[:n])
+	}
+	if got, want := got["short_message"], "message"; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
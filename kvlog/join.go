@@ -0,0 +1,103 @@
+package kvlog
+
+import (
+	"bytes"
+	"time"
+)
+
+// joiner holds the most recent message written by a logger, so that
+// any continuation lines that follow can be appended to it. It is only
+// accessed while the writer's mutex is locked.
+type joiner struct {
+	wait     time.Duration // time to wait for continuation lines
+	hdr      logEntry      // copy of the pending message header
+	text     []byte        // pending message text, or nil if none
+	deadline time.Time     // when the pending message is printed
+	timer    *time.Timer   // prints the pending message, or nil
+}
+
+// isContinuation reports whether line, which is the complete line written
+// by a logger, continues the previous message. A line is a continuation if
+// it starts with white space, such as the lines of a stack trace, or if the
+// logger prints a header and the header is missing from the line.
+func (w *logWriter) isContinuation(line []byte, hdr *logEntry) bool {
+	if len(line) > 0 && isspace(rune(line[0])) {
+		return true
+	}
+	hasHeader := w.prefixb != nil || w.hasDate || w.hasTime || w.hasFile
+	return hasHeader && hdr.Prefix == "" && hdr.Date == nil && hdr.Time == nil && hdr.File == nil
+}
+
+// writeJoined is called instead of writeEntry when continuation lines are
+// joined. If line is a continuation, it is appended to the pending message.
+// Otherwise the pending message is printed, and p becomes the pending message.
+// The writer's mutex must be locked.
+func (w *Writer) writeJoined(hdr *logEntry, p []byte, line []byte, continuation bool) error {
+	if w.closed {
+		return ErrClosed
+	}
+	j := w.joiner
+	if continuation && j.text != nil {
+		j.text = append(j.text, '\n')
+		j.text = append(j.text, trimNewline(line)...)
+		j.deadline = time.Now().Add(j.wait)
+		return nil
+	}
+	err := w.flushJoined()
+	j.hdr = copyEntry(hdr)
+	j.text = append([]byte{}, trimNewline(p)...)
+	j.deadline = time.Now().Add(j.wait)
+	if j.timer == nil {
+		j.timer = time.AfterFunc(j.wait, w.joinTimeout)
+	} else {
+		j.timer.Reset(j.wait)
+	}
+	return err
+}
+
+// joinTimeout is called by the joiner's timer. It prints the pending
+// message if no continuation lines have been written during the wait.
+func (w *Writer) joinTimeout() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	j := w.joiner
+	if j == nil || j.text == nil || w.closed {
+		return
+	}
+	if d := time.Until(j.deadline); d > 0 {
+		j.timer.Reset(d)
+		return
+	}
+	w.flushJoined()
+}
+
+// flushJoined prints the pending message, if any.
+// The writer's mutex must be locked.
+func (w *Writer) flushJoined() error {
+	j := w.joiner
+	if j == nil || j.text == nil {
+		return nil
+	}
+	hdr, text := j.hdr, j.text
+	j.hdr, j.text = logEntry{}, nil
+	return w.writeEntry(&hdr, text, nil)
+}
+
+// stopJoiner prints the pending message, if any, and stops the timer.
+// The writer's mutex must be locked.
+func (w *Writer) stopJoiner() {
+	if w.joiner == nil {
+		return
+	}
+	w.flushJoined()
+	if w.joiner.timer != nil {
+		w.joiner.timer.Stop()
+	}
+	w.joiner = nil
+}
+
+// trimNewline returns p without its line terminator.
+func trimNewline(p []byte) []byte {
+	p = bytes.TrimSuffix(p, []byte{'\n'})
+	return bytes.TrimSuffix(p, []byte{'\r'})
+}
@@ -0,0 +1,83 @@
+package kvlog
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestJoinLines(t *testing.T) {
+	tests := []struct {
+		prefix string
+		input  []string
+		output string
+	}{
+		{ // lines without the logger prefix are continuations
+			prefix: "prog: ",
+			input: []string{
+				"prog: panic: something went wrong\n",
+				"goroutine 1 [running]:\n",
+				"main.main()\n",
+				"\t/src/main.go:12 +0x1d\n",
+				"prog: next message a=1\n",
+			},
+			output: "prog: panic: something went wrong\n" +
+				"      goroutine 1 [running]:\n" +
+				"      main.main()\n" +
+				"        /src/main.go:12 +0x1d\n" +
+				"prog: next message a=1\n",
+		},
+		{ // indented lines are continuations
+			input: []string{
+				"stack trace:\n",
+				"  one\n",
+				"  two\n",
+				"next message\n",
+				"  three\n",
+			},
+			output: "stack trace:\n     one\n     two\nnext message\n     three\n",
+		},
+		{ // a continuation without a message before it
+			input: []string{
+				"  indented\n",
+				"message\n",
+			},
+			output: "indented\nmessage\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		c := NewCapture(60)
+		c.JoinLines(time.Hour)
+		lw := newLogWriter(c.Writer, log.New(ioutil.Discard, tt.prefix, 0))
+		for _, s := range tt.input {
+			lw.Write([]byte(s))
+		}
+		c.Close()
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestJoinLinesWait(t *testing.T) {
+	c := NewCapture(60)
+	c.JoinLines(10 * time.Millisecond)
+	lw := newLogWriter(c.Writer, log.New(ioutil.Discard, "", 0))
+	lw.Write([]byte("message\n"))
+	lw.Write([]byte("  continued\n"))
+	if got := c.String(); got != "" {
+		t.Errorf("got=%q, want nothing", got)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); c.String() == ""; {
+		if time.Now().After(deadline) {
+			t.Fatal("message not printed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := c.String(), "message\n     continued\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package kvlog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jjeffery/kv"
+	"github.com/jjeffery/kv/internal/logfmt"
+)
+
+// MarshalJSON implements the json.Marshaler interface. The message is
+// represented as a single JSON object containing "time", "level",
+// "prefix" and "file" (each only if present), followed by "text" and
+// then the key/value pairs in the list.
+//
+// String values are JSON strings, errors are represented by their
+// message, and other values keep their native JSON type. A key that
+// is the same as a field already in the object has underscores
+// appended until it is unique, so "text" becomes "text_".
+func (m *Message) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	used := make(map[string]bool)
+	field := func(key string, value interface{}) {
+		for used[key] {
+			key += "_"
+		}
+		used[key] = true
+		if buf.Len() == 0 {
+			buf.WriteByte('{')
+		} else {
+			buf.WriteByte(',')
+		}
+		writeJSONString(&buf, key)
+		buf.WriteByte(':')
+		writeJSONValue(&buf, value)
+	}
+
+	if !m.Timestamp.IsZero() {
+		field("time", m.Timestamp.Format(time.RFC3339Nano))
+	}
+	if m.Level != "" {
+		field("level", m.Level)
+	}
+	if m.Prefix != "" {
+		field("prefix", m.Prefix)
+	}
+	if m.File != "" {
+		field("file", m.File)
+	}
+	field("text", m.Text)
+
+	// flatten any nested lists and supply any missing keys
+	list := kv.With(m.List)
+	for i := 0; i+1 < len(list); i += 2 {
+		key, ok := list[i].(string)
+		if !ok {
+			key = fmt.Sprint(list[i])
+		}
+		field(key, list[i+1])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeJSONValue writes v to buf in JSON format. Values that
+// cannot be represented in JSON, and values that have a formatter
+// registered with kv.RegisterFormatter, are written as strings.
+// Byte slices are written as strings in the format described in
+// kv.SetBytesFormat.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	if format := logfmt.Formatter(v); format != nil {
+		writeJSONString(buf, logfmt.Format(format, v))
+		return
+	}
+	switch val := v.(type) {
+	case string:
+		writeJSONString(buf, val)
+		return
+	case []byte:
+		// in the same format as logfmt, see kv.SetBytesFormat
+		if logfmt.IsHexBytes(val) {
+			writeJSONString(buf, hex.EncodeToString(val))
+		} else {
+			writeJSONString(buf, string(val))
+		}
+		return
+	case json.Marshaler:
+		// checked before error so that types can choose
+		// their own representation
+	case error:
+		if !logfmt.IsNilPointer(val) {
+			writeJSONString(buf, val.Error())
+			return
+		}
+	default:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			// named byte slice type, which json.Marshal writes in base64
+			writeJSONValue(buf, rv.Bytes())
+			return
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeJSONString(buf, fmt.Sprint(v))
+		return
+	}
+	buf.Write(b)
+}
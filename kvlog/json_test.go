@@ -0,0 +1,127 @@
+package kvlog
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jjeffery/kv"
+)
+
+func TestMessageMarshalJSON(t *testing.T) {
+	tests := []struct {
+		msg  Message
+		want string
+	}{
+		{
+			msg:  Message{Text: "message"},
+			want: `{"text":"message"}`,
+		},
+		{
+			msg: Message{
+				Timestamp: time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC),
+				Prefix:    "prog: ",
+				File:      "file.go:23",
+				Level:     "info",
+				Text:      "message",
+				List:      kv.List{"a", "1", "b", 2, "c", true, "d", nil},
+			},
+			want: `{"time":"2009-11-10T23:00:00Z","level":"info","prefix":"prog: ","file":"file.go:23","text":"message","a":"1","b":2,"c":true,"d":null}`,
+		},
+		{
+			msg: Message{
+				Text: "collisions",
+				List: kv.List{"text", "a", "text", "b", "text_", "c"},
+			},
+			want: `{"text":"collisions","text_":"a","text__":"b","text___":"c"}`,
+		},
+		{
+			msg: Message{
+				List: kv.List{"err", errors.New("not found"), "inf", math.Inf(1)},
+			},
+			want: `{"text":"","err":"not found","inf":"+Inf"}`,
+		},
+		{ // the Error method is not called with a nil receiver
+			msg: Message{
+				List: kv.List{"err", error((*valueError)(nil))},
+			},
+			want: `{"text":"","err":null}`,
+		},
+		{
+			msg: Message{
+				Text: "missing key",
+				List: kv.List{"a"},
+			},
+			want: `{"text":"missing key","msg":"a"}`,
+		},
+		{
+			msg: Message{
+				List: kv.List{"b", []byte("bytes"), "x", []byte{0xff, 0x00, 0x68}},
+			},
+			want: `{"text":"","b":"bytes","x":"ff0068"}`,
+		},
+		{
+			msg: Message{
+				List: kv.List{"r", rawBytes("raw"), "j", json.RawMessage(`[1]`)},
+			},
+			want: `{"text":"","r":"raw","j":[1]}`,
+		},
+	}
+
+	for tn, tt := range tests {
+		b, err := json.Marshal(&tt.msg)
+		if err != nil {
+			t.Errorf("%d: %v", tn, err)
+			continue
+		}
+		if got, want := string(b), tt.want; got != want {
+			t.Errorf("%d:\n got=%s\nwant=%s", tn, got, want)
+		}
+	}
+}
+
+// valueError is an error with a value receiver, which
+// panics if its Error method is called with a nil pointer.
+type valueError struct{}
+
+func (valueError) Error() string { return "value error" }
+
+func TestMessageMarshalJSONFormatter(t *testing.T) {
+	typ := reflect.TypeOf(panicValue{})
+	kv.RegisterFormatter(typ, func(v interface{}) string {
+		panic("formatter panic")
+	})
+	defer kv.RegisterFormatter(typ, nil)
+
+	msg := Message{Text: "message", List: kv.List{"a", panicValue{}}}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"text":"message","a":"PANIC"}`; got != want {
+		t.Errorf("\n got=%s\nwant=%s", got, want)
+	}
+}
+
+func TestMessageMarshalJSONBytesHex(t *testing.T) {
+	kv.SetBytesFormat(kv.BytesHex)
+	defer kv.SetBytesFormat(kv.BytesText)
+
+	msg := Message{List: kv.List{"b", []byte("hi"), "e", []byte{}}}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"text":"","b":"6869","e":""}`; got != want {
+		t.Errorf("\n got=%s\nwant=%s", got, want)
+	}
+}
+
+// rawBytes is a named byte slice type.
+type rawBytes []byte
+
+// panicValue is formatted by a function that panics.
+type panicValue struct{}
@@ -11,9 +11,27 @@ import (
 )
 
 // IsTerminal returns true if the writer is a terminal.
+//
+// If the writer has an IsTerminal() bool method, its result is returned.
+// Otherwise, if the writer has an Fd() uintptr method, the file descriptor
+// is checked. Writers that wrap another writer can implement an
+// Unwrap() io.Writer method, in which case the wrapped writer is checked.
 func IsTerminal(writer io.Writer) bool {
-	if fd, ok := fileDescriptor(writer); ok {
-		return terminal.IsTerminal(fd)
+	for w := writer; w != nil; w = unwrap(w) {
+		if t, ok := w.(interface{ IsTerminal() bool }); ok {
+			return t.IsTerminal()
+		}
+		if fd, ok := fileDescriptor(w); ok {
+			return terminal.IsTerminal(fd)
+		}
 	}
 	return false
 }
+
+// unwrap returns the writer wrapped by w, or nil if there is none.
+func unwrap(w io.Writer) io.Writer {
+	if u, ok := w.(interface{ Unwrap() io.Writer }); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
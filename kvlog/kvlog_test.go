@@ -2,175 +2,3557 @@ package kvlog
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"os"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jjeffery/kv"
+	"github.com/jjeffery/kv/internal/terminal"
+	"github.com/jjeffery/kv/internal/wrap"
 )
 
+func init() {
+	// expected output uses the dark theme, regardless
+	// of the terminal background where tests are run
+	os.Unsetenv("COLORFGBG")
+}
+
 func TestWriter(t *testing.T) {
 	tests := []struct {
-		input     string
-		output    string
-		flags     int
-		prefix    string
-		width     int
-		showColor bool
-		verbose   bool
+		input     string
+		output    string
+		flags     int
+		prefix    string
+		width     int
+		showColor bool
+		verbose   bool
+	}{
+		{
+			input: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
+			output: "2099/12/31 12:34:56 this is the message key1=value1\n" +
+				"                    key2=value2 key3=value3\n",
+			width: 60,
+			flags: log.LstdFlags,
+		},
+		{
+			input: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
+			output: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2\n" +
+				"                    key3=value3\n",
+			width: 70,
+			flags: log.LstdFlags,
+		},
+		{
+			input: "prog [400] 2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
+			output: "prog [400] 2099/12/31 12:34:56 this is the message key1=value1\n" +
+				"                               key2=value2 key3=value3\n",
+			width:  70,
+			prefix: "prog [400] ",
+			flags:  log.LstdFlags,
+		},
+		{
+			input:  "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
+			output: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
+			width:  80,
+			flags:  log.LstdFlags,
+		},
+		{
+			input: "this is the message key1=value1 key2=value2 key3=value3\n",
+			output: "this is the message key1=value1\n" +
+				"    key2=value2 key3=value3\n",
+			width: 40,
+		},
+		{
+			input:  "12:34:56 error: this is the message key1=value1 key2=value2: file not found\n",
+			output: "12:34:56 error: this is the message key1=value1 key2=value2: file not found\n",
+			flags:  log.Ltime,
+		},
+		{
+			input: "12:34:56 error: this is the message key1=value1 key2=value2: file not found\n",
+			output: "12:34:56 error: this is the message key1=value1 key2=value2: file not\n" +
+				"         found\n",
+			width: 70,
+			flags: log.Ltime,
+		},
+		{
+			input: "12:34:56 error: this is a very long message that will wrap over the line key1=value1 key2=value2: file not found\n",
+			output: "12:34:56 error: this is a very long message that will wrap\n" +
+				"         over the line key1=value1 key2=value2: file not\n" +
+				"         found\n",
+			width: 60,
+			flags: log.Ltime,
+		},
+		{
+			input: `11:17:29 select "id","version","created_at","updated_at","status","message","nick_name","user_id",` +
+				`"customer_type","customer_company_name","customer_trading_name","customer_abn",` +
+				`"customer_acn","customer_phone_number","customer_fax_number","customer_first_name",` +
+				`"customer_last_name","customer_middle_names","customer_mobile_number",` +
+				`"customer_date_of_birth" from customers where id > $1 order by id limit $2 [14 10]`,
+			output: `11:17:29 select "id","version","created_at","updated_at","status","message","nick_name","user_id",` + "\n" +
+				`         "customer_type","customer_company_name","customer_trading_name","customer_abn",` + "\n" +
+				`         "customer_acn","customer_phone_number","customer_fax_number","customer_first_name",` + "\n" +
+				`         "customer_last_name","customer_middle_names","customer_mobile_number",` + "\n" +
+				`         "customer_date_of_birth" from customers where id > $1 order by id limit $2 [14 10]` + "\n",
+			width: 100,
+			flags: log.Ltime,
+		},
+		{ // colors
+			input:     "prefix: 11:17:39 error: this is an error message\n",
+			output:    "prefix: 11:17:39 \x1b[0;31merror: \x1b[0mthis is an error message\n",
+			width:     120,
+			showColor: true,
+			prefix:    "prefix: ",
+			flags:     log.Ltime,
+		},
+		{ // colors
+			input:     "11:17:39  Error: this is an error message\n",
+			output:    "11:17:39 \x1b[0;31merror: \x1b[0mthis is an error message\n",
+			width:     120,
+			showColor: true,
+			flags:     log.Ltime,
+		},
+		{ // colors
+			input:     "11:17:39  custom: this is a custom level\n",
+			output:    "11:17:39 \x1b[0;32;1mcustom: \x1b[0mthis is a custom level\n",
+			width:     120,
+			showColor: true,
+			flags:     log.Ltime,
+		},
+		{ // suppress debug
+			input:  "12:34:56 debug: should be suppressed",
+			output: "",
+			flags:  log.Ltime,
+		},
+		{ // suppress trace
+			input:  "12:34:56 trace: should be suppressed",
+			output: "",
+			flags:  log.Ltime,
+		},
+		{ // verbose shows debug
+			input:   "12:34:56 debug: should be displayed",
+			output:  "12:34:56 debug: should be displayed\n",
+			verbose: true,
+			flags:   log.Ltime | log.LUTC,
+		},
+		{ // verbose shows trace
+			input:   "12:34:56 trace: should be displayed",
+			output:  "12:34:56 trace: should be displayed\n",
+			verbose: true,
+			flags:   log.Ltime,
+		},
+		{ // trailing white space
+			input:   "12:34:56 trailing white space   ",
+			output:  "12:34:56 trailing white space\n",
+			verbose: true,
+			flags:   log.Ltime,
+		},
+		{ // wide runes occupy two columns
+			input: "12:34:56 日本語のメッセージ 日本語のメッセージ key1=日本語 key2=value2\n",
+			output: "12:34:56 日本語のメッセージ 日本語のメッセージ\n" +
+				"         key1=日本語 key2=value2\n",
+			width: 50,
+			flags: log.Ltime,
+		},
+		{ // colored keys and values do not affect wrapping
+			input: "12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
+			output: "12:34:56 this is the message \x1b[0;36mkey1\x1b[0m=\x1b[0;96mvalue1\x1b[0m \x1b[0;36mkey2\x1b[0m=\x1b[0;96mvalue2\x1b[0m\n" +
+				"         \x1b[0;36mkey3\x1b[0m=\x1b[0;96mvalue3\x1b[0m\n",
+			width:     55,
+			flags:     log.Ltime,
+			showColor: true,
+		},
+		{ // file format
+			input:     "12:34:56 file.go:123 message",
+			output:    "12:34:56 \x1b[0;90mfile.go:123: \x1b[0mmessage\n",
+			verbose:   true,
+			flags:     log.Ltime | log.Lshortfile,
+			showColor: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		if !tt.verbose {
+			output.Suppress("trace", "debug")
+		}
+		output.SetLevel("custom", "32;1")
+		printer := &terminalPrinter{
+			w:       &buf,
+			nocolor: !tt.showColor,
+			theme:   DefaultTheme(),
+		}
+		if tt.width > 0 {
+			printer.width = func() int { return tt.width }
+		} else {
+			printer.width = func() int { return 999999 }
+		}
+		output.printer = printer
+		logger := log.New(ioutil.Discard, tt.prefix, tt.flags)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		str := buf.String()
+		if got, want := str, tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		flags  int
+		prefix string
+	}{
+		{
+			input:  "2099/12/31 12:34:56 this is the message key1=value1 key2=\"value 2\"\n",
+			output: `ts="2099/12/31 12:34:56" msg="this is the message" key1=value1 key2="value 2"` + "\n",
+			flags:  log.LstdFlags,
+		},
+		{
+			input:  "prog [400] 12:34:56 error: file not found file=/etc/passwd\n",
+			output: `ts="12:34:56" prefix="prog [400]" level=error msg="file not found" file="/etc/passwd"` + "\n",
+			prefix: "prog [400] ",
+			flags:  log.Ltime,
+		},
+		{
+			input:  "12:34:56 file.go:123: warning: message text\n",
+			output: `ts="12:34:56" caller="file.go:123" level=warning msg="message text"` + "\n",
+			flags:  log.Ltime | log.Lshortfile,
+		},
+		{
+			input:  "key1=value1 key2=value2\n",
+			output: "key1=value1 key2=value2\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.Logfmt()
+		logger := log.New(ioutil.Discard, tt.prefix, tt.flags)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		str := buf.String()
+		if got, want := str, tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+			continue
+		}
+		text, list := kv.Parse([]byte(str))
+		if got, want := len(text), 0; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
+		}
+		if got, want := list.String()+"\n", tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   string
+		prefixes []string
+		verbose  bool
+	}{
+		{
+			input:  "debug: suppressed by default prefixes",
+			output: "",
+		},
+		{
+			input:  "TRACE: suppressed regardless of case",
+			output: "",
+		},
+		{
+			input:   "debug: displayed when verbose",
+			output:  "debug: displayed when verbose\n",
+			verbose: true,
+		},
+		{
+			input:    "vv: suppressed by custom prefix",
+			output:   "",
+			prefixes: []string{"vv:", "dbg"},
+		},
+		{
+			input:    "DBG : suppressed by custom prefix",
+			output:   "",
+			prefixes: []string{"vv:", "dbg"},
+		},
+		{
+			input:    "debug: no longer a verbose prefix",
+			output:   "debug: no longer a verbose prefix\n",
+			prefixes: []string{"vv:", "dbg"},
+		},
+		{
+			input:    "vv: displayed when verbose",
+			output:   "vv: displayed when verbose\n",
+			prefixes: []string{"vv:", "dbg"},
+			verbose:  true,
+		},
+		{
+			input:  "debugging is not a verbose prefix",
+			output: "debugging is not a verbose prefix\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.SetVerbose(tt.verbose)
+		if tt.prefixes != nil {
+			output.SetVerbosePrefixes(tt.prefixes...)
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestSetSuppressPrefixes(t *testing.T) {
+	tests := []struct {
+		input   string
+		output  string
+		verbose bool
+	}{
+		{
+			input:   "metric: requests=10",
+			output:  "",
+			verbose: true,
+		},
+		{
+			input:  "METRIC: requests=10",
+			output: "",
+		},
+		{
+			input:  "audit : login user=alice",
+			output: "",
+		},
+		{
+			input:  "metrics are not suppressed",
+			output: "metrics are not suppressed\n",
+		},
+		{
+			input:   "debug: debug message",
+			output:  "debug: debug message\n",
+			verbose: true,
+		},
+		{
+			input:  "info: message",
+			output: "info: message\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		var handled int
+		output := NewWriter(&buf)
+		output.SetVerbose(tt.verbose)
+		output.SetSuppressPrefixes("metric:", " audit ")
+		output.Handle(&testHandler{handle: func(*Message) { handled++ }})
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		if n, err := writer.Write([]byte(tt.input)); n != len(tt.input) || err != nil {
+			t.Errorf("%d: got=(%d, %v), want=(%d, nil)", tn, n, err, len(tt.input))
+		}
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+		wantHandled := 1
+		if tt.output == "" {
+			wantHandled = 0
+		}
+		if got, want := handled, wantHandled; got != want {
+			t.Errorf("%d: got=%d handled, want=%d", tn, got, want)
+		}
+	}
+
+	output := NewWriter(ioutil.Discard)
+	output.SetSuppressPrefixes("metric")
+	if !output.IsSuppressed("metric") {
+		t.Errorf("IsSuppressed: got=false, want=true")
+	}
+	output.SetSuppressPrefixes()
+	if output.IsSuppressed("metric") {
+		t.Errorf("IsSuppressed: got=true, want=false")
+	}
+
+	// the default verbose prefixes apply before the first message is written
+	output = NewWriter(ioutil.Discard)
+	output.SetVerbose(false)
+	if !output.IsSuppressed("debug") {
+		t.Errorf("IsSuppressed: got=false, want=true")
+	}
+}
+
+func TestIsSuppressedConcurrent(t *testing.T) {
+	// run with -race to check that IsSuppressed does not race
+	// with the methods that change which messages are suppressed
+	output := NewWriter(ioutil.Discard)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			output.SetSuppressPrefixes("metric")
+			output.SetVerbose(i%2 == 0)
+			output.SetVerbosePrefixes("debug", "trace")
+			output.Suppress("info")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			output.IsSuppressed("metric")
+			output.IsSuppressed("debug")
+			output.IsSuppressed("info")
+		}
+	}()
+	wg.Wait()
+	if !output.IsSuppressed("metric") {
+		t.Errorf("IsSuppressed: got=false, want=true")
+	}
+}
+
+func TestVerbosity(t *testing.T) {
+	inputs := []string{
+		"trace: trace message",
+		"debug: debug message",
+		"vv: custom message",
+		"info: info message",
+	}
+	tests := []struct {
+		verbosity int
+		verbose   bool // call SetVerbose(true) after Verbosity
+		output    string
+	}{
+		{
+			verbosity: 0,
+			output:    "info: info message\n",
+		},
+		{
+			verbosity: 1,
+			output:    "debug: debug message\nvv: custom message\ninfo: info message\n",
+		},
+		{
+			verbosity: 2,
+			output:    "trace: trace message\ndebug: debug message\nvv: custom message\ninfo: info message\n",
+		},
+		{
+			verbosity: 1,
+			verbose:   true,
+			output:    "trace: trace message\ndebug: debug message\nvv: custom message\ninfo: info message\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.SetVerbosePrefixes("trace", "debug", "vv")
+		output.Verbosity(tt.verbosity)
+		if tt.verbose {
+			output.SetVerbose(true)
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		for _, input := range inputs {
+			writer.Write([]byte(input))
+		}
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got, want := output.IsSuppressed("trace"), tt.verbosity < 2 && !tt.verbose; got != want {
+			t.Errorf("%d: IsSuppressed: got=%v, want=%v", tn, got, want)
+		}
+	}
+}
+
+func TestSetVerboseConcurrent(t *testing.T) {
+	// run with -race to check that changing the verbosity
+	// and color while messages are written is safe
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	logger := log.New(ioutil.Discard, "", 0)
+	output.Attach(logger)
+
+	const messages = 1000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < messages; i++ {
+			output.SetVerbose(i%2 == 0)
+			output.Verbosity(i % 3)
+			if i%2 == 0 {
+				output.NoColor()
+			} else {
+				output.ForceColor()
+			}
+		}
+	}()
+	for i := 0; i < messages; i++ {
+		logger.Printf("debug: message i=%d", i)
+		logger.Printf("info: message i=%d", i)
+	}
+	<-done
+
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) < messages {
+		t.Errorf("got=%d lines, want at least %d", len(lines), messages)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "debug: message i=") && !strings.HasPrefix(line, "info: message i=") {
+			t.Errorf("unexpected line: %q", line)
+		}
+	}
+}
+
+// writeRecorder records the bytes passed to each call to Write.
+type writeRecorder struct {
+	mutex  sync.Mutex
+	writes []string
+}
+
+func (r *writeRecorder) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	r.writes = append(r.writes, string(p))
+	r.mutex.Unlock()
+	return len(p), nil
+}
+
+func TestWriteAtomic(t *testing.T) {
+	// run with -race to check that messages written by many
+	// goroutines are each printed with a single call to Write
+	const (
+		goroutines = 100
+		messages   = 50
+	)
+	var rec writeRecorder
+	output := NewWriter(&rec)
+	output.SetVerbose(true)
+	output.printer = &terminalPrinter{
+		w:       &rec,
+		width:   func() int { return 40 },
+		nocolor: true,
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			logger := log.New(ioutil.Discard, "", log.LstdFlags)
+			logger.SetOutput(newLogWriter(output, logger))
+			for i := 0; i < messages; i++ {
+				msg := fmt.Sprintf("message g=%d i=%d with text that wraps over several lines a=%d b=%d", g, i, g, i)
+				if i%2 == 0 {
+					logger.Print(msg)
+				} else {
+					output.Write([]byte(msg))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if got, want := len(rec.writes), goroutines*messages; got != want {
+		t.Fatalf("got=%d writes, want=%d", got, want)
+	}
+	re := regexp.MustCompile(`^(\d{4}/\d\d/\d\d \d\d:\d\d:\d\d )?message g=(\d+) i=(\d+) with text that wraps over several lines a=(\d+) b=(\d+)$`)
+	seen := make(map[string]bool)
+	for _, w := range rec.writes {
+		// line breaks depend on the length of the header
+		m := re.FindStringSubmatch(strings.Join(strings.Fields(w), " "))
+		if m == nil || !strings.HasSuffix(w, "\n") {
+			t.Errorf("interleaved or torn write: %q", w)
+			continue
+		}
+		if m[2] != m[4] || m[3] != m[5] {
+			t.Errorf("mixed messages: %q", w)
+		}
+		if strings.Count(w, "\n") < 2 {
+			t.Errorf("want several lines: %q", w)
+		}
+		seen[m[2]+"/"+m[3]] = true
+	}
+	if got, want := len(seen), goroutines*messages; got != want {
+		t.Errorf("got=%d distinct messages, want=%d", got, want)
+	}
+}
+
+func TestErrorPrefixes(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "ERROR: error message",
+			output: "\x1b[0;31mERROR: \x1b[0merror message\n",
+		},
+		{
+			input:  "fatal: fatal message",
+			output: "\x1b[0;31mfatal: \x1b[0mfatal message\n",
+		},
+		{
+			input:  "WARN: warning message",
+			output: "\x1b[0;33mWARN: \x1b[0mwarning message\n",
+		},
+		{
+			input:  "warning: warning message",
+			output: "\x1b[0;33mwarning: \x1b[0mwarning message\n",
+		},
+		{
+			input:  "info: info message",
+			output: "\x1b[0;36minfo: \x1b[0minfo message\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.SetErrorPrefixes("ERROR:", "fatal")
+		output.SetWarningPrefixes("WARN")
+		output.printer = &terminalPrinter{
+			w:     &buf,
+			width: func() int { return 120 },
+			theme: DefaultTheme(),
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestColorWholeErrorLine(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "error: cannot connect",
+			output: "\x1b[0;31merror: \x1b[0m\x1b[0;31mcannot connect\x1b[0m\n",
+		},
+		{
+			input:  "error: cannot connect to the database server a=1",
+			output: "\x1b[0;31merror: \x1b[0m\x1b[0;31mcannot connect to the\n    database server\x1b[0m \x1b[0;36ma\x1b[0m=\x1b[0;96m1\x1b[0m\n",
+		},
+		{
+			input:  "error: a=1",
+			output: "\x1b[0;31merror: \x1b[0m\x1b[0;36ma\x1b[0m=\x1b[0;96m1\x1b[0m\n",
+		},
+		{
+			input:  "warning: slow response",
+			output: "\x1b[0;33mwarning: \x1b[0mslow response\n",
+		},
+		{
+			input:  "message text",
+			output: "message text\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.ColorWholeErrorLine()
+		output.SetTheme(DarkTheme())
+		output.printer = &terminalPrinter{
+			w:       &buf,
+			width:   func() int { return 30 },
+			theme:   DarkTheme(),
+			padding: -1,
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestTheme(t *testing.T) {
+	theme := Theme{
+		Error:     "magenta",
+		Warning:   "blue",
+		Key:       "green",
+		Value:     "1",
+		Timestamp: "bright black",
+	}
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "12:34:56 error: message a=1",
+			output: "\x1b[0;90m12:34:56 \x1b[0m\x1b[0;35merror: \x1b[0mmessage \x1b[0;32ma\x1b[0m=\x1b[0;1m1\x1b[0m\n",
+		},
+		{
+			input:  "12:34:56 fatal: message",
+			output: "\x1b[0;90m12:34:56 \x1b[0m\x1b[0;35mfatal: \x1b[0mmessage\n",
+		},
+		{
+			input:  "12:34:56 warning: message",
+			output: "\x1b[0;90m12:34:56 \x1b[0m\x1b[0;34mwarning: \x1b[0mmessage\n",
+		},
+		{
+			input:  "12:34:56 WARN: message",
+			output: "\x1b[0;90m12:34:56 \x1b[0m\x1b[0;34mWARN: \x1b[0mmessage\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.SetWarningPrefixes("WARN")
+		output.SetTheme(theme)
+		output.printer = &terminalPrinter{
+			w:     &buf,
+			width: func() int { return 120 },
+			theme: theme,
+		}
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		logfmt bool
+	}{
+		{
+			input:  "message c=1 a=2 b=3",
+			output: "message a=2 b=3 c=1\n",
+		},
+		{
+			input:  "message b=1 a=2 b=3 a=4 a=5",
+			output: "message a=2 a=4 a=5 b=1 b=3\n",
+		},
+		{
+			input:  "message c=1 a=2 b=3",
+			output: "msg=message a=2 b=3 c=1\n",
+			logfmt: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.SortKeys(true)
+		if tt.logfmt {
+			output.Logfmt()
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestSanitizeControl(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   string
+		width    int
+		disabled bool
+	}{
+		{
+			input:  "bell\x07 k=\"a\\x07b\"",
+			output: "bell\\x07 k=a\\x07b\n",
+		},
+		{
+			input:  "\x1b[2J\x1b[Hcleared screen",
+			output: "\\x1b[2J\\x1b[Hcleared screen\n",
+		},
+		{
+			input:  "one\rtwo\x7f three\u0085four",
+			output: "one\\x0dtwo\\x7f three\\x85four\n",
+		},
+		{ // color, tabs and newlines are not escaped
+			input:  "\x1b[31mred\x1b[0m k=\"a\\tb\"",
+			output: "\x1b[31mred\x1b[0m k=a\tb\n",
+		},
+		{ // escape sequences count towards the width
+			input:  "message \x01\x02\x03 a=1",
+			output: "message\n    \\x01\\x02\\x03\n    a=1\n",
+			width:  20,
+		},
+		{
+			input:    "bell\x07 k=\"a\\x07b\"",
+			output:   "bell\x07 k=a\x07b\n",
+			disabled: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		if tt.width == 0 {
+			tt.width = 80
+		}
+		c := NewCapture(tt.width)
+		c.Indent("    ")
+		if tt.disabled {
+			c.SanitizeControl(false)
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(c.Writer, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestOddLengthList(t *testing.T) {
+	defer func(v interface{}) { kv.MissingValue = v }(kv.MissingValue)
+	tests := []struct {
+		missing interface{}
+		keyvals []interface{}
+		output  string
+	}{
+		{
+			keyvals: []interface{}{"a", 1, "b"},
+			output:  "message a=1 msg=b\n",
+		},
+		{
+			keyvals: []interface{}{"key"},
+			output:  "message msg=key\n",
+		},
+		{
+			missing: kv.Missing,
+			keyvals: []interface{}{"a", 1, "b"},
+			output:  "message a=1 b=MISSING\n",
+		},
+		{
+			missing: kv.Missing,
+			keyvals: []interface{}{"key"},
+			output:  "message key=MISSING\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		kv.MissingValue = tt.missing
+		c := NewCapture(80)
+		logger := log.New(c, "", 0)
+		logger.Println("message", kv.With(tt.keyvals...))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestMergeDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		policy DuplicateKeys
+		sort   bool
+		input  string
+		output string
+	}{
+		{
+			policy: KeepDuplicateKeys,
+			input:  "message b=1 a=2 b=3 a=4 a=5",
+			output: "message b=1 a=2 b=3 a=4 a=5\n",
+		},
+		{
+			policy: LastKeyWins,
+			input:  "message b=1 a=2 b=3 a=4 c=6 a=5",
+			output: "message b=3 a=5 c=6\n",
+		},
+		{
+			policy: FirstKeyWins,
+			input:  "message b=1 a=2 b=3 a=4 c=6 a=5",
+			output: "message b=1 a=2 c=6\n",
+		},
+		{ // keys are case-sensitive
+			policy: LastKeyWins,
+			input:  "message a=1 A=2 a=3",
+			output: "message a=3 A=2\n",
+		},
+		{
+			policy: LastKeyWins,
+			sort:   true,
+			input:  "message b=1 a=2 b=3",
+			output: "message a=2 b=3\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.MergeDuplicateKeys(tt.policy)
+		output.SortKeys(tt.sort)
+		output.NoWrap()
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "message user=alice password=secret",
+			output: "message user=alice password=\"****\"\n",
+		},
+		{
+			input:  "message Token=abc Authorization=\"Bearer abc\"",
+			output: "message Token=\"****\" Authorization=\"****\"\n",
+		},
+		{
+			input:  "message api_secret=abc secretive=no",
+			output: "message api_secret=\"****\" secretive=no\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.Redact("password", "token", "AUTHORIZATION")
+		output.RedactFunc(func(key string) bool {
+			return strings.HasSuffix(key, "_secret")
+		})
+		var handled *Message
+		output.Handle(&testHandler{
+			handle: func(msg *Message) {
+				handled = msg
+			},
+		})
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got, want := handled.Text+" "+handled.List.String()+"\n", tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestNormalizeValues(t *testing.T) {
+	tests := []struct {
+		token  string
+		input  string
+		output string
+		logfmt string
+		json   string
+	}{
+		{
+			input:  "message done=True result=<nil> a=NIL b=false c=nothing",
+			output: "message done=true result=null a=null b=false c=nothing\n",
+			logfmt: "msg=message done=true result=null a=null b=false c=nothing\n",
+			json:   `{"text":"message","done":true,"result":null,"a":null,"b":false,"c":"nothing"}`,
+		},
+		{
+			token:  "nil",
+			input:  "message done=TRUE result=null",
+			output: "message done=true result=nil\n",
+			logfmt: "msg=message done=true result=nil\n",
+			json:   `{"text":"message","done":true,"result":null}`,
+		},
+		{ // quoted values are normalized
+			input:  `message done="false" result="nil"`,
+			output: "message done=false result=null\n",
+			logfmt: "msg=message done=false result=null\n",
+			json:   `{"text":"message","done":false,"result":null}`,
+		},
+		{ // nil values formatted by the kv package
+			input:  fmt.Sprint("message ", kv.With("p", (*int)(nil), "f", (func())(nil), "s", []int(nil))),
+			output: "message p=null f=null s=\"[]\"\n",
+			logfmt: "msg=message p=null f=null s=\"[]\"\n",
+			json:   `{"text":"message","p":null,"f":null,"s":"[]"}`,
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.NormalizeValues(tt.token)
+		var handled []byte
+		output.Handle(&testHandler{
+			handle: func(msg *Message) {
+				msg.Timestamp = time.Time{}
+				handled, _ = msg.MarshalJSON()
+			},
+		})
+		logger := log.New(ioutil.Discard, "", 0)
+		newLogWriter(output, logger).Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got, want := string(handled), tt.json; got != want {
+			t.Errorf("%d: json\n got=%s\nwant=%s", tn, got, want)
+		}
+
+		buf.Reset()
+		output.Logfmt()
+		newLogWriter(output, logger).Write([]byte(tt.input))
+		if got, want := buf.String(), tt.logfmt; got != want {
+			t.Errorf("%d: logfmt\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	err := kv.Wrap(kv.NewError("connection refused").With("host", "db1", "password", "secret"), "cannot query")
+	tests := []struct {
+		keys   []string
+		input  string
+		output string
+	}{
+		{
+			input:  fmt.Sprint("request failed ", kv.With("err", err, "id", 1)),
+			output: "request failed err=\"cannot query: connection refused\" host=db1 password=\"****\" id=1\n",
+		},
+		{
+			keys:   []string{"cause"},
+			input:  fmt.Sprint("request failed ", kv.With("err", err, "cause", err)),
+			output: "request failed err=\"cannot query: connection refused host=db1 password=secret\" cause=\"cannot query: connection refused\" host=db1 password=\"****\"\n",
+		},
+		{
+			input:  fmt.Sprint("request failed ", kv.With("error", "plain error", "id", 1)),
+			output: "request failed error=\"plain error\" id=1\n",
+		},
+		{ // the merged pairs of a two-level wrap, in the order of err.Keyvals()
+			input:  fmt.Sprint("request failed ", kv.With("err", kv.Wrap(err, "cannot load").With("user", "alice"))),
+			output: "request failed err=\"cannot load: cannot query: connection refused\" user=alice host=db1 password=\"****\"\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.ExpandErrors(tt.keys...)
+		output.Redact("password")
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestMaxValueWidth(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "a message that is longer than ten runes a=0123456789 b=0123456789a",
+			output: "a message that is longer than ten runes a=0123456789 b=012345678…\n",
+		},
+		{
+			input:  "message price=€€€€€€€€€€€ name=日本語日本語日本語日本語",
+			output: "message price=€€€€€€€€€… name=日本語日本語日本語…\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.MaxValueWidth(10)
+		output.printer = &terminalPrinter{
+			w:       &buf,
+			width:   func() int { return 999999 },
+			nocolor: true,
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestMaxValueWidthBytes(t *testing.T) {
+	defer kv.SetBytesFormat(kv.BytesText)
+	data := bytes.Repeat([]byte{0x00, 0xff}, 100)
+	tests := []struct {
+		format kv.BytesFormat
+		value  []byte
+		output string
+	}{
+		{
+			format: kv.BytesText,
+			value:  data,
+			output: "message data=\"00ff00ff0…\"\n",
+		},
+		{
+			format: kv.BytesText,
+			value:  []byte("abcdefghijklmnop"),
+			output: "message data=\"abcdefghi…\"\n",
+		},
+		{
+			format: kv.BytesHex,
+			value:  []byte("abcdefghijklmnop"),
+			output: "message data=\"616263646…\"\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		kv.SetBytesFormat(tt.format)
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.MaxValueWidth(10)
+		logger := log.New(output, "", 0)
+		logger.Println("message", kv.With("data", tt.value))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestMinLevel(t *testing.T) {
+	tests := []struct {
+		minLevel string
+		levelKey string
+		input    string
+		output   string
+	}{
+		{
+			minLevel: "info",
+			input:    "message level=debug",
+			output:   "",
+		},
+		{
+			minLevel: "info",
+			input:    "message level=INFO",
+			output:   "message level=INFO\n",
+		},
+		{
+			minLevel: "warn",
+			input:    "message level=warning",
+			output:   "message level=warning\n",
+		},
+		{
+			minLevel: "error",
+			input:    "message level=warn",
+			output:   "",
+		},
+		{
+			minLevel: "info",
+			input:    "debug: message",
+			output:   "",
+		},
+		{
+			minLevel: "info",
+			input:    "debug: message level=error",
+			output:   "debug: message level=error\n",
+		},
+		{
+			minLevel: "error",
+			input:    "message level=unknown",
+			output:   "message level=unknown\n",
+		},
+		{
+			minLevel: "info",
+			levelKey: "severity",
+			input:    "message level=trace Severity=debug",
+			output:   "",
+		},
+		{
+			minLevel: "info",
+			levelKey: "severity",
+			input:    "message level=trace",
+			output:   "message level=trace\n",
+		},
+		{
+			minLevel: "",
+			input:    "message level=trace",
+			output:   "message level=trace\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.MinLevel(tt.minLevel)
+		if tt.levelKey != "" {
+			output.LevelKey(tt.levelKey)
+		}
+		output.printer = &simplePrinter{w: &buf}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestLevelAliases(t *testing.T) {
+	aliases := map[string]string{
+		"err":     "error",
+		"E":       "error",
+		"[error]": "error",
+		"[warn]":  "warning",
+		"dbg":     "debug",
+	}
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "err: cannot open file a=1",
+			output: "error: cannot open file a=1\n",
+		},
+		{
+			input:  "ERR : cannot open file",
+			output: "error: cannot open file\n",
+		},
+		{
+			input:  "E: cannot open file",
+			output: "error: cannot open file\n",
+		},
+		{
+			input:  "[ERROR] cannot open file",
+			output: "error: cannot open file\n",
+		},
+		{
+			input:  "[warn]: slow request",
+			output: "warning: slow request\n",
+		},
+		{ // below the minimum level
+			input:  "dbg: message",
+			output: "",
+		},
+		{ // not followed by a colon
+			input:  "errors occurred",
+			output: "errors occurred\n",
+		},
+		{ // not followed by white space
+			input:  "[error]message",
+			output: "[error]message\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		c := NewCapture(80)
+		c.LevelAliases(aliases)
+		c.MinLevel("info")
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(c.Writer, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   string
+		tabWidth int
+		width    int
+	}{
+		{
+			input:    "12:34:56 name\tvalue\tx\tend",
+			output:   "12:34:56 name   value   x       end\n",
+			tabWidth: 8,
+		},
+		{
+			input:    "12:34:56 name\tvalue\tx\tend",
+			output:   "12:34:56 name   value   x   end\n",
+			tabWidth: 4,
+		},
+		{
+			input:    "12:34:56 name \t value",
+			output:   "12:34:56 name    value\n",
+			tabWidth: 4,
+		},
+		{
+			input:    "12:34:56 name\tvalue\tx\tend",
+			output:   "12:34:56 name value x end\n",
+			tabWidth: 0,
+		},
+		{
+			input: "12:34:56 name\tvalue\tx\tend",
+			output: "12:34:56 name   value   x\n" +
+				"         end\n",
+			tabWidth: 8,
+			width:    35,
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		width := tt.width
+		if width == 0 {
+			width = 120
+		}
+		output.printer = &terminalPrinter{
+			w:        &buf,
+			width:    func() int { return width },
+			nocolor:  true,
+			tabWidth: tt.tabWidth,
+		}
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestHardBreaks(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   string
+		tabWidth int
+	}{
+		{
+			input:  "12:34:56 first line\nsecond line",
+			output: "12:34:56 first line\n         second line\n",
+		},
+		{
+			input:  "12:34:56 first line\r\n\nthird   line\n  fourth line a=1",
+			output: "12:34:56 first line\n\n         third line\n          fourth line a=1\n",
+		},
+		{
+			input:    "12:34:56 panic: oops\n\tmain.go:12\n\tproc.go:250",
+			output:   "12:34:56 panic: oops\n                main.go:12\n                proc.go:250\n",
+			tabWidth: 8,
+		},
+		{
+			input:  "12:34:56 trailing newline\n",
+			output: "12:34:56 trailing newline\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.printer = &terminalPrinter{
+			w:        &buf,
+			width:    func() int { return 120 },
+			nocolor:  true,
+			tabWidth: tt.tabWidth,
+		}
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	tests := []struct {
+		flags  int
+		layout string
+		input  string
+		output string
+	}{
+		{
+			flags:  log.LstdFlags | log.LUTC,
+			layout: time.RFC3339,
+			input:  "2009/11/10 23:00:00 message a=1",
+			output: "2009-11-10T23:00:00Z message a=1\n",
+		},
+		{
+			flags:  log.Ltime | log.Lmicroseconds | log.LUTC,
+			layout: "15:04:05.000",
+			input:  "23:00:00.123456 message",
+			output: "23:00:00.123 message\n",
+		},
+		{
+			flags:  log.Ldate | log.LUTC,
+			layout: "Jan _2",
+			input:  "2009/11/10 message",
+			output: "Nov 10 message\n",
+		},
+		{
+			flags:  log.LstdFlags | log.LUTC,
+			layout: time.RFC3339,
+			input:  "2009/13/45 23:00:00 invalid date",
+			output: "2009/13/45 23:00:00 invalid date\n",
+		},
+		{
+			flags:  log.LstdFlags | log.LUTC,
+			layout: "",
+			input:  "2009/11/10 23:00:00 message",
+			output: "2009/11/10 23:00:00 message\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.TimeFormat(tt.layout)
+		logger := log.New(ioutil.Discard, "", tt.flags)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestSetHeaderRegexp(t *testing.T) {
+	tests := []struct {
+		prefix string
+		re     string
+		logfmt bool
+		input  string
+		output string
+	}{
+		{
+			re:     `^\[[^\]]*\] `,
+			input:  "[2024-01-02T15:04:05Z] error: cannot connect to the database host=db1 port=5432",
+			output: "[2024-01-02T15:04:05Z] error: cannot connect to the\n                       database host=db1 port=5432\n",
+		},
+		{
+			re:     `^\[[^\]]*\] `,
+			logfmt: true,
+			input:  "[2024-01-02T15:04:05Z] error: cannot connect host=db1",
+			output: "prefix=\"[2024-01-02T15:04:05Z]\" level=error msg=\"cannot connect\" host=db1\n",
+		},
+		{ // applied after the logger prefix
+			prefix: "lib: ",
+			re:     `^\[[^\]]*\] `,
+			input:  "lib: [2024-01-02T15:04:05Z] message",
+			output: "lib: [2024-01-02T15:04:05Z] message\n",
+		},
+		{ // no match
+			re:     `^\[[^\]]*\] `,
+			input:  "message [not a header] a=1",
+			output: "message [not a header] a=1\n",
+		},
+		{ // not anchored
+			re:     `\[[^\]]*\] `,
+			input:  "message [not a header] a=1",
+			output: "message [not a header] a=1\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		if tt.logfmt {
+			output.Logfmt()
+		} else {
+			output.printer = &terminalPrinter{
+				w:       &buf,
+				width:   func() int { return 56 },
+				nocolor: true,
+			}
+		}
+		output.SetHeaderRegexp(regexp.MustCompile(tt.re))
+		logger := log.New(ioutil.Discard, tt.prefix, 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestNoTime(t *testing.T) {
+	tests := []struct {
+		prefix string
+		flags  int
+		input  string
+		output string
+	}{
+		{
+			flags:  log.LstdFlags,
+			input:  "2009/11/10 23:00:00 this message is wrapped a=1",
+			output: "this message is wrapped\n    a=1\n",
+		},
+		{
+			flags:  log.LstdFlags | log.Lmicroseconds | log.Lshortfile,
+			input:  "2009/11/10 23:00:00.123456 file.go:23: this message a=1",
+			output: "file.go:23: this message\n    a=1\n",
+		},
+		{
+			prefix: "prog: ",
+			flags:  log.Ltime,
+			input:  "prog: 23:00:00 this message is wrapped a=1",
+			output: "prog: this message is\n      wrapped a=1\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.NoTime()
+		output.TimeFormat(time.RFC3339)
+		output.printer = &terminalPrinter{
+			w:       &buf,
+			width:   func() int { return 25 },
+			nocolor: true,
+		}
+		logger := log.New(ioutil.Discard, tt.prefix, tt.flags)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		label    string
+		flags    int
+		input    string
+		terminal string
+		simple   string
+		logfmt   string
+		handler  string
+	}{
+		{
+			label:    "api",
+			flags:    log.Ltime,
+			input:    "12:34:56 this message is wrapped a=1",
+			terminal: "12:34:56 [api] this message is wrapped\n               a=1\n",
+			simple:   "12:34:56 [api] this message is wrapped a=1\n",
+			logfmt:   "ts=\"12:34:56\" service=api msg=\"this message is wrapped\" a=1\n",
+			handler:  "this message is wrapped service=api a=1",
+		},
+		{
+			label:    "api",
+			flags:    log.Ltime | log.Lshortfile,
+			input:    "12:34:56 file.go:23: error: failed",
+			terminal: "12:34:56 [api] file.go:23: error: failed\n",
+			simple:   "12:34:56 [api] file.go:23: error: failed\n",
+			logfmt:   "ts=\"12:34:56\" service=api caller=\"file.go:23\" level=error msg=failed\n",
+			handler:  "failed service=api",
+		},
+		{ // no date or time
+			label:    "worker",
+			input:    "this message is wrapped onto the next line",
+			terminal: "[worker] this message is wrapped onto\n         the next line\n",
+			simple:   "[worker] this message is wrapped onto the next line\n",
+			logfmt:   "service=worker msg=\"this message is wrapped onto the next line\"\n",
+			handler:  "this message is wrapped onto the next line service=worker",
+		},
+		{ // no label
+			flags:    log.Ltime,
+			input:    "12:34:56 message a=1",
+			terminal: "12:34:56 message a=1\n",
+			simple:   "12:34:56 message a=1\n",
+			logfmt:   "ts=\"12:34:56\" msg=message a=1\n",
+			handler:  "message a=1",
+		},
+	}
+
+	for tn, tt := range tests {
+		logger := log.New(ioutil.Discard, "", tt.flags)
+
+		c := NewCapture(41)
+		c.Label(tt.label)
+		newLogWriter(c.Writer, logger).Write([]byte(tt.input))
+		if got, want := c.String(), tt.terminal; got != want {
+			t.Errorf("%d: terminal\n got=%q\nwant=%q", tn, got, want)
+		}
+
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.Label(tt.label)
+		var handled *Message
+		output.Handle(&testHandler{
+			handle: func(msg *Message) {
+				handled = msg
+			},
+		})
+		newLogWriter(output, logger).Write([]byte(tt.input))
+		if got, want := buf.String(), tt.simple; got != want {
+			t.Errorf("%d: simple\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got, want := strings.TrimSpace(handled.Text+" "+handled.List.String()), tt.handler; got != want {
+			t.Errorf("%d: handler\n got=%q\nwant=%q", tn, got, want)
+		}
+
+		buf.Reset()
+		output.Logfmt()
+		newLogWriter(output, logger).Write([]byte(tt.input))
+		if got, want := buf.String(), tt.logfmt; got != want {
+			t.Errorf("%d: logfmt\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestMaxLineBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   string
+		maxLine  int
+		terminal bool
+	}{
+		{
+			input:   "message text that is very long indeed a=1",
+			output:  "message text t… (truncated)\n",
+			maxLine: 30,
+		},
+		{
+			input:   "short a=1",
+			output:  "short a=1\n",
+			maxLine: 10,
+		},
+		{
+			input:   "日本語日本語日本語日本語",
+			output:  "日本語日… (truncated)\n",
+			maxLine: 30,
+		},
+		{
+			input:   "message text that is very long indeed a=1",
+			output:  "message text that is very long indeed a=1\n",
+			maxLine: 0,
+		},
+		{
+			input:    "message a=1",
+			output:   "message \x1b[0m… (truncated)\n",
+			maxLine:  30,
+			terminal: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.MaxLineBytes(tt.maxLine)
+		if tt.terminal {
+			output.printer = &terminalPrinter{
+				w:       &buf,
+				width:   func() int { return 80 },
+				theme:   DefaultTheme(),
+				maxLine: tt.maxLine,
+			}
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if tt.maxLine > 0 && buf.Len() > tt.maxLine {
+			t.Errorf("%d: got len=%d, want <= %d", tn, buf.Len(), tt.maxLine)
+		}
+	}
+}
+
+func TestSetRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	output.SetVerbose(false)
+	output.SetRenderer(func(msg *Message) ([]byte, error) {
+		if msg.Text == "fail" {
+			return nil, errors.New("cannot render")
+		}
+		return []byte(fmt.Sprintf("[%s] %s|%s|%s|%v\n", msg.Prefix, msg.File, msg.Level, msg.Text, msg.List)), nil
+	})
+	logger := log.New(ioutil.Discard, "prog:", log.Ltime|log.Lshortfile)
+	writer := newLogWriter(output, logger)
+	writer.Write([]byte("prog:12:34:56 file.go:23: error: message text a=1 b=2"))
+	writer.Write([]byte("prog:12:34:56 file.go:23: debug: suppressed"))
+	writer.Write([]byte("prog:12:34:56 file.go:23: fail"))
+	output.SetRenderer(nil)
+	writer.Write([]byte("prog:12:34:56 file.go:23: restored"))
+	want := "[prog:] file.go:23|error|message text|a=1 b=2\n" +
+		"prog:12:34:56 file.go:23: fail\n" +
+		"prog:12:34:56 file.go:23: restored\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestNarrowTerminal(t *testing.T) {
+	tests := []struct {
+		prefix string
+		flags  int
+		input  string
+		output string
+	}{
+		{
+			prefix: "long-program-name: ",
+			flags:  log.Ltime,
+			input:  "long-program-name: 12:34:56 the quick brown fox a=1 b=2",
+			output: "long-program-name: 12:34:56\n" +
+				"    the quick brown\n" +
+				"    fox a=1 b=2\n",
+		},
+		{
+			flags:  log.Lshortfile,
+			input:  "a/very/long/file/name.go:123: message",
+			output: "a/very/long/file/name.go:123:\n    message\n",
+		},
+		{
+			input:  "supercalifragilisticexpialidocious word",
+			output: "supercalifragilisticexpialidocious\n    word\n",
+		},
+		{
+			input:  "msg a=1",
+			output: "msg a=1\n",
+		},
+		{
+			input:  "error: a=1",
+			output: "error: a=1\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.printer = &terminalPrinter{
+			w:       &buf,
+			width:   func() int { return 20 },
+			nocolor: true,
+		}
+		logger := log.New(ioutil.Discard, tt.prefix, tt.flags)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestMinWidth(t *testing.T) {
+	tests := []struct {
+		minWidth int
+		input    string
+		output   string
+	}{
+		{
+			input:  "the quick brown fox jumps over the lazy dog a=1",
+			output: "the quick brown fox…\n",
+		},
+		{
+			input:  "message a=1",
+			output: "message a=1\n",
+		},
+		{ // each line is truncated
+			input:  "first line of the message\nsecond line of the message",
+			output: "first line of the m…\n    second line of …\n",
+		},
+		{
+			minWidth: 15,
+			input:    "the quick brown fox jumps",
+			output:   "the quick brow…\n",
+		},
+		{ // wider than the minimum
+			minWidth: 8,
+			input:    "the quick brown fox",
+			output:   "the quick\n    brown\n    fox\n",
+		},
+		{ // no minimum
+			minWidth: -1,
+			input:    "the quick brown fox",
+			output:   "the quick\n    brown\n    fox\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		c := NewCapture(10)
+		c.Indent("    ")
+		if tt.minWidth != 0 {
+			c.MinWidth(tt.minWidth)
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(c.Writer, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "the quick brown fox jumps over the lazy dog a=1 b=2",
+			output: "the quick brown fox jumps over the lazy dog a=1 b=2\n",
+		},
+		{ // line breaks in the text are kept
+			input:  "first line of the message\nsecond line of the message",
+			output: "first line of the message\n    second line of the message\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		c := NewCapture(10)
+		c.Indent("    ")
+		c.Unlimited()
+		writer := newLogWriter(c.Writer, log.New(ioutil.Discard, "", 0))
+		writer.Write([]byte(tt.input))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+
+	// color is displayed inline
+	c := NewCapture(10)
+	c.ForceColor()
+	c.Unlimited()
+	writer := newLogWriter(c.Writer, log.New(ioutil.Discard, "", 0))
+	writer.Write([]byte("error: the quick brown fox jumps over the lazy dog a=1"))
+	got := c.String()
+	if !strings.Contains(got, "\x1b[") {
+		t.Errorf("missing color: %q", got)
+	}
+	if n := strings.Count(got, "\n"); n != 1 {
+		t.Errorf("got %d lines, want 1: %q", n, got)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	inputs := []string{
+		"message",
+		"the quick brown fox",
+		"the quick brown fox jumps over the lazy dog",
+		"comma, separated, words",
+		" leading space",
+		"trailing space ",
+		"two  spaces",
+		"tab\tseparated",
+		"line\nbreak",
+		"\x1b[31mred\x1b[0m text",
+		"caf\u00e9 au lait",
+		"",
+	}
+	for _, col := range []int{0, 10, 30} {
+		for _, input := range inputs {
+			start := func() *terminalPrinter {
+				p := &terminalPrinter{nocolor: true, buf: &bytes.Buffer{}, indent: 4, bol: true}
+				p.Space(col)
+				return p
+			}
+			want := start()
+			wrap.Text(want, []byte(input), 40)
+			got := start()
+			got.printText([]byte(input), 40)
+			if got.buf.String() != want.buf.String() || got.col != want.col || got.bol != want.bol {
+				t.Errorf("%d %q:\n got=%q col=%d bol=%v\nwant=%q col=%d bol=%v",
+					col, input, got.buf, got.col, got.bol, want.buf, want.col, want.bol)
+			}
+		}
+	}
+}
+
+func TestTruncateLines(t *testing.T) {
+	tests := []struct {
+		input  string
+		width  int
+		output string
+	}{
+		{
+			input:  "abcdef",
+			width:  6,
+			output: "abcdef",
+		},
+		{
+			input:  "abcdefg",
+			width:  6,
+			output: "abcde…",
+		},
+		{
+			input:  "日本語日本語",
+			width:  6,
+			output: "日本…",
+		},
+		{
+			input:  "\x1b[31mabcdefg\x1b[0m\r\nab\r\n",
+			width:  6,
+			output: "\x1b[31mabcde\x1b[0m…\r\nab\r\n",
+		},
+		{
+			input:  "\x1b[31mabc\x1b[0m",
+			width:  6,
+			output: "\x1b[31mabc\x1b[0m",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		buf.WriteString(tt.input)
+		truncateLines(&buf, tt.width)
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestColorWidth(t *testing.T) {
+	const width = 40
+	inputs := []string{
+		"error: cannot connect to the database server because it is down host=db1 port=5432",
+		"warning: disk space is getting low on the server volume=/var/lib/data free=1%",
+		"info: request completed successfully in a reasonable time status=200 elapsed=15ms",
+		"request req-123 failed with a " + "\x1b[31mcolored\x1b[0m error, retrying req-123 x=req-123",
+		"error: " + "\x1b[1;31mfailed\x1b[0m to open file err=" + "\x1b[31mpermission\x1b[0m",
+		"debug: a b c d e f g h i j k l m n o p q r s t u v w x y z a=1 b=2 c=3 d=4 e=5",
+	}
+	ansi := regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+	for tn, input := range inputs {
+		// the output with color should be the same as the output
+		// without color for the input without escape sequences
+		var outputs [2]string
+		for i, nocolor := range []bool{false, true} {
+			if nocolor {
+				input = ansi.ReplaceAllString(input, "")
+			}
+			var buf bytes.Buffer
+			output := NewWriter(&buf)
+			output.printer = &terminalPrinter{
+				w:         &buf,
+				width:     func() int { return width },
+				theme:     DefaultTheme(),
+				nocolor:   nocolor,
+				highlight: regexp.MustCompile(`req-\d+`),
+			}
+			logger := log.New(ioutil.Discard, "prog: ", log.Ltime)
+			writer := newLogWriter(output, logger)
+			writer.Write([]byte("prog: 12:34:56 " + input))
+			outputs[i] = ansi.ReplaceAllString(buf.String(), "")
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(outputs[0], "\n"), "\n") {
+			if n := terminal.StringWidth(line); n > width {
+				t.Errorf("%d: line is %d columns wide, want <= %d: %q", tn, n, width, line)
+			}
+		}
+		if got, want := outputs[0], outputs[1]; got != want {
+			t.Errorf("%d: color changes wrapping:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestEscapeSequencesInText(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "the \x1b[31mquick brown\x1b[0m fox jumps over",
+			output: "the \x1b[31mquick brown\x1b[0m\n    fox jumps\n    over\n",
+		},
+		{ // escape sequences between words
+			input:  "the quick brown fox \x1b[31m jumps \x1b[0m over",
+			output: "the quick brown\n    fox\x1b[31m jumps\x1b[0m\n    over\n",
+		},
+		{ // escape sequence at the end of a line
+			input:  "aaaaaaaaaaaaaa \x1b[31m bbb \x1b[0m",
+			output: "aaaaaaaaaaaaaa\x1b[31m\n    bbb\x1b[0m\n",
+		},
+		{ // values are not split
+			input:  "message a=\x1b[32mgreen\x1b[0m b=\x1b[1;31mred\x1b[0m",
+			output: "message a=\x1b[32mgreen\x1b[0m\n    b=\x1b[1;31mred\x1b[0m\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.printer = &terminalPrinter{
+			w:       &buf,
+			width:   func() int { return 16 },
+			nocolor: true,
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+// fakeTerminal is a writer that reports whether it is a terminal.
+type fakeTerminal struct {
+	bytes.Buffer
+	terminal bool
+}
+
+func (t *fakeTerminal) IsTerminal() bool { return t.terminal }
+
+// wrappedWriter wraps another writer.
+type wrappedWriter struct {
+	io.Writer
+}
+
+func (w wrappedWriter) Unwrap() io.Writer { return w.Writer }
+
+func TestIsTerminal(t *testing.T) {
+	tests := []struct {
+		w    io.Writer
+		want bool
+	}{
+		{w: &bytes.Buffer{}, want: false},
+		{w: &fakeTerminal{terminal: true}, want: true},
+		{w: &fakeTerminal{terminal: false}, want: false},
+		{w: wrappedWriter{&fakeTerminal{terminal: true}}, want: true},
+		{w: wrappedWriter{wrappedWriter{&fakeTerminal{terminal: true}}}, want: true},
+		{w: wrappedWriter{&bytes.Buffer{}}, want: false},
+		{w: wrappedWriter{}, want: false},
+	}
+	for tn, tt := range tests {
+		if got, want := IsTerminal(tt.w), tt.want; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
+		}
+		_, isTerminal := newPrinter(tt.w, printerOptions{}).(*terminalPrinter)
+		if got, want := isTerminal, tt.want; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		input   string
+		output  string
+		nocolor bool
+	}{
+		{
+			input:  "request req-123 failed, retrying req-123 x=req-123",
+			output: "request \x1b[7mreq-123\x1b[27m failed,\n    retrying \x1b[7mreq-123\x1b[27m\n    \x1b[0;36mx\x1b[0m=\x1b[0;96m\x1b[7mreq-123\x1b[27m\x1b[0m\n",
+		},
+		{
+			input:  "prefix-req-456-suffix",
+			output: "prefix-\x1b[7mreq-456\x1b[27m-suffix\n",
+		},
+		{
+			input:   "request req-123",
+			output:  "request req-123\n",
+			nocolor: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.printer = &terminalPrinter{
+			w:         &buf,
+			width:     func() int { return 25 },
+			theme:     DefaultTheme(),
+			nocolor:   tt.nocolor,
+			highlight: regexp.MustCompile(`req-\d+`),
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestDefaultWidth(t *testing.T) {
+	if value, ok := os.LookupEnv("COLUMNS"); ok {
+		defer os.Setenv("COLUMNS", value)
+		os.Unsetenv("COLUMNS")
+	}
+	input := "this is a message that is too long for a narrow terminal"
+	tests := []struct {
+		width  int
+		output string
+	}{
+		{
+			width:  20,
+			output: "this is a message\n    that is too\n    long for a\n    narrow terminal\n",
+		},
+		{
+			width:  0,
+			output: input + "\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		// a fake terminal has no file descriptor, so its size is not known
+		term := &fakeTerminal{terminal: true}
+		output := NewWriter(term)
+		output.DefaultWidth(tt.width)
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(input))
+		if got, want := term.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+
+	if got, want := (printerOptions{}).fallbackWidth(), defaultTerminalWidth; got != want {
+		t.Errorf("got=%d, want=%d", got, want)
+	}
+}
+
+func TestWidthPadding(t *testing.T) {
+	if got, want := NewCapture(20).printer.(*terminalPrinter).lineWidth(), 19; got != want {
+		t.Errorf("default: got=%d, want=%d", got, want)
+	}
+
+	tests := []struct {
+		padding   int
+		lineWidth int
+		output    string
+	}{
+		{
+			padding:   1,
+			lineWidth: 19,
+			output:    "the quick brown\n    foxy jumps\n",
+		},
+		{
+			padding:   0,
+			lineWidth: 20,
+			output:    "the quick brown foxy\n    jumps\n",
+		},
+		{
+			padding:   6,
+			lineWidth: 14,
+			output:    "the quick\n    brown foxy\n    jumps\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		c := NewCapture(20)
+		c.WidthPadding(tt.padding)
+		p, ok := c.printer.(*terminalPrinter)
+		if !ok {
+			t.Fatalf("%d: got=%T, want=%T", tn, c.printer, p)
+		}
+		if got, want := p.lineWidth(), tt.lineWidth; got != want {
+			t.Errorf("%d: got=%d, want=%d", tn, got, want)
+		}
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(c.Writer, logger)
+		writer.Write([]byte("the quick brown foxy jumps"))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	if value, ok := os.LookupEnv("COLUMNS"); ok {
+		defer os.Setenv("COLUMNS", value)
+	} else {
+		defer os.Unsetenv("COLUMNS")
+	}
+	tests := []struct {
+		env  string
+		want int
+	}{
+		{env: "", want: 99},
+		{env: "80", want: 80},
+		{env: "0", want: 99},
+		{env: "-1", want: 99},
+		{env: "wide", want: 99},
+	}
+	for tn, tt := range tests {
+		os.Setenv("COLUMNS", tt.env)
+		if got, want := columns(99), tt.want; got != want {
+			t.Errorf("%d: got=%d, want=%d", tn, got, want)
+		}
+	}
+
+	os.Setenv("COLUMNS", "30")
+	term := &fakeTerminal{terminal: true}
+	output := NewWriter(term)
+	output.DefaultWidth(10)
+	logger := log.New(ioutil.Discard, "", 0)
+	writer := newLogWriter(output, logger)
+	writer.Write([]byte("this is a message that is wrapped at thirty"))
+	if got, want := term.String(), "this is a message that is\n    wrapped at thirty\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	logger := log.New(ioutil.Discard, "", 0)
+	output.Attach(logger)
+	ctx := kv.From(context.Background()).With("req", 1, "user", "alice smith")
+
+	n, err := output.WriteContext(ctx, []byte("error: message text a=1\n"))
+	if got, want := n, 24; got != want || err != nil {
+		t.Errorf("got=(%d, %v), want=(%d, nil)", got, err, want)
+	}
+	output.WriteContext(context.Background(), []byte("no fields"))
+	logger.Println("from logger")
+	output.ContextFields(func(ctx context.Context) kv.List {
+		return kv.List{"trace_id", ctx.Value("trace")}
+	})
+	output.WriteContext(context.WithValue(ctx, "trace", "abc123"), []byte("traced"))
+	output.ContextFields(func(ctx context.Context) kv.List {
+		return kv.List{kv.With("q", `say "hi"`), "missing key"}
+	})
+	output.WriteContext(ctx, []byte("nested"))
+
+	want := "error: message text a=1 req=1 user=\"alice smith\"\n" +
+		"no fields\n" +
+		"from logger\n" +
+		"traced trace_id=abc123\n" +
+		"nested q=\"say \\\"hi\\\"\" msg=\"missing key\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+
+	output.Close()
+	if _, err := output.WriteContext(ctx, []byte("closed")); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "message a=1",
+			output: "message a=1\n",
+		},
+		{
+			input:  "error: this message is wrapped a=1 b=2",
+			output: "error: this message is\n    wrapped a=1 b=2\n",
+		},
+		{ // suppressed
+			input:  "debug: message",
+			output: "",
+		},
+	}
+
+	for tn, tt := range tests {
+		c := NewCapture(26)
+		c.Indent("    ")
+		c.Suppress("debug")
+		b, err := c.Render([]byte(tt.input))
+		if err != nil {
+			t.Errorf("%d: %v", tn, err)
+		}
+		if got, want := string(b), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got := c.String(); got != "" {
+			t.Errorf("%d: got=%q, want nothing written", tn, got)
+		}
+		c.Write([]byte(tt.input))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+
+	c := NewCapture(80)
+	c.Close()
+	if _, err := c.Render([]byte("closed")); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+	if _, err := c.Write([]byte("closed")); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+}
+
+func TestRenderPipeline(t *testing.T) {
+	// messages are sampled and collapsed in the same way as by Write
+	c := NewCapture(80)
+	c.Sample(2)
+	c.Dedup(time.Hour)
+	var rendered []string
+	for _, input := range []string{"debug: one", "debug: two", "debug: three", "message", "message"} {
+		b, err := c.Render([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rendered = append(rendered, string(b))
+	}
+	if got, want := rendered, []string{"debug: one\n", "", "debug: three\n", "message\n", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+
+	// the sinks of a tee writer and chained writers render the message
+	sink1 := NewCapture(80)
+	sink2 := NewCapture(80)
+	sink2.Logfmt()
+	next := NewCapture(80)
+	next.Label("next")
+	chained := NewWriter(next.Writer)
+	b, err := Tee(sink1.Writer, sink2.Writer, chained).Render([]byte("message a=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "message a=1\n" + "msg=message a=1\n" + "[next] message a=1\n"
+	if got := string(b); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+	for _, c := range []*Capture{sink1, sink2, next} {
+		if got := c.String(); got != "" {
+			t.Errorf("got=%q, want nothing written", got)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	output.Sample(3)
+	logger := log.New(ioutil.Discard, "", 0)
+	writer := newLogWriter(output, logger)
+	for i := 0; i < 7; i++ {
+		writer.Write([]byte(fmt.Sprintf("debug: message n=%d", i)))
+		if i%2 == 0 {
+			writer.Write([]byte(fmt.Sprintf("TRACE: message n=%d", i)))
+		}
+		if i < 2 {
+			writer.Write([]byte(fmt.Sprintf("error: message n=%d", i)))
+			writer.Write([]byte(fmt.Sprintf("message n=%d", i)))
+		}
+	}
+	want := "debug: message n=0\n" +
+		"trace: message n=0\n" +
+		"error: message n=0\n" +
+		"message n=0\n" +
+		"error: message n=1\n" +
+		"message n=1\n" +
+		"debug: message n=3\n" +
+		"debug: message n=6\n" +
+		"trace: message n=6\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestCacheWidth(t *testing.T) {
+	var calls int
+	width := func() int {
+		calls++
+		return 80 + calls
+	}
+
+	cached := cacheWidth(width, time.Hour)
+	for i := 0; i < 3; i++ {
+		if got, want := cached(), 81; got != want {
+			t.Errorf("%d: got=%v, want=%v", i, got, want)
+		}
+	}
+	if got, want := calls, 1; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+
+	calls = 0
+	uncached := cacheWidth(width, 0)
+	for i := 0; i < 3; i++ {
+		if got, want := uncached(), 81+i; got != want {
+			t.Errorf("%d: got=%v, want=%v", i, got, want)
+		}
+	}
+}
+
+func TestNoWrap(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	output.NoWrap()
+	if _, ok := output.printer.(*simplePrinter); !ok {
+		t.Fatalf("got=%T, want=%T", output.printer, &simplePrinter{})
+	}
+	output.SetVerbose(false)
+	output.Redact("password")
+	logger := log.New(ioutil.Discard, "", log.Ltime)
+	writer := newLogWriter(output, logger)
+	writer.Write([]byte("12:34:56 debug: suppressed"))
+	writer.Write([]byte("12:34:56 this is a message that is long enough to be wrapped on most terminals user=alice password=secret"))
+	want := "12:34:56 this is a message that is long enough to be wrapped on most terminals user=alice password=\"****\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestNoText(t *testing.T) {
+	tests := []struct {
+		flags  int
+		input  string
+		output string
+	}{
+		{
+			input:  "event=startup port=8080",
+			output: "event=startup port=8080\n",
+		},
+		{
+			flags:  log.Ltime,
+			input:  "prog: 12:34:56 event=startup port=8080",
+			output: "prog: 12:34:56 event=startup port=8080\n",
+		},
+		{
+			flags:  log.Ltime,
+			input:  "prog: 12:34:56 info: event=startup",
+			output: "prog: 12:34:56 info: event=startup\n",
+		},
+		{
+			flags:  log.Ltime | log.Lshortfile,
+			input:  "prog: 12:34:56 file.go:23: event=startup",
+			output: "prog: 12:34:56 file.go:23: event=startup\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var tbuf, sbuf bytes.Buffer
+		terminal := NewWriter(&tbuf)
+		terminal.printer = &terminalPrinter{
+			w:       &tbuf,
+			width:   func() int { return 80 },
+			nocolor: true,
+		}
+		simple := NewWriter(&sbuf)
+		simple.NoWrap()
+		prefix := ""
+		if tt.flags != 0 {
+			prefix = "prog: "
+		}
+		for _, output := range []*Writer{terminal, simple} {
+			logger := log.New(ioutil.Discard, prefix, tt.flags)
+			writer := newLogWriter(output, logger)
+			writer.Write([]byte(tt.input))
+		}
+		if got, want := tbuf.String(), tt.output; got != want {
+			t.Errorf("%d: terminal:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got, want := sbuf.String(), tt.output; got != want {
+			t.Errorf("%d: simple:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestAlignKeys(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "12:34:56 short message a=1 bb=2",
+			output: "12:34:56 short message a=1 bb=2\n",
+		},
+		{
+			input: "12:34:56 this message is longer a=1 bbbb=2 cc=\"three four\"",
+			output: "12:34:56 this message is longer\n" +
+				"         a   =1\n" +
+				"         bbbb=2\n" +
+				"         cc  =three four\n",
+		},
+		{
+			input: "no time but a long message text name=日本語 id=1",
+			output: "no time but a long message text\n" +
+				"    name=日本語\n" +
+				"    id  =1\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.printer = &terminalPrinter{
+			w:         &buf,
+			width:     func() int { return 41 },
+			nocolor:   true,
+			alignKeys: true,
+		}
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		if !strings.HasPrefix(tt.input, "12:") {
+			logger.SetFlags(0)
+		}
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestKeyvalsFirst(t *testing.T) {
+	tests := []struct {
+		input    string
+		terminal string
+		simple   string
+	}{
+		{
+			input:    "12:34:56 info: message a=1 bb=2",
+			terminal: "12:34:56 info: a=1 bb=2 | message\n",
+			simple:   "12:34:56 info: a=1 bb=2 | message\n",
+		},
+		{
+			input: "12:34:56 this message is longer and wraps a=1 bbbb=2 cc=\"three four\"",
+			terminal: "12:34:56 a=1 bbbb=2 cc=three four | this\n" +
+				"         message is longer and wraps\n",
+			simple: "12:34:56 a=1 bbbb=2 cc=\"three four\" | this message is longer and wraps\n",
+		},
+		{
+			input:    "12:34:56 no key/value pairs",
+			terminal: "12:34:56 no key/value pairs\n",
+			simple:   "12:34:56 no key/value pairs\n",
+		},
+		{
+			input:    "12:34:56 a=1 b=2",
+			terminal: "12:34:56 a=1 b=2\n",
+			simple:   "12:34:56 a=1 b=2\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+
+		c := NewCapture(41)
+		c.KeyvalsFirst()
+		newLogWriter(c.Writer, logger).Write([]byte(tt.input))
+		if got, want := c.String(), tt.terminal; got != want {
+			t.Errorf("%d: terminal\n got=%q\nwant=%q", tn, got, want)
+		}
+
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.KeyvalsFirst()
+		newLogWriter(output, logger).Write([]byte(tt.input))
+		if got, want := buf.String(), tt.simple; got != want {
+			t.Errorf("%d: simple\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestKeyvalSeparator(t *testing.T) {
+	tests := []struct {
+		sep      string
+		kvFirst  bool
+		input    string
+		terminal string
+		simple   string
+	}{
+		{
+			sep:      " » ",
+			input:    "12:34:56 message a=1 b=2",
+			terminal: "12:34:56 message » a=1 b=2\n",
+			simple:   "12:34:56 message » a=1 b=2\n",
+		},
+		{ // separator at the wrap point
+			sep:      " | ",
+			input:    "12:34:56 this message text is just long enough a=1 b=2",
+			terminal: "12:34:56 this message text is just long\n         enough | a=1 b=2\n",
+			simple:   "12:34:56 this message text is just long enough | a=1 b=2\n",
+		},
+		{ // separator wraps to the next line
+			sep:      " | ",
+			input:    "12:34:56 the message texts nearly fills a=1",
+			terminal: "12:34:56 the message texts nearly fills\n         | a=1\n",
+			simple:   "12:34:56 the message texts nearly fills | a=1\n",
+		},
+		{ // no separator without text
+			sep:      " | ",
+			input:    "12:34:56 a=1",
+			terminal: "12:34:56 a=1\n",
+			simple:   "12:34:56 a=1\n",
+		},
+		{ // no separator without key/value pairs
+			sep:      " | ",
+			input:    "12:34:56 message",
+			terminal: "12:34:56 message\n",
+			simple:   "12:34:56 message\n",
+		},
+		{
+			sep:      " » ",
+			kvFirst:  true,
+			input:    "12:34:56 message a=1 b=2",
+			terminal: "12:34:56 a=1 b=2 » message\n",
+			simple:   "12:34:56 a=1 b=2 » message\n",
+		},
+		{ // default
+			sep:      "",
+			input:    "12:34:56 message a=1 b=2",
+			terminal: "12:34:56 message a=1 b=2\n",
+			simple:   "12:34:56 message a=1 b=2\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+
+		c := NewCapture(41)
+		c.KeyvalSeparator(tt.sep)
+		if tt.kvFirst {
+			c.KeyvalsFirst()
+		}
+		newLogWriter(c.Writer, logger).Write([]byte(tt.input))
+		if got, want := c.String(), tt.terminal; got != want {
+			t.Errorf("%d: terminal\n got=%q\nwant=%q", tn, got, want)
+		}
+
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.KeyvalSeparator(tt.sep)
+		if tt.kvFirst {
+			output.KeyvalsFirst()
+		}
+		newLogWriter(output, logger).Write([]byte(tt.input))
+		if got, want := buf.String(), tt.simple; got != want {
+			t.Errorf("%d: simple\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestKeyvalColumn(t *testing.T) {
+	tests := []struct {
+		column int
+		input  string
+		want   string
+	}{
+		{
+			column: 24,
+			input:  "12:34:56 message a=1 b=2",
+			want:   "12:34:56 message        a=1 b=2\n",
+		},
+		{ // text ends just before the column
+			column: 17,
+			input:  "12:34:56 message a=1 b=2",
+			want:   "12:34:56 message a=1 b=2\n",
+		},
+		{ // text reaches the column
+			column: 16,
+			input:  "12:34:56 message a=1 b=2",
+			want:   "12:34:56 message\n         a=1 b=2\n",
+		},
+		{ // pairs wrap as usual after padding
+			column: 24,
+			input:  "12:34:56 message a=1 b=2 c=3 d=4 e=5",
+			want:   "12:34:56 message        a=1 b=2 c=3 d=4\n         e=5\n",
+		},
+		{ // no padding without text
+			column: 24,
+			input:  "12:34:56 a=1 b=2",
+			want:   "12:34:56 a=1 b=2\n",
+		},
+		{ // column beyond the line width
+			column: 50,
+			input:  "12:34:56 message a=1 b=2",
+			want:   "12:34:56 message a=1 b=2\n",
+		},
+		{ // default
+			input: "12:34:56 message a=1 b=2",
+			want:  "12:34:56 message a=1 b=2\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		c := NewCapture(41)
+		c.KeyvalColumn(tt.column)
+		newLogWriter(c.Writer, logger).Write([]byte(tt.input))
+		if got, want := c.String(), tt.want; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestMaxLines(t *testing.T) {
+	var pairs []string
+	for i := 0; i < 20; i++ {
+		pairs = append(pairs, fmt.Sprintf("key%02d=%d", i, i))
+	}
+	long := strings.Join(pairs, " ")
+	tests := []struct {
+		maxLines   int
+		expandJSON bool
+		input      string
+		want       string
+	}{
+		{
+			maxLines: 1,
+			input:    "12:34:56 message " + long,
+			want:     "12:34:56 message key00=0 key01=1 key02=2\n         key03=3 key04=4 key05=5 key06=6\n         … (truncated, 5 more)\n",
+		},
+		{ // the last continuation line is printed in full
+			maxLines: 6,
+			input:    "12:34:56 message " + long,
+			want:     "12:34:56 message key00=0 key01=1 key02=2\n         key03=3 key04=4 key05=5 key06=6\n         key07=7 key08=8 key09=9\n         key10=10 key11=11 key12=12\n         key13=13 key14=14 key15=15\n         key16=16 key17=17 key18=18\n         key19=19\n",
+		},
+		{
+			maxLines:   2,
+			expandJSON: true,
+			input:      `12:34:56 message doc="{\"a\":1,\"b\":2,\"c\":3}"`,
+			want:       "12:34:56 message doc={\n           \"a\": 1,\n           \"b\": 2,\n         … (truncated, 2 more)\n",
+		},
+		{
+			maxLines: 1,
+			input:    "12:34:56 message a=1 b=2",
+			want:     "12:34:56 message a=1 b=2\n",
+		},
+		{ // not limited
+			input: "12:34:56 message " + long,
+			want:  "12:34:56 message key00=0 key01=1 key02=2\n         key03=3 key04=4 key05=5 key06=6\n         key07=7 key08=8 key09=9\n         key10=10 key11=11 key12=12\n         key13=13 key14=14 key15=15\n         key16=16 key17=17 key18=18\n         key19=19\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		c := NewCapture(41)
+		c.MaxLines(tt.maxLines)
+		if tt.expandJSON {
+			c.ExpandJSON()
+		}
+		newLogWriter(c.Writer, logger).Write([]byte(tt.input))
+		if got, want := c.String(), tt.want; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestLimitLines(t *testing.T) {
+	tests := []struct {
+		input string
+		n     int
+		want  string
+	}{
+		{input: "a\n  b\n  c\n  d", n: 1, want: "a\n  b\n  … (truncated, 2 more)"},
+		{input: "a\r\n  b\r\n  c", n: 1, want: "a\r\n  b\r\n  … (truncated, 1 more)"},
+		{input: "\x1b[0;31ma\n  b\x1b[0m\n  c", n: 0, want: "\x1b[0;31ma\x1b[0m\n  … (truncated, 2 more)"},
+		{input: "a\n  b", n: 1, want: "a\n  b"},
+		{input: "a", n: 1, want: "a"},
+	}
+	for tn, tt := range tests {
+		buf := bytes.NewBufferString(tt.input)
+		limitLines(buf, tt.n, "  ")
+		if got, want := buf.String(), tt.want; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestExpandJSON(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input: `12:34:56 request payload="{\"id\":1,\"tags\":[\"a\",\"b\"]}" status=200`,
+			output: "12:34:56 request payload={\n" +
+				"           \"id\": 1,\n" +
+				"           \"tags\": [\n" +
+				"             \"a\",\n" +
+				"             \"b\"\n" +
+				"           ]\n" +
+				"         } status=200\n",
+		},
+		{
+			input: `12:34:56 list items=[1,2]`,
+			output: "12:34:56 list items=[\n" +
+				"           1,\n" +
+				"           2\n" +
+				"         ]\n",
+		},
+		{ // not JSON
+			input:  `12:34:56 message a={not-json} b=[] c="{}"`,
+			output: "12:34:56 message a={not-json} b=[] c={}\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		c := NewCapture(80)
+		c.ExpandJSON()
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		newLogWriter(c.Writer, logger).Write([]byte(tt.input))
+		if got, want := c.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestChainedWriters(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input: "lib: 2009/11/10 12:34:56 file.go:23: warning: message text that is long enough to wrap a=1 b=\"x y\"",
+			output: "lib: 2009/11/10 12:34:56 file.go:23: warning: message text that is\n" +
+				"                         long enough to wrap a=1 b=x y\n",
+		},
+		{
+			input:  "lib: 2009/11/10 12:34:56 file.go:23: a=1",
+			output: "lib: 2009/11/10 12:34:56 file.go:23: a=1\n",
+		},
+		{ // the next writer's options apply
+			input:  "lib: 2009/11/10 12:34:56 file.go:23: debug: message",
+			output: "",
+		},
+	}
+	for tn, tt := range tests {
+		c := NewCapture(70)
+		c.Suppress("debug")
+		nexts := []io.Writer{
+			// the logger of the next writer has a different prefix and flags
+			newLogWriter(c.Writer, log.New(ioutil.Discard, "app: ", log.Ltime)),
+			c.Writer,
+		}
+		for _, next := range nexts {
+			c.Reset()
+			output := NewWriter(next)
+			writer := newLogWriter(output, log.New(ioutil.Discard, "lib: ", log.LstdFlags|log.Lshortfile))
+			writer.Write([]byte(tt.input))
+			if got, want := c.String(), tt.output; got != want {
+				t.Errorf("%d: %T:\n got=%q\nwant=%q", tn, next, got, want)
+			}
+		}
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	tests := []struct {
+		setup  func(w *Writer, buf *bytes.Buffer)
+		output string
+	}{
+		{
+			setup:  func(w *Writer, buf *bytes.Buffer) {},
+			output: "message text that wraps a=1\n",
+		},
+		{
+			setup:  func(w *Writer, buf *bytes.Buffer) { w.CRLF() },
+			output: "message text that wraps a=1\r\n",
+		},
+		{
+			setup: func(w *Writer, buf *bytes.Buffer) {
+				w.CRLF()
+				w.Logfmt()
+			},
+			output: "msg=\"message text that wraps\" a=1\r\n",
+		},
+		{
+			setup: func(w *Writer, buf *bytes.Buffer) {
+				w.CRLF()
+				w.printer = &terminalPrinter{
+					w:       buf,
+					width:   func() int { return 21 },
+					nocolor: true,
+					crlf:    true,
+				}
+			},
+			output: "message text that\r\n    wraps a=1\r\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		tt.setup(output, &buf)
+		logger := log.New(ioutil.Discard, "", 0)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte("message text that wraps a=1"))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+// closeBuffer records calls to Flush and Close.
+type closeBuffer struct {
+	bytes.Buffer
+	calls []string
+}
+
+func (b *closeBuffer) Flush() error {
+	b.calls = append(b.calls, "flush")
+	return nil
+}
+
+func (b *closeBuffer) Close() error {
+	b.calls = append(b.calls, "close")
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	var buf closeBuffer
+	output := NewWriter(&buf)
+	logger := log.New(ioutil.Discard, "", 0)
+	writer := newLogWriter(output, logger)
+	if _, err := writer.Write([]byte("before close")); err != nil {
+		t.Fatalf("got=%v, want=nil", err)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatalf("got=%v, want=nil", err)
+	}
+	if got, want := strings.Join(buf.calls, ","), "flush,close"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+	if _, err := writer.Write([]byte("after close")); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+	if err := output.Close(); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+	if got, want := buf.String(), "before close\n"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	var out, raw closeBuffer
+	output := NewWriter(&out)
+	output.Raw(&raw)
+	output.Suppress("debug")
+	logger := log.New(ioutil.Discard, "app: ", log.LstdFlags)
+	writer := newLogWriter(output, logger)
+	inputs := []string{
+		"app: 2009/11/10 23:00:00 info: message a=1\n",
+		"app: 2009/11/10 23:00:00 debug: suppressed b=2\n",
+	}
+	for _, input := range inputs {
+		if _, err := writer.Write([]byte(input)); err != nil {
+			t.Errorf("got=%v, want=nil", err)
+		}
+	}
+	if _, err := output.Write([]byte("no header")); err != nil {
+		t.Errorf("got=%v, want=nil", err)
+	}
+	if got, want := out.String(), "app: 2009/11/10 23:00:00 info: message a=1\nno header\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+	if got, want := raw.String(), strings.Join(inputs, "")+"no header"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatalf("got=%v, want=nil", err)
+	}
+	if got, want := strings.Join(raw.calls, ","), "flush,close"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+	if _, err := writer.Write([]byte(inputs[0])); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+	if got, want := raw.Len(), len(strings.Join(inputs, ""))+len("no header"); got != want {
+		t.Errorf("got=%d, want=%d", got, want)
+	}
+
+	// errors writing raw input do not prevent printing
+	out.Reset()
+	output = NewWriter(&out)
+	output.Raw(errorWriter{})
+	if _, err := output.Write([]byte("message a=1")); err != errRawWrite {
+		t.Errorf("got=%v, want=%v", err, errRawWrite)
+	}
+	if got, want := out.String(), "message a=1\n"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+
+	// raw input is written when the output is discarded
+	raw.Reset()
+	output = NewWriter(Discard)
+	output.Raw(&raw)
+	output.Write([]byte("message a=1"))
+	if got, want := raw.String(), "message a=1"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+var errRawWrite = errors.New("raw write failed")
+
+// errorWriter fails every write.
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) { return 0, errRawWrite }
+
+func TestDiscard(t *testing.T) {
+	var calls int
+	handler := &testHandler{handle: func(*Message) { calls++ }}
+	tests := []struct {
+		output   *Writer
+		discards bool
+	}{
+		{output: NewWriter(Discard), discards: true},
+		{output: NewWriter(ioutil.Discard), discards: true},
+		{output: NewSplitWriter(Discard, Discard), discards: true},
+		{output: NewWriter(&bytes.Buffer{})},
+		{output: NewSplitWriter(Discard, &bytes.Buffer{})},
+		{output: NewOTelWriter(func(*OTelRecord) { calls++ })},
+		{output: Tee(NewWriter(Discard))},
+	}
+	for tn, tt := range tests {
+		if got, want := tt.output.discards(), tt.discards; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
+		}
+	}
+
+	output := NewWriter(Discard)
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	writer := newLogWriter(output, logger)
+	input := []byte("2009/11/10 23:00:00 message a=1\n")
+	if n, err := writer.Write(input); n != len(input) || err != nil {
+		t.Errorf("got=(%d, %v), want=(%d, nil)", n, err, len(input))
+	}
+	if n, err := output.Write(input); n != len(input) || err != nil {
+		t.Errorf("got=(%d, %v), want=(%d, nil)", n, err, len(input))
+	}
+
+	// handlers still receive messages
+	output.Handle(handler)
+	writer.Write(input)
+	if got, want := calls, 1; got != want {
+		t.Errorf("got=%d, want=%d", got, want)
+	}
+
+	output = NewWriter(Discard)
+	writer = newLogWriter(output, logger)
+	output.Close()
+	if _, err := writer.Write(input); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+	if _, err := output.Write(input); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+}
+
+func TestSplitWriter(t *testing.T) {
+	var out, errOut closeBuffer
+	output := NewSplitWriter(&out, &errOut)
+	output.SetVerbose(true)
+	output.SetWarningPrefixes("caution")
+	logger := log.New(ioutil.Discard, "", 0)
+	writer := newLogWriter(output, logger)
+	for _, input := range []string{
+		"debug: connecting",
+		"info: connected",
+		"warning: slow",
+		"error: disconnected",
+		"caution: low disk",
+		"request failed level=error",
+		"request completed level=info",
+		"message",
+	} {
+		writer.Write([]byte(input))
+	}
+	if got, want := out.String(), "debug: connecting\ninfo: connected\nrequest completed level=info\nmessage\n"; got != want {
+		t.Errorf("out:\n got=%q\nwant=%q", got, want)
+	}
+	if got, want := errOut.String(), "warning: slow\nerror: disconnected\ncaution: low disk\nrequest failed level=error\n"; got != want {
+		t.Errorf("errOut:\n got=%q\nwant=%q", got, want)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*closeBuffer{&out, &errOut} {
+		if got, want := b.calls, []string{"flush", "close"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got=%v, want=%v", got, want)
+		}
+	}
+
+	// each output has its own terminal detection
+	term := &fakeTerminal{terminal: true}
+	output = NewSplitWriter(term, &out)
+	if _, ok := output.printer.(*terminalPrinter); !ok {
+		t.Errorf("out: got=%T, want=%T", output.printer, &terminalPrinter{})
+	}
+	if _, ok := output.errPrinter.(*simplePrinter); !ok {
+		t.Errorf("errOut: got=%T, want=%T", output.errPrinter, &simplePrinter{})
+	}
+}
+
+func TestDedup(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	output.Dedup(50 * time.Millisecond)
+	logger := log.New(ioutil.Discard, "", 0)
+	writer := newLogWriter(output, logger)
+	for i := 0; i < 5; i++ {
+		writer.Write([]byte("error: connection refused addr=localhost"))
+	}
+	writer.Write([]byte("error: connection refused addr=otherhost"))
+	writer.Write([]byte("warning: connection refused addr=otherhost"))
+	// the summary can be printed by the writer's timer, so the
+	// output is read while the writer's mutex is locked
+	output.mutex.Lock()
+	got := buf.String()
+	output.mutex.Unlock()
+	want := "error: connection refused addr=localhost\n" +
+		"error: connection refused addr=otherhost\n" +
+		"warning: connection refused addr=otherhost\n"
+	if got != want {
+		t.Fatalf("\n got=%q\nwant=%q", got, want)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	writer.Write([]byte("error: connection refused addr=localhost"))
+	writer.Write([]byte("error: connection refused addr=localhost"))
+	want += "error: connection refused addr=localhost repeated=4\n" +
+		"error: connection refused addr=localhost\n"
+	output.mutex.Lock()
+	got = buf.String()
+	output.mutex.Unlock()
+	if got != want {
+		t.Fatalf("\n got=%q\nwant=%q", got, want)
+	}
+
+	output.Close()
+	want += "error: connection refused addr=localhost repeated=1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestDedupTimer(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	output.Dedup(20 * time.Millisecond)
+	logger := log.New(ioutil.Discard, "", 0)
+	writer := newLogWriter(output, logger)
+	for i := 0; i < 3; i++ {
+		writer.Write([]byte("error: connection refused addr=localhost"))
+	}
+
+	// the summary is printed when the window closes,
+	// without waiting for another message to be written
+	want := "error: connection refused addr=localhost\n" +
+		"error: connection refused addr=localhost repeated=2\n"
+	var got string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		output.mutex.Lock()
+		got = buf.String()
+		output.mutex.Unlock()
+		if got == want {
+			break
+		}
+	}
+	if got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+
+	output.mutex.Lock()
+	if got, want := len(output.dedup.order), 0; got != want {
+		t.Errorf("got=%d messages, want=%d", got, want)
+	}
+	output.mutex.Unlock()
+	output.Close()
+}
+
+func TestDedupMaxEntries(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	output.Dedup(time.Hour)
+	logger := log.New(ioutil.Discard, "", 0)
+	writer := newLogWriter(output, logger)
+	writer.Write([]byte("message n=0"))
+	writer.Write([]byte("message n=0"))
+	for i := 0; i < maxDedupEntries; i++ {
+		writer.Write([]byte("message n=" + strconv.Itoa(i+1)))
+	}
+	if got, want := len(output.dedup.entries), maxDedupEntries; got != want {
+		t.Errorf("got=%d, want=%d", got, want)
+	}
+	if got, want := len(output.dedup.order), maxDedupEntries; got != want {
+		t.Errorf("got=%d, want=%d", got, want)
+	}
+	if !strings.Contains(buf.String(), "message n=0 repeated=1\n") {
+		t.Errorf("missing summary for evicted message")
+	}
+}
+
+func TestIndent(t *testing.T) {
+	tests := []struct {
+		indent string
+		input  string
+		output string
+	}{
+		{
+			indent: "  ",
+			input:  "12:34:56 this message is wrapped over lines a=1",
+			output: "12:34:56 this message is\n  wrapped over lines a=1\n",
+		},
+		{
+			indent: "  │ ",
+			input:  "12:34:56 this message is wrapped over three lines a=1 b=2",
+			output: "12:34:56 this message is\n  │ wrapped over three\n  │ lines a=1 b=2\n",
+		},
+		{
+			indent: "",
+			input:  "12:34:56 this message is wrapped over lines a=1",
+			output: "12:34:56 this message is\n         wrapped over\n         lines a=1\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.printer = &terminalPrinter{
+			w:         &buf,
+			width:     func() int { return 25 },
+			nocolor:   true,
+			indentStr: tt.indent,
+		}
+		logger := log.New(ioutil.Discard, "", log.Ltime)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	capture := NewCapture(30)
+	capture.TabWidth(4) // resets the printer, which should remain fixed width
+	logger := log.New(ioutil.Discard, "", 0)
+	capture.Attach(logger)
+	if got := capture.Lines(); got != nil {
+		t.Errorf("got=%q, want=nil", got)
+	}
+	logger.Println("error: first message that wraps", "a=1")
+	logger.Println("second message")
+	want := []string{
+		"error: first message that",
+		"    wraps a=1",
+		"second message",
+	}
+	if got := capture.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+	capture.Reset()
+	if got := capture.String(); got != "" {
+		t.Errorf("got=%q, want empty", got)
+	}
+}
+
+func TestTee(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	sink1 := NewWriter(&buf1)
+	sink1.SortKeys(true)
+	sink2 := NewWriter(&buf2)
+	sink2.Logfmt()
+	sink2.Redact("password")
+	sink2.SetVerbose(false)
+	output := Tee(sink1, sink2)
+	logger := log.New(ioutil.Discard, "", log.Ltime)
+	writer := newLogWriter(output, logger)
+	for _, input := range []string{
+		"12:34:56 error: login failed user=alice password=secret",
+		"12:34:56 debug: message text b=2 a=1",
+		"12:34:56 warning:",
+	} {
+		if _, err := writer.Write([]byte(input)); err != nil {
+			t.Fatalf("got=%v, want=nil", err)
+		}
+	}
+
+	want1 := "12:34:56 error: login failed password=secret user=alice\n" +
+		"12:34:56 debug: message text a=1 b=2\n" +
+		"12:34:56 warning: \n"
+	if got := buf1.String(); got != want1 {
+		t.Errorf("\n got=%q\nwant=%q", got, want1)
+	}
+	want2 := "ts=\"12:34:56\" level=error msg=\"login failed\" user=alice password=\"****\"\n" +
+		"ts=\"12:34:56\" level=warning\n"
+	if got := buf2.String(); got != want2 {
+		t.Errorf("\n got=%q\nwant=%q", got, want2)
+	}
+
+	sink1.Close()
+	if _, err := writer.Write([]byte("12:34:56 message")); err != ErrClosed {
+		t.Errorf("got=%v, want=%v", err, ErrClosed)
+	}
+	if got, want := buf2.String(), want2+"ts=\"12:34:56\" msg=message\n"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestWriteLength(t *testing.T) {
+	var buf bytes.Buffer
+	output := NewWriter(&buf)
+	output.SetVerbose(false)
+	output.Suppress("info")
+	output.MinLevel("warn")
+	logger := log.New(ioutil.Discard, "prefix: ", log.LstdFlags|log.Lshortfile)
+	output.Attach(logger)
+	writer := newLogWriter(output, logger)
+	for tn, input := range []string{
+		"debug: verbose message",
+		"info: suppressed message",
+		"message level=trace",
+		"warning: printed message",
+		"",
+	} {
+		if err := logger.Output(1, input); err != nil {
+			t.Errorf("%d: got=%v, want=nil", tn, err)
+		}
+		line := "prefix: 2009/11/10 23:00:00 file.go:1: " + input + "\n"
+		if n, err := writer.Write([]byte(line)); n != len(line) || err != nil {
+			t.Errorf("%d: got=(%d, %v), want=(%d, nil)", tn, n, err, len(line))
+		}
+	}
+}
+
+func TestDefaultTheme(t *testing.T) {
+	defer os.Unsetenv("COLORFGBG")
+	tests := []struct {
+		env    string
+		theme  Theme
+		output string
 	}{
 		{
-			input: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
-			output: "2099/12/31 12:34:56 this is the message key1=value1\n" +
-				"                    key2=value2 key3=value3\n",
-			width: 60,
-			flags: log.LstdFlags,
+			env:    "",
+			theme:  DarkTheme(),
+			output: "\x1b[0;33mwarning: \x1b[0mmessage \x1b[0;36ma\x1b[0m=\x1b[0;96m1\x1b[0m\n",
 		},
 		{
-			input: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
-			output: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2\n" +
-				"                    key3=value3\n",
-			width: 70,
-			flags: log.LstdFlags,
+			env:    "15;0",
+			theme:  DarkTheme(),
+			output: "\x1b[0;33mwarning: \x1b[0mmessage \x1b[0;36ma\x1b[0m=\x1b[0;96m1\x1b[0m\n",
 		},
 		{
-			input: "prog [400] 2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
-			output: "prog [400] 2099/12/31 12:34:56 this is the message key1=value1\n" +
-				"                               key2=value2 key3=value3\n",
-			width:  70,
-			prefix: "prog [400] ",
-			flags:  log.LstdFlags,
+			env:    "0;15",
+			theme:  LightTheme(),
+			output: "\x1b[0;35mwarning: \x1b[0mmessage \x1b[0;34ma\x1b[0m=\x1b[0;36m1\x1b[0m\n",
 		},
 		{
-			input:  "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
-			output: "2099/12/31 12:34:56 this is the message key1=value1 key2=value2 key3=value3\n",
-			width:  80,
-			flags:  log.LstdFlags,
+			env:    "0;default;7",
+			theme:  LightTheme(),
+			output: "\x1b[0;35mwarning: \x1b[0mmessage \x1b[0;34ma\x1b[0m=\x1b[0;36m1\x1b[0m\n",
 		},
 		{
-			input: "this is the message key1=value1 key2=value2 key3=value3\n",
-			output: "this is the message key1=value1\n" +
-				"    key2=value2 key3=value3\n",
-			width: 40,
+			env:    "invalid",
+			theme:  DarkTheme(),
+			output: "\x1b[0;33mwarning: \x1b[0mmessage \x1b[0;36ma\x1b[0m=\x1b[0;96m1\x1b[0m\n",
 		},
+	}
+	for tn, tt := range tests {
+		os.Setenv("COLORFGBG", tt.env)
+		if got, want := DefaultTheme(), tt.theme; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
+		}
+		var buf bytes.Buffer
+		output := NewWriter(&buf)
+		output.ForceColor()
+		output.printer = newTerminalPrinter(&buf, output.opts, func() int { return 80 })
+		writer := newLogWriter(output, log.New(ioutil.Discard, "", 0))
+		writer.Write([]byte("warning: message a=1"))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestDetectColorProfile(t *testing.T) {
+	for _, key := range []string{"TERM", "COLORTERM", "WT_SESSION"} {
+		if value, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, value)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+	tests := []struct {
+		term      string
+		colorterm string
+		wtSession string
+		want      ColorProfile
+	}{
+		{term: "dumb", want: ProfileNone},
+		{term: "dumb", colorterm: "truecolor", want: ProfileNone},
+		{term: "xterm", want: Profile16},
+		{term: "xterm-256color", want: Profile256},
+		{term: "screen-256color", want: Profile256},
+		{term: "xterm-256color", colorterm: "truecolor", want: ProfileTrueColor},
+		{term: "xterm", colorterm: "24bit", want: ProfileTrueColor},
+		{term: "xterm-direct", want: ProfileTrueColor},
+		{wtSession: "1", want: ProfileTrueColor},
+		{want: Profile16},
+	}
+	for tn, tt := range tests {
+		os.Setenv("TERM", tt.term)
+		os.Setenv("COLORTERM", tt.colorterm)
+		os.Setenv("WT_SESSION", tt.wtSession)
+		if got, want := DetectColorProfile(), tt.want; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
+		}
+	}
+}
+
+func TestConvertEffect(t *testing.T) {
+	tests := []struct {
+		effect  string
+		profile ColorProfile
+		want    string
+	}{
+		{effect: "38;5;208", profile: ProfileTrueColor, want: "38;5;208"},
+		{effect: "38;5;208", profile: Profile256, want: "38;5;208"},
+		{effect: "38;5;208", profile: Profile16, want: "33"},
+		{effect: "38;5;9", profile: Profile16, want: "91"},
+		{effect: "48;5;21", profile: Profile16, want: "44"},
+		{effect: "38;5;244", profile: Profile16, want: "90"},
+		{effect: "38;2;255;135;0", profile: ProfileTrueColor, want: "38;2;255;135;0"},
+		{effect: "38;2;255;135;0", profile: Profile256, want: "38;5;208"},
+		{effect: "38;2;128;128;128", profile: Profile256, want: "38;5;244"},
+		{effect: "38;2;255;135;0", profile: Profile16, want: "33"},
+		{effect: "1;38;5;208;48;2;0;0;0", profile: Profile16, want: "1;33;40"},
+		{effect: "32;1", profile: Profile16, want: "32;1"},
+		{effect: "38;5", profile: Profile16, want: "38;5"},
+	}
+	for tn, tt := range tests {
+		if got, want := convertEffect(tt.effect, tt.profile), tt.want; got != want {
+			t.Errorf("%d: got=%q, want=%q", tn, got, want)
+		}
+	}
+}
+
+func TestSetColorProfile(t *testing.T) {
+	tests := []struct {
+		profile ColorProfile
+		force   bool
+		output  string
+	}{
 		{
-			input:  "12:34:56 error: this is the message key1=value1 key2=value2: file not found\n",
-			output: "12:34:56 error: this is the message key1=value1 key2=value2: file not found\n",
-			flags:  log.Ltime,
+			profile: ProfileTrueColor,
+			force:   true,
+			output:  "message \x1b[0;38;2;255;135;0ma\x1b[0m=\x1b[0;38;5;208m1\x1b[0m\n",
 		},
 		{
-			input: "12:34:56 error: this is the message key1=value1 key2=value2: file not found\n",
-			output: "12:34:56 error: this is the message key1=value1 key2=value2: file not\n" +
-				"         found\n",
-			width: 70,
-			flags: log.Ltime,
+			profile: Profile256,
+			force:   true,
+			output:  "message \x1b[0;38;5;208ma\x1b[0m=\x1b[0;38;5;208m1\x1b[0m\n",
 		},
 		{
-			input: "12:34:56 error: this is a very long message that will wrap over the line key1=value1 key2=value2: file not found\n",
-			output: "12:34:56 error: this is a very long message that will wrap\n" +
-				"         over the line key1=value1 key2=value2: file not\n" +
-				"         found\n",
-			width: 60,
-			flags: log.Ltime,
+			profile: Profile16,
+			force:   true,
+			output:  "message \x1b[0;33ma\x1b[0m=\x1b[0;33m1\x1b[0m\n",
 		},
 		{
-			input: `11:17:29 select "id","version","created_at","updated_at","status","message","nick_name","user_id",` +
-				`"customer_type","customer_company_name","customer_trading_name","customer_abn",` +
-				`"customer_acn","customer_phone_number","customer_fax_number","customer_first_name",` +
-				`"customer_last_name","customer_middle_names","customer_mobile_number",` +
-				`"customer_date_of_birth" from customers where id > $1 order by id limit $2 [14 10]`,
-			output: `11:17:29 select "id","version","created_at","updated_at","status","message","nick_name","user_id",` + "\n" +
-				`         "customer_type","customer_company_name","customer_trading_name","customer_abn",` + "\n" +
-				`         "customer_acn","customer_phone_number","customer_fax_number","customer_first_name",` + "\n" +
-				`         "customer_last_name","customer_middle_names","customer_mobile_number",` + "\n" +
-				`         "customer_date_of_birth" from customers where id > $1 order by id limit $2 [14 10]` + "\n",
-			width: 100,
-			flags: log.Ltime,
-		},
-		{ // colors
-			input:     "prefix: 11:17:39 error: this is an error message\n",
-			output:    "prefix: 11:17:39 \x1b[0;31merror: \x1b[0mthis is an error message\n",
-			width:     120,
-			showColor: true,
-			prefix:    "prefix: ",
-			flags:     log.Ltime,
-		},
-		{ // colors
-			input:     "11:17:39  Error: this is an error message\n",
-			output:    "11:17:39 \x1b[0;31merror: \x1b[0mthis is an error message\n",
-			width:     120,
-			showColor: true,
-			flags:     log.Ltime,
-		},
-		{ // colors
-			input:     "11:17:39  custom: this is a custom level\n",
-			output:    "11:17:39 \x1b[0;32;1mcustom: \x1b[0mthis is a custom level\n",
-			width:     120,
-			showColor: true,
-			flags:     log.Ltime,
-		},
-		{ // suppress debug
-			input:  "12:34:56 debug: should be suppressed",
-			output: "",
-			flags:  log.Ltime,
-		},
-		{ // suppress trace
-			input:  "12:34:56 trace: should be suppressed",
-			output: "",
-			flags:  log.Ltime,
-		},
-		{ // verbose shows debug
-			input:   "12:34:56 debug: should be displayed",
-			output:  "12:34:56 debug: should be displayed\n",
-			verbose: true,
-			flags:   log.Ltime | log.LUTC,
-		},
-		{ // verbose shows trace
-			input:   "12:34:56 trace: should be displayed",
-			output:  "12:34:56 trace: should be displayed\n",
-			verbose: true,
-			flags:   log.Ltime,
-		},
-		{ // trailing white space
-			input:   "12:34:56 trailing white space   ",
-			output:  "12:34:56 trailing white space\n",
-			verbose: true,
-			flags:   log.Ltime,
+			profile: ProfileNone,
+			force:   true,
+			output:  "message \x1b[0;33ma\x1b[0m=\x1b[0;33m1\x1b[0m\n",
 		},
-		{ // file format
-			input:     "12:34:56 file.go:123 message",
-			output:    "12:34:56 \x1b[0;90mfile.go:123: \x1b[0mmessage\n",
-			verbose:   true,
-			flags:     log.Ltime | log.Lshortfile,
-			showColor: true,
+		{
+			profile: ProfileNone,
+			output:  "message a=1\n",
 		},
 	}
-
+	if value, ok := os.LookupEnv("NO_COLOR"); ok {
+		defer os.Setenv("NO_COLOR", value)
+		os.Unsetenv("NO_COLOR")
+	}
 	for tn, tt := range tests {
 		var buf bytes.Buffer
 		output := NewWriter(&buf)
-		if !tt.verbose {
-			output.Suppress("trace", "debug")
+		output.SetTheme(Theme{Key: "38;2;255;135;0", Value: "38;5;208"})
+		if tt.force {
+			output.ForceColor()
 		}
-		output.SetLevel("custom", "32;1")
-		printer := &terminalPrinter{
-			w:       &buf,
-			nocolor: !tt.showColor,
+		output.SetColorProfile(tt.profile)
+		output.printer = newTerminalPrinter(&buf, output.opts, func() int { return 80 })
+		writer := newLogWriter(output, log.New(ioutil.Discard, "", 0))
+		writer.Write([]byte("message a=1"))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
 		}
-		if tt.width > 0 {
-			printer.width = func() int { return tt.width }
-		} else {
-			printer.width = func() int { return 999999 }
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	if value, ok := os.LookupEnv("NO_COLOR"); ok {
+		defer os.Setenv("NO_COLOR", value)
+	} else {
+		defer os.Unsetenv("NO_COLOR")
+	}
+	tests := []struct {
+		env   string
+		color colorMode
+		want  bool
+	}{
+		{env: "", color: colorAuto, want: true},
+		{env: "1", color: colorAuto, want: false},
+		{env: "", color: colorNever, want: false},
+		{env: "1", color: colorNever, want: false},
+		{env: "", color: colorAlways, want: true},
+		{env: "1", color: colorAlways, want: true},
+	}
+	for tn, tt := range tests {
+		os.Setenv("NO_COLOR", tt.env)
+		if got, want := tt.color.enabled(), tt.want; got != want {
+			t.Errorf("%d: got=%v, want=%v", tn, got, want)
 		}
-		output.printer = printer
-		logger := log.New(ioutil.Discard, tt.prefix, tt.flags)
-		writer := newLogWriter(output, logger)
-		writer.Write([]byte(tt.input))
-		str := buf.String()
-		if got, want := str, tt.output; got != want {
-			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	// scanning must match the regular expressions it replaced
+	whiteSpaceRE := regexp.MustCompile(`^\s+`)
+	inputs := []string{
+		"",
+		"word",
+		"  \t\r\n\fword",
+		"word, more",
+		",,,",
+		"\v\u00a0\u0085\u2003 word",
+		"日本語 words",
+		"€250.00,\tnext",
+	}
+	for tn, input := range inputs {
+		for in := []byte(input); len(in) > 0; in = in[1:] {
+			if got, want := scan(in, isWhiteSpace), len(whiteSpaceRE.Find(in)); got != want {
+				t.Errorf("%d: %q: got=%v, want=%v", tn, in, got, want)
+			}
+		}
+	}
+}
+
+func TestMatchHeader(t *testing.T) {
+	// matching must give the same results as the regular expressions it replaced
+	tests := []struct {
+		match func([]byte) int
+		re    *regexp.Regexp
+	}{
+		{matchDate, regexp.MustCompile(`^\d{4}/\d\d/\d\d`)},
+		{matchTime, regexp.MustCompile(`^\d\d:\d\d:\d\d(\.\d+)?`)},
+		{matchFile, regexp.MustCompile(`^([a-zA-Z]:)?[^:]+:\d+`)},
+		{matchColon, regexp.MustCompile(`^\s*:\s*`)},
+	}
+	inputs := []string{
+		"",
+		"2009/11/10 23:00:00.123456 file.go:23: message",
+		"2009/1/10 23:00:00. file.go:: message",
+		"23:00:00.x message",
+		"D:/go/src/kv.go:123: message",
+		"D:123: message",
+		"D:/go/src/kv.go:",
+		"???:0: message",
+		" \t: \r\n message",
+		"日本語:12",
+	}
+	for tn, tt := range tests {
+		for _, input := range inputs {
+			for in := []byte(input); len(in) > 0; in = in[1:] {
+				if got, want := tt.match(in), len(tt.re.Find(in)); got != want {
+					t.Errorf("%d: %q: got=%v, want=%v", tn, in, got, want)
+				}
+			}
 		}
 	}
 }
@@ -283,7 +3665,9 @@ func TestOutput(t *testing.T) {
 	output.Suppress("debug")
 	var entry *logEntry
 	output.entryHandler = func(e *logEntry) {
-		entry = e
+		// the writer re-uses its entry, so take a copy
+		ent := *e
+		entry = &ent
 	}
 
 	for tn, tt := range tests {
@@ -342,6 +3726,12 @@ func (e *logEntry) String() string {
 	return buf.String()
 }
 
+// nopWriter discards its input. Unlike Discard, a writer
+// that prints to it still parses and prints each message.
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) { return len(p), nil }
+
 func BenchmarkStdLog(b *testing.B) {
 	logger := log.New(ioutil.Discard, "testing", log.LstdFlags)
 	benchmarkLog(b, logger)
@@ -349,19 +3739,153 @@ func BenchmarkStdLog(b *testing.B) {
 
 func BenchmarkKVLog(b *testing.B) {
 	logger := log.New(ioutil.Discard, "testing", log.LstdFlags)
-	w := NewWriter(ioutil.Discard)
+	w := NewWriter(nopWriter{})
 	w.Attach(logger)
 	benchmarkLog(b, logger)
 }
 
 func BenchmarkSuppress(b *testing.B) {
 	logger := log.New(ioutil.Discard, "testing", log.LstdFlags)
-	w := NewWriter(ioutil.Discard)
+	w := NewWriter(nopWriter{})
 	w.Attach(logger)
 	w.Suppress("info")
 	benchmarkLog(b, logger)
 }
 
+func BenchmarkWrite(b *testing.B) {
+	logger := log.New(ioutil.Discard, "testing", log.LstdFlags)
+	w := NewWriter(nopWriter{})
+	lw := newLogWriter(w, logger)
+	input := []byte("testing2099/12/31 12:34:56 info: message a=1 b=\"value 2\" c=3\n")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lw.Write(input)
+		}
+	})
+}
+
+// parseWriter calls parse for each line written to it.
+type parseWriter struct {
+	parse func(p []byte)
+}
+
+func (w parseWriter) Write(p []byte) (int, error) {
+	w.parse(p)
+	return len(p), nil
+}
+
+// BenchmarkParseInto measures the allocations for each message printed by
+// a writer whose raw output is parsed with kv.Parse, and with kv.ParseInto
+// re-using one message. The "Print" benchmark does not parse the raw output.
+func BenchmarkParseInto(b *testing.B) {
+	input := []byte("testing2099/12/31 12:34:56 info: request complete id=42 method=GET path=\"/api/items\" status=200 elapsed=1.5ms\n")
+	var msg kv.Message
+	benchmarks := []struct {
+		name  string
+		parse func(p []byte)
+	}{
+		{"Print", nil},
+		{"Parse", func(p []byte) { kv.Parse(p) }},
+		{"ParseInto", func(p []byte) { kv.ParseInto(&msg, p) }},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			logger := log.New(ioutil.Discard, "testing", log.LstdFlags)
+			w := NewWriter(nopWriter{})
+			if bm.parse != nil {
+				w.Raw(parseWriter{parse: bm.parse})
+			}
+			lw := newLogWriter(w, logger)
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				lw.Write(input)
+			}
+		})
+	}
+}
+
+func BenchmarkDiscard(b *testing.B) {
+	logger := log.New(ioutil.Discard, "testing", log.LstdFlags)
+	w := NewWriter(Discard)
+	lw := newLogWriter(w, logger)
+	input := []byte("testing2099/12/31 12:34:56 info: message a=1 b=\"value 2\" c=3\n")
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		lw.Write(input)
+	}
+}
+
+func BenchmarkHeader(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		flags int
+		input string
+	}{
+		{"time", log.LstdFlags | log.Lshortfile, "2099/12/31 12:34:56 file.go:23: message a=1\n"},
+		{"notime", 0, "message a=1\n"},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			logger := log.New(ioutil.Discard, "", bm.flags)
+			w := NewWriter(nopWriter{})
+			lw := newLogWriter(w, logger)
+			input := []byte(bm.input)
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				lw.Write(input)
+			}
+		})
+	}
+}
+
+func BenchmarkTerminalPrinter(b *testing.B) {
+	p := &terminalPrinter{
+		w:       ioutil.Discard,
+		width:   func() int { return 80 },
+		nocolor: true,
+	}
+	ent := &logEntry{
+		Time: []byte("12:34:56"),
+		Text: []byte("lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod" +
+			" tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis" +
+			" nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat."),
+		List: [][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("value 2")},
+	}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		p.Print(ent)
+	}
+}
+
+func BenchmarkTerminalPrinterShort(b *testing.B) {
+	for _, color := range []bool{false, true} {
+		name := "nocolor"
+		if color {
+			name = "color"
+		}
+		b.Run(name, func(b *testing.B) {
+			p := &terminalPrinter{
+				w:       ioutil.Discard,
+				width:   func() int { return 80 },
+				nocolor: !color,
+				theme:   DarkTheme(),
+			}
+			ent := &logEntry{
+				Time:   []byte("12:34:56"),
+				Level:  "info",
+				Effect: "green",
+				Text:   []byte("connected to the database server"),
+				List:   [][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("value 2")},
+			}
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				p.Print(ent)
+			}
+		})
+	}
+}
+
 func benchmarkLog(b *testing.B, logger *log.Logger) {
 	b.ReportAllocs()
 	kv := kv.With("n", 0)
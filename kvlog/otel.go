@@ -0,0 +1,117 @@
+package kvlog
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/jjeffery/kv"
+	"github.com/jjeffery/kv/internal/parse"
+)
+
+// OTelRecord contains the details of a message as an OpenTelemetry log
+// record. The fields correspond to the fields of the Record type in the
+// go.opentelemetry.io/otel/log package.
+type OTelRecord struct {
+	Timestamp         time.Time // time printed by the logger, or when the message was written
+	ObservedTimestamp time.Time // when the message was written
+	Severity          int       // severity number, from 1 (TRACE) to 24 (FATAL4), or 0 if unknown
+	SeverityText      string    // level, or empty if unknown
+	Body              string    // message text
+	Attributes        kv.List   // key/value pairs
+}
+
+// NewOTelWriter returns a writer that passes each message to emit as an
+// OpenTelemetry log record. The message text is the body of the record, and
+// the key/value pairs are attributes. Values that look like integers,
+// floating point numbers or booleans are converted to int, float64 and bool
+// using the same rules as kv.ParseTyped, so that attributes keep their type.
+// As with kv.ParseTyped, quoted values are always strings, so code="42" is
+// the string "42". The logger prefix and file, if any, are the "prefix" and
+// "file" attributes.
+//
+// The level of each message determines the severity, in the same way as for
+// NewSyslogWriter: the level at the beginning of the message text is used,
+// otherwise the value of the level key (see LevelKey). Levels are mapped to
+// the base severity of the matching OpenTelemetry range, eg "debug" is 5
+// (DEBUG) and "error" is 17 (ERROR). Messages without a known level have
+// a severity of zero, and the level, if any, is the severity text.
+//
+// The record and its attributes are only valid during the call to emit.
+// The kvlog package does not depend on OpenTelemetry. The otelkv package,
+// which is a separate module, provides a writer that emits each record to
+// a logger from the OpenTelemetry Logs Bridge API:
+//
+//	import "github.com/jjeffery/kv/kvlog/otelkv"
+//
+//	otelkv.NewWriter(provider.Logger("myapp")).Attach()
+//
+// Records emitted to a logger from the OpenTelemetry SDK are batched
+// and exported by the logger provider's processors.
+func NewOTelWriter(emit func(r *OTelRecord)) *Writer {
+	w := NewWriter(ioutil.Discard)
+	w.mutex.Lock()
+	w.otel = emit
+	w.setPrinter()
+	w.mutex.Unlock()
+	return w
+}
+
+// otelPrinter passes messages to a function as OpenTelemetry log records.
+type otelPrinter struct {
+	emit     func(*OTelRecord)
+	levelKey []byte
+	rec      OTelRecord // re-used for each message
+}
+
+func (p *otelPrinter) Print(msg *logEntry) {
+	level := entryLevel(msg, p.levelKey)
+	rec := &p.rec
+	*rec = OTelRecord{
+		Timestamp:         entryTime(msg),
+		ObservedTimestamp: msg.Timestamp,
+		Severity:          otelSeverity(level),
+		SeverityText:      level,
+		Body:              string(msg.Text),
+		Attributes:        rec.Attributes[:0],
+	}
+	if prefix := strings.TrimSpace(msg.Prefix); prefix != "" {
+		rec.Attributes = append(rec.Attributes, "prefix", prefix)
+	}
+	if msg.Label != "" {
+		rec.Attributes = append(rec.Attributes, labelKey, msg.Label)
+	}
+	if len(msg.File) > 0 {
+		rec.Attributes = append(rec.Attributes, "file", string(msg.File))
+	}
+	for i := 0; i < len(msg.List); i += 2 {
+		var value interface{}
+		if i+1 < len(msg.Quoted) && msg.Quoted[i+1] {
+			value = string(msg.List[i+1])
+		} else {
+			value = parse.Typed(msg.List[i+1])
+		}
+		rec.Attributes = append(rec.Attributes, string(msg.List[i]), value)
+	}
+	p.emit(rec)
+}
+
+// otelSeverity returns the OpenTelemetry severity number for a level,
+// or zero if the level is not known.
+func otelSeverity(level string) int {
+	switch strings.ToLower(level) {
+	case "trace":
+		return 1
+	case "debug":
+		return 5
+	case "info":
+		return 9
+	case "warn", "warning":
+		return 13
+	case "error":
+		return 17
+	case "fatal", "alert":
+		return 21
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package kvlog
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jjeffery/kv"
+)
+
+func TestOTelWriter(t *testing.T) {
+	tm := time.Date(2009, 11, 10, 23, 0, 0, 0, time.Local)
+	tests := []struct {
+		levelKey string
+		setup    func(w *Writer)
+		input    string
+		want     OTelRecord
+	}{
+		{
+			input: "app: 2009/11/10 23:00:00 file.go:23: error: cannot connect host=db1 port=5432 retry=true wait=1.5 id=007",
+			want: OTelRecord{
+				Timestamp:    tm,
+				Severity:     17,
+				SeverityText: "error",
+				Body:         "cannot connect",
+				Attributes:   kv.List{"prefix", "app:", "file", "file.go:23", "host", "db1", "port", 5432, "retry", true, "wait", 1.5, "id", "007"},
+			},
+		},
+		{
+			input: "app: 2009/11/10 23:00:00 file.go:23: started",
+			want: OTelRecord{
+				Timestamp:  tm,
+				Body:       "started",
+				Attributes: kv.List{"prefix", "app:", "file", "file.go:23"},
+			},
+		},
+		{
+			levelKey: "severity",
+			input:    "app: 2009/11/10 23:00:00 file.go:23: request slow severity=warn",
+			want: OTelRecord{
+				Timestamp:    tm,
+				Severity:     13,
+				SeverityText: "warn",
+				Body:         "request slow",
+				Attributes:   kv.List{"prefix", "app:", "file", "file.go:23", "severity", "warn"},
+			},
+		},
+		{ // quoted values are strings
+			input: `app: 2009/11/10 23:00:00 file.go:23: request failed code="42" ok="true" n=42`,
+			want: OTelRecord{
+				Timestamp:  tm,
+				Body:       "request failed",
+				Attributes: kv.List{"prefix", "app:", "file", "file.go:23", "code", "42", "ok", "true", "n", 42},
+			},
+		},
+		{ // quoted values are tracked when pairs are sorted, merged and expanded
+			setup: func(w *Writer) {
+				w.SortKeys(true)
+				w.MergeDuplicateKeys(LastKeyWins)
+				w.ExpandErrors()
+			},
+			input: `app: 2009/11/10 23:00:00 file.go:23: request failed n=1 err="not found id=\"7\" seq=8" code="42" n=2`,
+			want: OTelRecord{
+				Timestamp:  tm,
+				Body:       "request failed",
+				Attributes: kv.List{"prefix", "app:", "file", "file.go:23", "code", "42", "err", "not found", "id", "7", "n", 2, "seq", 8},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		var records []OTelRecord
+		output := NewOTelWriter(func(r *OTelRecord) {
+			rec := *r
+			rec.Attributes = append(kv.List(nil), r.Attributes...)
+			records = append(records, rec)
+		})
+		if tt.levelKey != "" {
+			output.LevelKey(tt.levelKey)
+		}
+		if tt.setup != nil {
+			tt.setup(output)
+		}
+		logger := log.New(ioutil.Discard, "app: ", log.LstdFlags|log.Lshortfile)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if len(records) != 1 {
+			t.Errorf("%d: got %d records, want 1", tn, len(records))
+			continue
+		}
+		got := records[0]
+		if got.ObservedTimestamp.IsZero() {
+			t.Errorf("%d: missing observed timestamp", tn)
+		}
+		got.ObservedTimestamp = time.Time{}
+		if !got.Timestamp.Equal(tt.want.Timestamp) {
+			t.Errorf("%d: got=%v, want=%v", tn, got.Timestamp, tt.want.Timestamp)
+		}
+		got.Timestamp = tt.want.Timestamp
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d:\n got=%+v\nwant=%+v", tn, got, tt.want)
+		}
+	}
+}
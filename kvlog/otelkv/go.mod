@@ -0,0 +1,20 @@
+module github.com/jjeffery/kv/kvlog/otelkv
+
+go 1.25.0
+
+// The kv version required below is the first to contain kvlog.NewOTelWriter.
+// The replace directive builds this module against the kv source in the
+// parent directories during development, and is ignored by users of the module.
+replace github.com/jjeffery/kv => ../..
+
+require (
+	github.com/jjeffery/kv v0.8.2-0.20261015083205-98038d7a4c7d
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/log v0.22.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -0,0 +1,67 @@
+// Package otelkv emits the messages printed by the `log` package
+// in the Go standard library as OpenTelemetry log records.
+//
+// It is a separate module, so that programs that use the kv and kvlog
+// packages do not depend on OpenTelemetry unless they import it.
+package otelkv
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jjeffery/kv/kvlog"
+	"go.opentelemetry.io/otel/attribute"
+	otellog "go.opentelemetry.io/otel/log"
+)
+
+// NewWriter returns a writer that emits each message to logger as an
+// OpenTelemetry log record. Messages are parsed and converted in the same
+// way as for kvlog.NewOTelWriter: the message text is the body of the record,
+// the key/value pairs are attributes that keep the type of integer, floating
+// point and boolean values, the time printed by the logger is the timestamp,
+// and the level of the message determines the severity.
+//
+//	provider := sdklog.NewLoggerProvider(
+//		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+//	)
+//	otelkv.NewWriter(provider.Logger("myapp")).Attach()
+//
+// Records are emitted to logger as each message is written. If logger
+// comes from a logger provider in the OpenTelemetry SDK, records are
+// batched and exported by the provider's processors, so the provider
+// should be shut down before the program exits.
+func NewWriter(logger otellog.Logger) *kvlog.Writer {
+	return kvlog.NewOTelWriter(func(r *kvlog.OTelRecord) {
+		logger.Emit(context.Background(), Record(r))
+	})
+}
+
+// Record returns the OpenTelemetry log record for r. Attributes with
+// int, float64 and bool values keep their type, and all other values
+// are strings.
+func Record(r *kvlog.OTelRecord) otellog.Record {
+	var rec otellog.Record
+	rec.SetTimestamp(r.Timestamp)
+	rec.SetObservedTimestamp(r.ObservedTimestamp)
+	rec.SetSeverity(otellog.Severity(r.Severity))
+	rec.SetSeverityText(r.SeverityText)
+	rec.SetBody(attribute.StringValue(r.Body))
+	for i := 0; i+1 < len(r.Attributes); i += 2 {
+		key, _ := r.Attributes[i].(string)
+		rec.AddAttributes(keyValue(key, r.Attributes[i+1]))
+	}
+	return rec
+}
+
+// keyValue returns the attribute for a key/value pair.
+func keyValue(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case int:
+		return attribute.Int(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
@@ -0,0 +1,74 @@
+package otelkv
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/embedded"
+)
+
+// testLogger records the log records emitted to it.
+type testLogger struct {
+	embedded.Logger
+	mutex   sync.Mutex
+	records []otellog.Record
+}
+
+func (l *testLogger) Emit(ctx context.Context, r otellog.Record) {
+	l.mutex.Lock()
+	l.records = append(l.records, r.Clone())
+	l.mutex.Unlock()
+}
+
+func (l *testLogger) Enabled(ctx context.Context, param otellog.EnabledParameters) bool {
+	return true
+}
+
+func TestNewWriter(t *testing.T) {
+	var logger testLogger
+	stdlog := log.New(nil, "", log.LstdFlags)
+	NewWriter(&logger).Attach(stdlog)
+
+	start := time.Now().Truncate(time.Second)
+	stdlog.Print(`error: cannot connect host=db1 port=5432 retry=true wait=1.5 code="42"`)
+	if got, want := len(logger.records), 1; got != want {
+		t.Fatalf("got=%d records, want=%d", got, want)
+	}
+	rec := logger.records[0]
+	if tm := rec.Timestamp(); tm.Before(start) || tm.After(time.Now()) {
+		t.Errorf("timestamp got=%v, want=%v", tm, start)
+	}
+	if rec.ObservedTimestamp().IsZero() {
+		t.Errorf("missing observed timestamp")
+	}
+	if got, want := rec.Severity(), otellog.SeverityError; got != want {
+		t.Errorf("severity got=%v, want=%v", got, want)
+	}
+	if got, want := rec.SeverityText(), "error"; got != want {
+		t.Errorf("severity text got=%q, want=%q", got, want)
+	}
+	if got, want := rec.Body().AsString(), "cannot connect"; got != want {
+		t.Errorf("body got=%q, want=%q", got, want)
+	}
+	var attrs []attribute.KeyValue
+	rec.WalkAttributes(func(kv attribute.KeyValue) bool {
+		attrs = append(attrs, kv)
+		return true
+	})
+	want := []attribute.KeyValue{
+		attribute.String("host", "db1"),
+		attribute.Int("port", 5432),
+		attribute.Bool("retry", true),
+		attribute.Float64("wait", 1.5),
+		attribute.String("code", "42"),
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("attributes\n got=%v\nwant=%v", attrs, want)
+	}
+}
@@ -2,54 +2,396 @@ package kvlog
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"math"
+	"os"
 	"regexp"
-	"unicode"
+	"strconv"
+	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/jjeffery/kv/internal/logfmt"
 	"github.com/jjeffery/kv/internal/pool"
 	"github.com/jjeffery/kv/internal/terminal"
+	"github.com/jjeffery/kv/internal/wrap"
 )
 
 const (
 	// defaultTerminalWidth is the width to use for a terminal if
 	// the attempt to query the terminal width fails.
 	defaultTerminalWidth = 120
-)
 
-var (
-	whiteSpaceRE = regexp.MustCompile(`^\s+`)
-	blackSpaceRE = regexp.MustCompile(`^[^\s,]+`)
+	// noWrapWidth is a terminal width that is wide enough
+	// that messages are never wrapped.
+	noWrapWidth = math.MaxInt32
+
+	// defaultMinWidth is the default minimum terminal width. Messages
+	// printed to a narrower terminal are truncated instead of wrapped.
+	defaultMinWidth = 20
+
+	// defaultWidthPadding is the number of columns left unused at the
+	// end of each line, because some terminals don't format nicely when
+	// text is printed in the last column (eg git bash).
+	defaultWidthPadding = 1
+
+	// defaultTabWidth is the default distance between tab stops.
+	defaultTabWidth = 8
+
+	// newline terminates each line of output, unless the
+	// writer has been configured to use crlf.
+	newline = "\n"
+
+	// crlf terminates each line of output for writers
+	// configured with the CRLF option.
+	crlf = "\r\n"
 )
 
 type printer interface {
 	Print(*logEntry)
 }
 
-func newPrinter(w io.Writer) printer {
-	if fd, ok := fileDescriptor(w); ok {
-		if terminal.IsTerminal(fd) {
+// colorMode determines whether messages printed to a terminal are displayed in color.
+type colorMode int
+
+const (
+	colorAuto   colorMode = iota // color unless NO_COLOR is set
+	colorNever                   // never display color
+	colorAlways                  // always display color
+)
+
+// enabled reports whether color should be displayed. In auto mode, color
+// is disabled if the NO_COLOR environment variable is set to a non-empty
+// value. See https://no-color.org.
+func (c colorMode) enabled() bool {
+	switch c {
+	case colorNever:
+		return false
+	case colorAlways:
+		return true
+	}
+	return os.Getenv("NO_COLOR") == ""
+}
+
+// printerOptions contains options for printing to a terminal.
+type printerOptions struct {
+	color      colorMode      // display color
+	profile    ColorProfile   // colors the terminal can display, or zero to detect
+	theme      Theme          // display effects
+	tabWidth   int            // distance between tab stops, or zero
+	widthCache time.Duration  // how long to cache the terminal width
+	noWrap     bool           // print each message on a single line
+	unlimited  bool           // never wrap or truncate, but display color
+	alignKeys  bool           // align wrapped key/value pairs
+	crlf       bool           // terminate lines with CR LF
+	indent     string         // indent for continuation lines, or empty
+	width      int            // fixed terminal width, or zero
+	maxLine    int            // maximum bytes printed for a message, or zero
+	highlight  *regexp.Regexp // text to highlight, or nil
+	fallback   int            // width if terminal size unknown, -1 for no wrap, 0 for default
+	padding    int            // columns unused at end of line, -1 for none, 0 for default
+	kvFirst    bool           // print key/value pairs before the message text
+	kvSep      string         // between message text and key/value pairs, or empty for default
+	expandJSON bool           // print JSON values indented on continuation lines
+	minWidth   int            // truncate lines if terminal is narrower, -1 for none, 0 for default
+	kvColumn   int            // column where key/value pairs start, or zero
+	maxLines   int            // maximum continuation lines for a message, or zero
+	errorText  bool           // display the message text of errors in color
+}
+
+func newPrinter(w io.Writer, opts printerOptions) printer {
+	if opts.noWrap {
+		return &simplePrinter{w: w, crlf: opts.crlf, maxLine: opts.maxLine, kvFirst: opts.kvFirst, kvSep: opts.kvSep}
+	}
+	if opts.width > 0 {
+		// format as if w is a terminal with a fixed width
+		width := opts.width
+		return newTerminalPrinter(w, opts, func() int { return width })
+	}
+	if IsTerminal(w) {
+		fallback := opts.fallbackWidth()
+		width := func() int { return columns(fallback) }
+		if fd, ok := terminalDescriptor(w); ok {
 			terminal.EnableVirtualTerminalProcessing(fd)
-			return &terminalPrinter{
-				w: w,
-				width: func() int {
-					width, _, err := terminal.GetSize(fd)
-					if err != nil {
-						return defaultTerminalWidth
-					}
-					return width
-				},
+			width = func() int {
+				width, _, err := terminal.GetSize(fd)
+				if err != nil || width <= 0 {
+					return columns(fallback)
+				}
+				return width
 			}
 		}
+		return newTerminalPrinter(w, opts, cacheWidth(width, opts.widthCache))
+	}
+
+	return &simplePrinter{w: w, crlf: opts.crlf, maxLine: opts.maxLine, kvFirst: opts.kvFirst, kvSep: opts.kvSep}
+}
+
+// minimumWidth returns the minimum terminal width for wrapping
+// messages, or zero if there is no minimum.
+func (opts printerOptions) minimumWidth() int {
+	switch {
+	case opts.minWidth < 0:
+		return 0
+	case opts.minWidth == 0:
+		return defaultMinWidth
+	}
+	return opts.minWidth
+}
+
+// fallbackWidth returns the width to use if the size of
+// the terminal cannot be determined.
+func (opts printerOptions) fallbackWidth() int {
+	switch {
+	case opts.fallback < 0:
+		return noWrapWidth
+	case opts.fallback == 0:
+		return defaultTerminalWidth
+	}
+	return opts.fallback
+}
+
+// columns returns the width in the COLUMNS environment variable,
+// which is set by many shells. If COLUMNS is not set to a
+// positive integer, fallback is returned.
+func columns(fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+func newTerminalPrinter(w io.Writer, opts printerOptions, width func() int) *terminalPrinter {
+	if opts.unlimited {
+		width = func() int { return 0 }
+	}
+	nocolor := !opts.color.enabled()
+	profile := opts.profile
+	if profile == 0 {
+		profile = DetectColorProfile()
+	}
+	if profile == ProfileNone {
+		// color is only displayed on a terminal without color if forced
+		nocolor = nocolor || opts.color != colorAlways
+		profile = Profile16
+	}
+	return &terminalPrinter{
+		w:          w,
+		nocolor:    nocolor,
+		profile:    profile,
+		theme:      opts.theme,
+		tabWidth:   opts.tabWidth,
+		alignKeys:  opts.alignKeys,
+		crlf:       opts.crlf,
+		indentStr:  opts.indent,
+		maxLine:    opts.maxLine,
+		highlight:  opts.highlight,
+		padding:    opts.padding,
+		kvFirst:    opts.kvFirst,
+		kvSep:      opts.kvSep,
+		expandJSON: opts.expandJSON,
+		minWidth:   opts.minimumWidth(),
+		kvColumn:   opts.kvColumn,
+		maxLines:   opts.maxLines,
+		width:      width,
+	}
+}
+
+// truncated is printed at the end of a message that
+// has been truncated because it is too long.
+const truncated = "… (truncated)"
+
+// writeLine terminates the message in buf and writes it to w. If max is
+// greater than zero, and the message would be longer than max bytes, it
+// is truncated at a UTF-8 boundary, and ends with a marker and the line
+// terminator. The message is not truncated inside an ANSI escape sequence.
+func writeLine(w io.Writer, buf *bytes.Buffer, useCRLF bool, max int) {
+	eol := newline
+	if useCRLF {
+		eol = crlf
+	}
+	if max > 0 && buf.Len()+len(eol) > max {
+		b := buf.Bytes()
+		suffix := truncated
+		if bytes.IndexByte(b, 0x1b) >= 0 {
+			// reset any color effect in progress
+			suffix = "\x1b[0m" + suffix
+		}
+		n := max - len(suffix) - len(eol)
+		if n < 0 {
+			n = 0
+		}
+		for n > 0 && !utf8.RuneStart(b[n]) {
+			n--
+		}
+		if i := bytes.LastIndexByte(b[:n], 0x1b); i >= 0 && bytes.IndexByte(b[i:n], 'm') < 0 {
+			n = i
+		}
+		buf.Truncate(n)
+		buf.WriteString(suffix)
+	}
+	buf.WriteString(eol)
+	w.Write(buf.Bytes())
+}
+
+// writeNewline writes the line terminator to buf.
+func writeNewline(buf *bytes.Buffer, useCRLF bool) {
+	if useCRLF {
+		buf.WriteString(crlf)
+	} else {
+		buf.WriteString(newline)
+	}
+}
+
+// trimTrailingSpace removes white space from the end of the line in buf,
+// which can be left by a header or indent when a line is wrapped. White
+// space followed only by escape sequences, which occupy no columns, is
+// also removed, and the escape sequences are kept.
+func trimTrailingSpace(buf *bytes.Buffer) {
+	b := buf.Bytes()
+	n := len(b)
+	for i := n; i > 0; {
+		switch c := b[i-1]; {
+		case c == ' ' || c == '\t':
+			copy(b[i-1:], b[i:n])
+			n--
+			i--
+			continue
+		case c == 'm':
+			// skip over a color escape sequence, eg "\x1b[0m"
+			if j := bytes.LastIndexByte(b[:i], 0x1b); j >= 0 && j+2 < i && b[j+1] == '[' && ansiRE.Match(b[j+2:i-1]) {
+				i = j
+				continue
+			}
+		}
+		break
+	}
+	buf.Truncate(n)
+}
+
+// truncateLines truncates each line in buf that is wider than width
+// columns, so that it ends with an ellipsis in the last column. Color
+// escape sequences occupy no columns, and the color is reset before
+// the ellipsis if the line is truncated.
+func truncateLines(buf *bytes.Buffer, width int) {
+	var out []byte
+	for _, line := range bytes.SplitAfter(buf.Bytes(), []byte(newline)) {
+		text := bytes.TrimRight(line, crlf)
+		out = appendTruncated(out, text, width)
+		out = append(out, line[len(text):]...)
+	}
+	buf.Reset()
+	buf.Write(out)
+}
+
+// limitLines keeps the first line in buf and the n continuation lines
+// that follow it. Any other lines are replaced with a single continuation
+// line, indented by indent, with a marker that shows how many lines were
+// removed. The color is reset before the marker if buf contains color
+// escape sequences.
+func limitLines(buf *bytes.Buffer, n int, indent string) {
+	b := buf.Bytes()
+	var end int
+	for i := 0; i <= n; i++ {
+		j := bytes.IndexByte(b[end:], '\n')
+		if j < 0 {
+			return
+		}
+		end += j + 1
+	}
+	more := bytes.Count(b[end:], []byte(newline)) + 1
+	text := bytes.TrimRight(b[:end], crlf)
+	out := make([]byte, 0, end+len(indent)+32)
+	out = append(out, text...)
+	if bytes.IndexByte(text, 0x1b) >= 0 {
+		out = append(out, "\x1b[0m"...)
+	}
+	out = append(out, b[len(text):end]...)
+	out = append(out, indent...)
+	out = append(out, truncatedLines(more)...)
+	buf.Reset()
+	buf.Write(out)
+}
+
+// truncatedLines returns the marker printed at the end of a message
+// after n lines have been removed because it has too many lines.
+func truncatedLines(n int) string {
+	return "… (truncated, " + strconv.Itoa(n) + " more)"
+}
+
+// appendTruncated appends line to dst. If line is wider than width
+// columns, it is truncated to width-1 columns followed by an ellipsis.
+func appendTruncated(dst, line []byte, width int) []byte {
+	if terminal.Width(line) <= width {
+		return append(dst, line...)
+	}
+	var col int
+	var color bool
+	for i := 0; i < len(line); {
+		if n := colorLen(line[i:]); n > 0 {
+			dst = append(dst, line[i:i+n]...)
+			color = true
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRune(line[i:])
+		col += terminal.RuneWidth(r)
+		if col > width-1 {
+			break
+		}
+		dst = append(dst, line[i:i+size]...)
+		i += size
+	}
+	if color {
+		dst = append(dst, "\x1b[0m"...)
 	}
+	return append(dst, "…"...)
+}
 
-	return &simplePrinter{w: w}
+// cacheWidth returns a function that calls width at most once
+// in each period of duration d. If d is zero or less, width is
+// returned unchanged. The returned function is not safe for
+// concurrent use, which is not an issue because printers are
+// only called while the writer's mutex is locked.
+func cacheWidth(width func() int, d time.Duration) func() int {
+	if d <= 0 {
+		return width
+	}
+	var (
+		cached  int
+		expires time.Time
+	)
+	return func() int {
+		now := time.Now()
+		if !now.Before(expires) {
+			cached = width()
+			expires = now.Add(d)
+		}
+		return cached
+	}
 }
 
 // simplePrinter prints to a non-terminal device
 type simplePrinter struct {
-	w io.Writer
+	w       io.Writer
+	crlf    bool
+	maxLine int
+	kvFirst bool
+	kvSep   string
+}
+
+// keyvalsSeparator returns the separator between the message text and the
+// key/value pairs. The default is a space, or " | " if the key/value pairs
+// are printed first.
+func keyvalsSeparator(sep string, kvFirst bool) string {
+	switch {
+	case sep != "":
+		return sep
+	case kvFirst:
+		return " | "
+	}
+	return " "
 }
 
 func (p *simplePrinter) Print(msg *logEntry) {
@@ -67,6 +409,11 @@ func (p *simplePrinter) Print(msg *logEntry) {
 		buf.Write(msg.Time)
 		buf.WriteRune(' ')
 	}
+	if msg.Label != "" {
+		buf.WriteRune('[')
+		buf.WriteString(msg.Label)
+		buf.WriteString("] ")
+	}
 	if len(msg.File) > 0 {
 		buf.Write(msg.File)
 		buf.WriteString(": ")
@@ -75,25 +422,162 @@ func (p *simplePrinter) Print(msg *logEntry) {
 		buf.WriteString(msg.Level)
 		buf.WriteString(": ")
 	}
+	if p.kvFirst {
+		for i := 0; i < len(msg.List); i += 2 {
+			if i > 0 {
+				buf.WriteRune(' ')
+			}
+			logfmt.WriteBytesKeyValue(buf, msg.List[i], msg.List[i+1])
+		}
+		if len(msg.List) > 0 && len(msg.Text) > 0 {
+			buf.WriteString(keyvalsSeparator(p.kvSep, true))
+		}
+		buf.Write(msg.Text)
+		writeLine(p.w, buf, p.crlf, p.maxLine)
+		pool.ReleaseBuffer(buf)
+		return
+	}
 	buf.Write(msg.Text)
 	for i := 0; i < len(msg.List); i += 2 {
-		buf.WriteRune(' ')
-		logfmt.WriteKeyValue(buf, msg.List[i], msg.List[i+1])
+		if i > 0 {
+			buf.WriteRune(' ')
+		} else if len(msg.Text) > 0 {
+			// no separator if there is no text
+			buf.WriteString(keyvalsSeparator(p.kvSep, false))
+		}
+		logfmt.WriteBytesKeyValue(buf, msg.List[i], msg.List[i+1])
 	}
-	buf.WriteRune('\n')
-	p.w.Write(buf.Bytes())
+	writeLine(p.w, buf, p.crlf, p.maxLine)
 	pool.ReleaseBuffer(buf)
 }
 
+// logfmtPrinter prints messages in logfmt format
+type logfmtPrinter struct {
+	w       io.Writer
+	crlf    bool
+	maxLine int
+}
+
+func (p *logfmtPrinter) Print(msg *logEntry) {
+	buf := pool.AllocBuffer()
+	sep := func() {
+		if buf.Len() > 0 {
+			buf.WriteRune(' ')
+		}
+	}
+	if ts := bytes.TrimSpace(bytes.Join([][]byte{msg.Date, msg.Time}, []byte{' '})); len(ts) > 0 {
+		logfmt.WriteKeyValue(buf, "ts", ts)
+	}
+	if prefix := strings.TrimSpace(msg.Prefix); prefix != "" {
+		sep()
+		logfmt.WriteKeyValue(buf, "prefix", prefix)
+	}
+	if msg.Label != "" {
+		sep()
+		logfmt.WriteKeyValue(buf, labelKey, msg.Label)
+	}
+	if len(msg.File) > 0 {
+		sep()
+		logfmt.WriteKeyValue(buf, "caller", msg.File)
+	}
+	if msg.Level != "" {
+		sep()
+		logfmt.WriteKeyValue(buf, "level", msg.Level)
+	}
+	if len(msg.Text) > 0 {
+		sep()
+		logfmt.WriteKeyValue(buf, "msg", msg.Text)
+	}
+	for i := 0; i < len(msg.List); i += 2 {
+		sep()
+		logfmt.WriteBytesKeyValue(buf, msg.List[i], msg.List[i+1])
+	}
+	writeLine(p.w, buf, p.crlf, p.maxLine)
+	pool.ReleaseBuffer(buf)
+}
+
+// chainPrinter passes messages to another writer, which
+// formats them as if they were written by its own logger.
+type chainPrinter struct {
+	next   *Writer
+	render *bytes.Buffer // receives the message from next when rendering, or nil
+}
+
+func (p *chainPrinter) Print(msg *logEntry) {
+	buf := pool.AllocBuffer()
+	if msg.Level != "" {
+		buf.WriteString(msg.Level)
+		buf.WriteString(": ")
+	}
+	buf.Write(msg.Text)
+	if msg.Label != "" {
+		if buf.Len() > 0 {
+			buf.WriteRune(' ')
+		}
+		logfmt.WriteKeyValue(buf, labelKey, msg.Label)
+	}
+	for i := 0; i < len(msg.List); i += 2 {
+		if buf.Len() > 0 {
+			buf.WriteRune(' ')
+		}
+		logfmt.WriteBytesKeyValue(buf, msg.List[i], msg.List[i+1])
+	}
+	p.next.mutex.Lock()
+	p.next.rendering = p.render
+	p.next.writeEntry(&logEntry{
+		Timestamp: msg.Timestamp,
+		Prefix:    msg.Prefix,
+		Date:      msg.Date,
+		Time:      msg.Time,
+		File:      msg.File,
+	}, buf.Bytes(), nil)
+	p.next.rendering = nil
+	p.next.mutex.Unlock()
+	pool.ReleaseBuffer(buf)
+}
+
+// renderPrinter prints messages formatted by a user-supplied function.
+type renderPrinter struct {
+	w        io.Writer
+	render   func(*Message) ([]byte, error)
+	fallback printer // used if render fails
+}
+
+func (p *renderPrinter) Print(msg *logEntry) {
+	b, err := p.render(newMessage(msg))
+	if err != nil {
+		p.fallback.Print(msg)
+		return
+	}
+	p.w.Write(b)
+}
+
 // terminalPrinter is used to write log messages to an ANSI terminal.
 type terminalPrinter struct {
-	w       io.Writer
-	width   func() int
-	nocolor bool
+	w          io.Writer
+	width      func() int
+	nocolor    bool
+	profile    ColorProfile
+	effects    map[string]string // effects converted for the color profile
+	theme      Theme
+	tabWidth   int
+	alignKeys  bool
+	crlf       bool
+	indentStr  string // overrides the computed indent if not empty
+	maxLine    int
+	highlight  *regexp.Regexp
+	padding    int // columns unused at end of line, -1 for none, 0 for default
+	kvFirst    bool
+	kvSep      string
+	expandJSON bool
+	minWidth   int // truncate lines if the terminal is narrower, or zero
+	kvColumn   int // column where key/value pairs start, or zero
+	maxLines   int // maximum continuation lines for a message, or zero
 
 	buf    *bytes.Buffer
 	indent int
 	col    int  // current column number
+	bol    bool // at beginning of line, after header or indent
 	infmt  bool // inside a format
 }
 
@@ -106,17 +590,39 @@ func (p *terminalPrinter) reset() {
 
 func (p *terminalPrinter) writeString(s string) {
 	p.buf.WriteString(s)
-	p.col += utf8.RuneCountInString(s)
+	p.col += terminal.StringWidth(s)
 }
 
 func (p *terminalPrinter) writeRune(r rune) {
 	p.buf.WriteRune(r)
-	p.col++
+	p.col += terminal.RuneWidth(r)
 }
 
 func (p *terminalPrinter) write(b []byte) {
 	p.buf.Write(b)
-	p.col += utf8.RuneCount(b)
+	p.col += terminal.Width(b)
+}
+
+// writeHighlighted writes b, highlighting any text that matches
+// the highlight regular expression using inverse video. The escape
+// sequences do not change the current color.
+func (p *terminalPrinter) writeHighlighted(b []byte) {
+	if p.highlight == nil || p.nocolor {
+		p.write(b)
+		return
+	}
+	var pos int
+	for _, loc := range p.highlight.FindAllIndex(b, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		p.write(b[pos:loc[0]])
+		p.buf.WriteString("\x1b[7m")
+		p.write(b[loc[0]:loc[1]])
+		p.buf.WriteString("\x1b[27m")
+		pos = loc[1]
+	}
+	p.write(b[pos:])
 }
 
 var ansiRE = regexp.MustCompile(`^[0-9]+(;[0-9]+)*$`)
@@ -131,6 +637,9 @@ func (p *terminalPrinter) startFormat(effect string) {
 		p.buf.WriteRune('m')
 		p.infmt = true
 	} else if ansiRE.MatchString(effect) {
+		if p.profile == Profile16 || p.profile == Profile256 {
+			effect = p.convertEffect(effect)
+		}
 		p.buf.WriteString("\x1b[0;")
 		p.buf.WriteString(effect)
 		p.buf.WriteRune('m')
@@ -138,12 +647,41 @@ func (p *terminalPrinter) startFormat(effect string) {
 	}
 }
 
+// convertEffect returns effect converted to colors that can be displayed
+// with the printer's color profile. Converted effects are cached, because
+// the same few effects are used for every message.
+func (p *terminalPrinter) convertEffect(effect string) string {
+	if converted, ok := p.effects[effect]; ok {
+		return converted
+	}
+	if p.effects == nil {
+		p.effects = make(map[string]string)
+	}
+	converted := convertEffect(effect, p.profile)
+	p.effects[effect] = converted
+	return converted
+}
+
 func (p *terminalPrinter) newline() {
-	p.buf.WriteRune('\n')
-	for i := 0; i < p.indent; i++ {
-		p.buf.WriteRune(' ')
+	trimTrailingSpace(p.buf)
+	writeNewline(p.buf, p.crlf)
+	if p.indentStr != "" {
+		p.buf.WriteString(p.indentStr)
+	} else {
+		for i := 0; i < p.indent; i++ {
+			p.buf.WriteRune(' ')
+		}
 	}
 	p.col = p.indent
+	p.bol = true
+}
+
+// indentString returns the indent for continuation lines.
+func (p *terminalPrinter) indentString() string {
+	if p.indentStr != "" {
+		return p.indentStr
+	}
+	return strings.Repeat(" ", p.indent)
 }
 
 func (p *terminalPrinter) resetFormat() {
@@ -153,6 +691,32 @@ func (p *terminalPrinter) resetFormat() {
 	}
 }
 
+// lineWidth returns the number of columns available for printing
+// each line, which is the terminal width less the padding.
+func (p *terminalPrinter) lineWidth() int {
+	return p.paddedWidth(p.width())
+}
+
+// paddedWidth returns the line width for a terminal that
+// is width columns wide. A width of zero means unlimited.
+func (p *terminalPrinter) paddedWidth(width int) int {
+	if width == 0 {
+		return noWrapWidth
+	}
+	padding := p.padding
+	switch {
+	case padding < 0:
+		padding = 0
+	case padding == 0:
+		padding = defaultWidthPadding
+	}
+	width -= padding
+	if width <= 0 {
+		width = defaultTerminalWidth
+	}
+	return width
+}
+
 func (p *terminalPrinter) Print(msg *logEntry) {
 	p.buf = pool.AllocBuffer()
 
@@ -161,23 +725,34 @@ func (p *terminalPrinter) Print(msg *logEntry) {
 		// no space here to match the way prefixes
 		// work in the log package
 	}
-	if len(msg.Date) > 0 {
-		p.write(msg.Date)
-		p.writeRune(' ')
+	if len(msg.Date) > 0 || len(msg.Time) > 0 {
+		p.startFormat(p.theme.Timestamp)
+		if len(msg.Date) > 0 {
+			p.write(msg.Date)
+			p.writeRune(' ')
+		}
+		if len(msg.Time) > 0 {
+			p.write(msg.Time)
+			p.writeRune(' ')
+		}
+		p.resetFormat()
 	}
-	if len(msg.Time) > 0 {
-		p.write(msg.Time)
-		p.writeRune(' ')
+	if msg.Label != "" {
+		p.writeRune('[')
+		p.writeString(msg.Label)
+		p.writeString("] ")
 	}
 
 	// indent is the hanging indent for messages that span multiple lines
 	p.indent = p.col
-	if p.indent == 0 {
+	if p.indentStr != "" {
+		p.indent = terminal.StringWidth(p.indentStr)
+	} else if p.indent == 0 {
 		p.indent = 4
 	}
 
 	if len(msg.File) > 0 {
-		p.startFormat("bright black")
+		p.startFormat(p.theme.File)
 		p.write(msg.File)
 		p.writeString(": ")
 		p.resetFormat()
@@ -190,89 +765,277 @@ func (p *terminalPrinter) Print(msg *logEntry) {
 		p.resetFormat()
 	}
 
-	// print to one less than the terminal width because some terminals
-	// don't format nicely otherwise (eg git bash)
-	width := p.width() - 1
-	if width <= 0 {
-		width = defaultTerminalWidth
+	termWidth := p.width()
+	width := p.paddedWidth(termWidth)
+	// On a terminal that is too narrow for wrapping to be useful,
+	// each line is printed in full and then truncated.
+	narrow := termWidth > 0 && termWidth < p.minWidth
+	if narrow {
+		width = noWrapWidth
+	}
+	if p.indentStr == "" && p.indent > width/2 {
+		// A long prefix on a narrow terminal leaves little or no
+		// room for continuation lines, so use the minimum indent.
+		p.indent = 4
 	}
+	p.bol = true
 
-	// print message text with line wrapping
-	for in := msg.Text; len(in) > 0; {
-		var (
-			wsLen, bsLen, punctLen int
-			punct                  rune
-		)
-		ws := whiteSpaceRE.Find(in)
-		if n := len(ws); n > 0 {
-			in = in[n:]
-			wsLen = 1
-		}
-		bs := blackSpaceRE.Find(in)
-		if len(bs) > 0 {
-			in = in[len(bs):]
-			bsLen = utf8.RuneCount(bs)
-		}
-
-		// The black space RE will terminate before punctuation to handle very long
-		// strings with no spaces but possibly punctuation. Detect if it has terminated
-		// before punctuation, and if so include the punctuation char on the same line.
-		if len(in) > 0 {
-			var size int
-			punct, size = utf8.DecodeRune(in)
-			if !unicode.IsSpace(punct) {
-				punctLen = size
-				in = in[size:]
-			}
+	if p.kvFirst {
+		// The message text wraps in the space remaining after the
+		// key/value pairs. The separator is printed as part of the
+		// text, so that it wraps with the first word of the text.
+		p.printWrapped(msg.List, width)
+		if len(msg.List) > 0 && len(msg.Text) > 0 {
+			text := pool.AllocBuffer()
+			text.WriteString(keyvalsSeparator(p.kvSep, true))
+			text.Write(msg.Text)
+			p.printMessageText(msg, text.Bytes(), width)
+			pool.ReleaseBuffer(text)
+		} else {
+			p.printMessageText(msg, msg.Text, width)
+		}
+		trimTrailingSpace(p.buf)
+		if p.maxLines > 0 {
+			limitLines(p.buf, p.maxLines, p.indentString())
 		}
+		if narrow {
+			truncateLines(p.buf, p.minWidth)
+		}
+		writeLine(p.w, p.buf, p.crlf, p.maxLine)
+		p.reset()
+		return
+	}
 
-		if bsLen+punctLen == 0 {
-			// trailing white space
-			continue
+	p.printMessageText(msg, msg.Text, width)
+	if len(msg.Text) > 0 && len(msg.List) > 0 {
+		// The separator wraps like message text. White space at
+		// the end is replaced by the space before the first pair.
+		p.printText([]byte(keyvalsSeparator(p.kvSep, false)), width)
+	}
+
+	if p.alignKeys && !p.fitsOnLine(msg.List, width) {
+		p.printAligned(msg.List)
+	} else {
+		if len(msg.Text) > 0 && p.kvColumn > 0 {
+			p.padToColumn(p.kvColumn, width)
 		}
+		p.printWrapped(msg.List, width)
+	}
 
-		if bsLen+wsLen+punctLen+p.col > width {
-			p.newline()
-			p.write(bs)
-		} else {
-			if len(ws) > 0 {
-				p.writeRune(' ')
-			}
-			p.write(bs)
+	trimTrailingSpace(p.buf)
+	if p.maxLines > 0 {
+		limitLines(p.buf, p.maxLines, p.indentString())
+	}
+	if narrow {
+		truncateLines(p.buf, p.minWidth)
+	}
+	writeLine(p.w, p.buf, p.crlf, p.maxLine)
+	p.reset()
+}
+
+// padToColumn pads the current line with spaces so that the key/value
+// pairs, which follow a single space, start at column col. If the line
+// is already too long, the key/value pairs start on the next line. Nothing
+// is done if col is beyond the line width.
+func (p *terminalPrinter) padToColumn(col int, width int) {
+	switch {
+	case col > width:
+		return
+	case p.col < col:
+		p.Space(col - 1 - p.col)
+	default:
+		p.newline()
+	}
+}
+
+// printMessageText prints the text of msg, which is displayed with the
+// effect of its level if the message is an error and the whole error
+// line is colored.
+func (p *terminalPrinter) printMessageText(msg *logEntry, text []byte, width int) {
+	if msg.ErrorText && len(text) > 0 {
+		p.startFormat(msg.Effect)
+		p.printText(text, width)
+		p.resetFormat()
+		return
+	}
+	p.printText(text, width)
+}
+
+// printText prints the message text, wrapping lines that would
+// be wider than width.
+func (p *terminalPrinter) printText(text []byte, width int) {
+	if p.col+len(text) <= width && (p.highlight == nil || p.nocolor) && isPlainText(text) {
+		// Most messages are short, and fit on the line as they are, so
+		// there is no need to break them into words.
+		p.write(text)
+		p.bol = false
+		return
+	}
+	wrap.Text(p, text, width)
+}
+
+// isPlainText reports whether text is printed unchanged by wrap.Text when
+// it fits on the line. This is true when text consists of printable ASCII
+// words separated by single spaces, because it contains no white space to
+// collapse, tabs to expand, line breaks or escape sequences. Each byte of
+// plain text occupies one column.
+func isPlainText(text []byte) bool {
+	if len(text) == 0 || text[0] == ' ' || text[len(text)-1] == ' ' {
+		return false
+	}
+	for i, c := range text {
+		if c < ' ' || c >= 0x7f || c == ' ' && text[i-1] == ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// printWrapped prints key/value pairs with line wrapping.
+func (p *terminalPrinter) printWrapped(list [][]byte, width int) {
+	wrap.Pairs(p, list, width)
+}
+
+// The following methods implement the wrap.Printer interface.
+
+func (p *terminalPrinter) Column() int     { return p.col }
+func (p *terminalPrinter) LineStart() bool { return p.bol }
+func (p *terminalPrinter) TabWidth() int   { return p.tabWidth }
+func (p *terminalPrinter) Newline()        { p.newline() }
+func (p *terminalPrinter) Escape(b []byte) { p.buf.Write(b) }
+
+func (p *terminalPrinter) CanWrap() bool {
+	return !p.bol || p.col > p.indent
+}
+
+func (p *terminalPrinter) Space(n int) {
+	for i := 0; i < n; i++ {
+		p.writeRune(' ')
+	}
+}
+
+func (p *terminalPrinter) Word(b []byte) {
+	p.writeHighlighted(b)
+	p.bol = false
+}
+
+func (p *terminalPrinter) Punct(r rune) {
+	p.writeRune(r)
+	p.bol = false
+}
+
+func (p *terminalPrinter) PairWidth(key, value []byte) int {
+	const equalsLen = 1
+	if p.isJSON(value) {
+		// only the opening bracket is on this line
+		return terminal.Width(key) + equalsLen + 1
+	}
+	return terminal.Width(key) + equalsLen + terminal.Width(value)
+}
+
+func (p *terminalPrinter) Pair(key, value []byte) {
+	p.startFormat(p.theme.Key)
+	p.write(key)
+	p.resetFormat()
+	p.writeRune('=')
+	p.writeValue(value)
+	p.bol = false
+}
+
+// fitsOnLine reports whether all of the key/value pairs fit
+// on the current line.
+func (p *terminalPrinter) fitsOnLine(list [][]byte, width int) bool {
+	col := p.col
+	for i := 0; i < len(list); i += 2 {
+		if i > 0 || !p.bol {
+			col++
 		}
-		if punctLen > 0 {
-			p.writeRune(punct)
+		col += terminal.Width(list[i]) + 1 + terminal.Width(list[i+1])
+		if col > width {
+			return false
 		}
 	}
+	return true
+}
 
-	// print key/value pairs with line wrapping
-	for i := 0; i < len(msg.List); i += 2 {
-		key := msg.List[i]
-		val := msg.List[i+1]
-		keyLen := utf8.RuneCount(key)
-		valLen := utf8.RuneCount(val)
-		const equalsLen = 1
-		var wsLen int
-		if p.col > p.indent {
-			wsLen = 1
-		}
-		if keyLen+valLen+equalsLen+wsLen+p.col > width {
-			p.newline()
-			wsLen = 0
+// printAligned prints each key/value pair on its own line,
+// with the keys padded so that the equals signs line up.
+func (p *terminalPrinter) printAligned(list [][]byte) {
+	var maxKeyLen int
+	for i := 0; i < len(list); i += 2 {
+		if keyLen := terminal.Width(list[i]); keyLen > maxKeyLen {
+			maxKeyLen = keyLen
 		}
-		if wsLen > 0 {
+	}
+	for i := 0; i < len(list); i += 2 {
+		p.newline()
+		p.startFormat(p.theme.Key)
+		p.write(list[i])
+		p.resetFormat()
+		for n := terminal.Width(list[i]); n < maxKeyLen; n++ {
 			p.writeRune(' ')
 		}
-		p.write(key)
 		p.writeRune('=')
-		p.startFormat("bright cyan")
-		p.write(val)
+		p.writeValue(list[i+1])
+	}
+}
+
+// writeValue writes the value of a key/value pair. If the value is a JSON
+// object or array, and JSON values are expanded, it is printed indented
+// on continuation lines.
+func (p *terminalPrinter) writeValue(val []byte) {
+	if !p.isJSON(val) {
+		p.startFormat(p.theme.Value)
+		p.writeHighlighted(val)
+		p.resetFormat()
+		return
+	}
+	buf := pool.AllocBuffer()
+	json.Indent(buf, val, "", "  ")
+	for i, line := range bytes.Split(buf.Bytes(), []byte{'\n'}) {
+		if i > 0 {
+			p.newline()
+		}
+		p.startFormat(p.theme.Value)
+		p.writeHighlighted(line)
 		p.resetFormat()
 	}
+	pool.ReleaseBuffer(buf)
+}
 
-	p.writeRune('\n')
-	p.w.Write(p.buf.Bytes())
-	p.reset()
+// isJSON reports whether val is a JSON object or array that
+// is printed on multiple lines because JSON values are expanded.
+func (p *terminalPrinter) isJSON(val []byte) bool {
+	if !p.expandJSON || len(val) < 3 {
+		// too short to contain anything
+		return false
+	}
+	if c := val[0]; c != '{' && c != '[' {
+		return false
+	}
+	return json.Valid(val)
+}
+
+// scan returns the length of the longest prefix of b
+// consisting of bytes for which fn reports true.
+func scan(b []byte, fn func(byte) bool) int {
+	for i, c := range b {
+		if !fn(c) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// isWhiteSpace reports whether c is white space. This matches the
+// `\s` character class in regular expressions, which is restricted
+// to ASCII, so it is safe to scan UTF-8 text one byte at a time.
+func isWhiteSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\f', '\r':
+		return true
+	}
+	return false
 }
 
 var colorEffects = map[string]string{
@@ -296,6 +1059,17 @@ var colorEffects = map[string]string{
 	"bright white":   "37;1",
 }
 
+// terminalDescriptor returns the file descriptor of the terminal
+// associated with w, unwrapping w if necessary.
+func terminalDescriptor(w io.Writer) (fd int, ok bool) {
+	for ; w != nil; w = unwrap(w) {
+		if fd, ok := fileDescriptor(w); ok {
+			return fd, terminal.IsTerminal(fd)
+		}
+	}
+	return 0, false
+}
+
 // fileDescriptor returns the file descriptor associated with the
 // writer, or (0, false) if no file descriptor is available.
 func fileDescriptor(w io.Writer) (fd int, ok bool) {
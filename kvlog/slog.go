@@ -0,0 +1,212 @@
+//go:build go1.21
+// +build go1.21
+
+package kvlog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/jjeffery/kv/internal/logfmt"
+	"github.com/jjeffery/kv/internal/parse"
+	"github.com/jjeffery/kv/internal/pool"
+)
+
+// slogHandler implements the slog.Handler interface.
+type slogHandler struct {
+	output *Writer // shared by a handler and all handlers derived from it
+	opts   slog.HandlerOptions
+	attrs  [][]byte // pre-formatted key/value pairs
+	quoted []bool   // reports whether each item in attrs is a string
+	group  string   // prefix for keys, including trailing "."
+	groups []string // open groups, for ReplaceAttr
+}
+
+// NewSlogHandler returns a slog.Handler that writes records to w in the
+// same way as a Writer, including line wrapping and color display if
+// w is a terminal. Attributes in groups are printed with their keys
+// qualified by the group names, separated by dots. If opts is nil,
+// the default options are used.
+//
+// If w is a *Writer, records are written with the writer's options, so
+// that its levels, suppressed and verbose prefixes, handlers and sinks
+// apply to records in the same way as to messages written by a logger.
+// Otherwise records are written by a new writer created with NewWriter.
+// The level of each record is printed at the beginning of the message
+// text, and is one of "debug", "info", "warning" or "error".
+//
+// If opts.ReplaceAttr is specified, it is called for the record's time
+// (with key slog.TimeKey) and for each attribute. Returning an empty
+// attribute for the time omits the date and time from the output.
+func NewSlogHandler(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	output, ok := w.(*Writer)
+	if !ok {
+		output = NewWriter(w)
+	}
+	h := &slogHandler{
+		output: output,
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// Enabled implements the slog.Handler interface.
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+// Handle implements the slog.Handler interface.
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	hdr := logEntry{
+		Timestamp: r.Time,
+	}
+	if hdr.Timestamp.IsZero() {
+		hdr.Timestamp = time.Now()
+	}
+	tm := r.Time
+	if h.opts.ReplaceAttr != nil && !tm.IsZero() {
+		// the time is the only built-in attribute that can
+		// be replaced, and only with another time
+		a := h.opts.ReplaceAttr(nil, slog.Time(slog.TimeKey, tm))
+		a.Value = a.Value.Resolve()
+		if a.Value.Kind() == slog.KindTime {
+			tm = a.Value.Time()
+		} else {
+			tm = time.Time{}
+		}
+	}
+	if !tm.IsZero() {
+		hdr.Date = []byte(tm.Format("2006/01/02"))
+		hdr.Time = []byte(tm.Format("15:04:05"))
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		frame, _ := frames.Next()
+		hdr.File = []byte(filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line))
+	}
+	msg := parse.Message{
+		List:   make([][]byte, len(h.attrs), len(h.attrs)+r.NumAttrs()*2),
+		Quoted: make([]bool, len(h.quoted), len(h.quoted)+r.NumAttrs()*2),
+	}
+	copy(msg.List, h.attrs)
+	copy(msg.Quoted, h.quoted)
+	r.Attrs(func(a slog.Attr) bool {
+		msg.List, msg.Quoted = h.appendAttr(msg.List, msg.Quoted, h.group, h.groups, a)
+		return true
+	})
+
+	// The writer is passed the message text and the key/value pairs,
+	// so the message text is not parsed for key/value pairs. The record
+	// is also formatted as text, which is used to decide whether the
+	// message is suppressed, sampled or a repeat.
+	buf := pool.AllocBuffer()
+	defer pool.ReleaseBuffer(buf)
+	buf.WriteString(slogLevel(r.Level))
+	buf.WriteString(": ")
+	buf.WriteString(r.Message)
+	msg.Text = []byte(buf.String())
+	for i := 0; i < len(msg.List); i += 2 {
+		buf.WriteRune(' ')
+		logfmt.WriteBytesKeyValue(buf, msg.List[i], msg.List[i+1])
+	}
+
+	h.output.mutex.Lock()
+	defer h.output.mutex.Unlock()
+	return h.output.writeEntry(&hdr, buf.Bytes(), &msg)
+}
+
+// WithAttrs implements the slog.Handler interface.
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.attrs = make([][]byte, len(h.attrs), len(h.attrs)+len(attrs)*2)
+	h2.quoted = make([]bool, len(h.quoted), len(h.quoted)+len(attrs)*2)
+	copy(h2.attrs, h.attrs)
+	copy(h2.quoted, h.quoted)
+	for _, a := range attrs {
+		h2.attrs, h2.quoted = h.appendAttr(h2.attrs, h2.quoted, h.group, h.groups, a)
+	}
+	return &h2
+}
+
+// WithGroup implements the slog.Handler interface.
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group = h.group + name + "."
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &h2
+}
+
+// appendAttr appends the attribute to list as one or more key/value
+// pairs, and appends the quoted flag of each key and value to quoted.
+// Group attributes are flattened, with keys qualified by the group name.
+// Values other than numbers and booleans are flagged as quoted, so that
+// they remain strings when converted to OpenTelemetry attributes.
+func (h *slogHandler) appendAttr(list [][]byte, quoted []bool, prefix string, groups []string, a slog.Attr) ([][]byte, []bool) {
+	a.Value = a.Value.Resolve()
+	if h.opts.ReplaceAttr != nil && a.Value.Kind() != slog.KindGroup {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return list, quoted
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return list, quoted
+		}
+		if a.Key != "" {
+			prefix = prefix + a.Key + "."
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, ga := range attrs {
+			list, quoted = h.appendAttr(list, quoted, prefix, groups, ga)
+		}
+		return list, quoted
+	}
+	switch a.Value.Kind() {
+	case slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindBool:
+		quoted = append(quoted, false, false)
+	default:
+		quoted = append(quoted, false, true)
+	}
+	return append(list, []byte(prefix+a.Key), []byte(slogValue(a.Value))), quoted
+}
+
+// slogValue returns the text representation of v.
+func slogValue(v slog.Value) string {
+	if v.Kind() == slog.KindTime {
+		return v.Time().Format(time.RFC3339Nano)
+	}
+	return v.String()
+}
+
+// slogLevel returns the kvlog level corresponding to the slog level.
+func slogLevel(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "debug"
+	case level < slog.LevelWarn:
+		return "info"
+	case level < slog.LevelError:
+		return "warning"
+	}
+	return "error"
+}
@@ -0,0 +1,212 @@
+//go:build go1.21
+// +build go1.21
+
+package kvlog
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjeffery/kv"
+)
+
+func TestSlogHandler(t *testing.T) {
+	tm := time.Date(2099, 12, 31, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		fn     func(logger *slog.Logger)
+		output string
+	}{
+		{
+			fn: func(logger *slog.Logger) {
+				logger.Info("message text", "a", 1, "b", "value 2")
+			},
+			output: "info: message text a=1 b=\"value 2\"\n",
+		},
+		{
+			fn: func(logger *slog.Logger) {
+				logger.Debug("not displayed")
+			},
+			output: "",
+		},
+		{
+			fn: func(logger *slog.Logger) {
+				logger.Warn("message text", "t", tm, "d", time.Second)
+			},
+			output: "warning: message text t=\"2099-12-31T12:34:56Z\" d=1s\n",
+		},
+		{
+			fn: func(logger *slog.Logger) {
+				logger.With("a", 1).WithGroup("g").With("b", 2).Error("message text", "c", 3)
+			},
+			output: "error: message text a=1 g.b=2 g.c=3\n",
+		},
+		{
+			fn: func(logger *slog.Logger) {
+				logger.Info("message text", slog.Group("req", "method", "get", slog.Group("url", "path", "x")))
+			},
+			output: "info: message text req.method=get req.url.path=x\n",
+		},
+		{
+			fn: func(logger *slog.Logger) {
+				logger.WithGroup("").Info("message text", slog.Group("", "a", 1), slog.Group("empty"))
+			},
+			output: "info: message text a=1\n",
+		},
+		{
+			fn: func(logger *slog.Logger) {
+				logger.Info("message text", "password", "secret")
+			},
+			output: "info: message text password=\"****\"\n",
+		},
+	}
+
+	for tn, tt := range tests {
+		var buf bytes.Buffer
+		h := NewSlogHandler(&buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey && len(groups) == 0 {
+					return slog.Attr{}
+				}
+				if a.Key == "password" {
+					a.Value = slog.StringValue("****")
+				}
+				return a
+			},
+		})
+		tt.fn(slog.New(h))
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestSlogHandlerTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewSlogHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Time(slog.TimeKey, time.Date(2099, 12, 31, 12, 34, 56, 0, time.UTC))
+			}
+			return a
+		},
+	}).(*slogHandler)
+	h.output.printer = &terminalPrinter{
+		w:     &buf,
+		width: func() int { return 50 },
+		theme: DefaultTheme(),
+	}
+	logger := slog.New(h)
+	logger.Error("this is the message text", "key1", "value1", "key2", "value2")
+	logger.Debug("debug message")
+	want := "2099/12/31 12:34:56 \x1b[0;31merror: \x1b[0mthis is the message\n" +
+		"                    text \x1b[0;36mkey1\x1b[0m=\x1b[0;96mvalue1\x1b[0m \x1b[0;36mkey2\x1b[0m=\x1b[0;96mvalue2\x1b[0m\n" +
+		"2099/12/31 12:34:56 debug: debug message\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
+
+func TestSlogHandlerWriter(t *testing.T) {
+	output := NewCapture(0)
+	w := output.Writer
+	w.Logfmt()
+	w.Redact("password")
+	w.Suppress("warning")
+	var levels []string
+	w.Handle(&testHandler{handle: func(msg *Message) {
+		levels = append(levels, msg.Level)
+	}})
+	h := NewSlogHandler(w, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := slog.New(h)
+	logger.Info("message text", "password", "secret")
+	logger.Warn("suppressed")
+	logger.Error("message text", "a", 1)
+	want := "level=info msg=\"message text\" password=\"****\"\n" +
+		"level=error msg=\"message text\" a=1\n"
+	if got := output.String(); got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+	if got, want := strings.Join(levels, ","), "info,error"; got != want {
+		t.Errorf("levels: got=%q want=%q", got, want)
+	}
+}
+
+func TestSlogHandlerMessageText(t *testing.T) {
+	tests := []struct {
+		setup  func(w *Writer) *Writer
+		output string
+	}{
+		{
+			setup: func(w *Writer) *Writer {
+				return w
+			},
+			output: "level=info msg=\"cache miss key=abc\" attempt=2\n",
+		},
+		{
+			setup: func(w *Writer) *Writer {
+				return Tee(w)
+			},
+			output: "level=info msg=\"cache miss key=abc\" attempt=2\n",
+		},
+		{
+			setup: func(w *Writer) *Writer {
+				w.LevelAliases(map[string]string{"info": "alert"})
+				return w
+			},
+			output: "level=alert msg=\"cache miss key=abc\" attempt=2\n",
+		},
+		{
+			setup: func(w *Writer) *Writer {
+				tee := Tee(w)
+				tee.LevelAliases(map[string]string{"info": "alert"})
+				return tee
+			},
+			output: "level=alert msg=\"cache miss key=abc\" attempt=2\n",
+		},
+	}
+	for tn, tt := range tests {
+		output := NewCapture(0)
+		output.Logfmt()
+		output.NoTime()
+		h := NewSlogHandler(tt.setup(output.Writer), nil)
+		slog.New(h).Info("cache miss key=abc", "attempt", 2)
+		if got, want := output.String(), tt.output; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestSlogHandlerOTel(t *testing.T) {
+	var records []OTelRecord
+	output := NewOTelWriter(func(r *OTelRecord) {
+		records = append(records, *r)
+	})
+	logger := slog.New(NewSlogHandler(output, nil))
+	logger.Warn("cache miss key=abc", "n", 2, "s", "2", "ok", true)
+	if got, want := len(records), 1; got != want {
+		t.Fatalf("got=%d records, want=%d", got, want)
+	}
+	r := records[0]
+	if got, want := r.Body, "cache miss key=abc"; got != want {
+		t.Errorf("body: got=%q want=%q", got, want)
+	}
+	if got, want := r.SeverityText, "warning"; got != want {
+		t.Errorf("severity: got=%q want=%q", got, want)
+	}
+	want := kv.List{"n", 2, "s", "2", "ok", true}
+	if got := r.Attributes; !reflect.DeepEqual(got, want) {
+		t.Errorf("attributes: got=%#v want=%#v", got, want)
+	}
+}
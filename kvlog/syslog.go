@@ -0,0 +1,84 @@
+package kvlog
+
+import (
+	"bytes"
+	"strings"
+)
+
+// syslogger is the subset of the methods of *syslog.Writer
+// used to print messages to syslog.
+type syslogger interface {
+	Debug(m string) error
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+	Crit(m string) error
+	Alert(m string) error
+}
+
+// syslogPrinter prints messages to syslog.
+type syslogPrinter struct {
+	w        syslogger
+	levelKey []byte
+	buf      bytes.Buffer
+}
+
+func (p *syslogPrinter) Print(msg *logEntry) {
+	// syslog records the time of each message
+	ent := *msg
+	ent.Date = nil
+	ent.Time = nil
+	p.buf.Reset()
+	(&simplePrinter{w: &p.buf}).Print(&ent)
+	text := strings.TrimSuffix(p.buf.String(), newline)
+
+	switch severity(entryLevel(msg, p.levelKey)) {
+	case 7:
+		p.w.Debug(text)
+	case 4:
+		p.w.Warning(text)
+	case 3:
+		p.w.Err(text)
+	case 2:
+		p.w.Crit(text)
+	case 1:
+		p.w.Alert(text)
+	default:
+		p.w.Info(text)
+	}
+}
+
+// entryLevel returns the level at the beginning of the message text, or
+// if there is none, the value of the level key in the key/value pairs.
+// If key is nil, the default level key is used.
+func entryLevel(msg *logEntry, key []byte) string {
+	if msg.Level != "" {
+		return msg.Level
+	}
+	if key == nil {
+		key = defaultLevelKey
+	}
+	for i := 0; i < len(msg.List); i += 2 {
+		if bytes.EqualFold(msg.List[i], key) {
+			return string(msg.List[i+1])
+		}
+	}
+	return ""
+}
+
+// severity returns the syslog severity for a level.
+func severity(level string) int {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return 7
+	case "warn", "warning":
+		return 4
+	case "error":
+		return 3
+	case "fatal":
+		return 2
+	case "alert":
+		return 1
+	}
+	return 6 // informational
+}
@@ -0,0 +1,82 @@
+package kvlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+)
+
+// fakeSyslog records messages printed to syslog.
+type fakeSyslog struct {
+	messages []string
+}
+
+func (s *fakeSyslog) print(severity, m string) error {
+	s.messages = append(s.messages, fmt.Sprintf("%s %s", severity, m))
+	return nil
+}
+
+func (s *fakeSyslog) Debug(m string) error   { return s.print("DEBUG", m) }
+func (s *fakeSyslog) Info(m string) error    { return s.print("INFO", m) }
+func (s *fakeSyslog) Warning(m string) error { return s.print("WARNING", m) }
+func (s *fakeSyslog) Err(m string) error     { return s.print("ERR", m) }
+func (s *fakeSyslog) Crit(m string) error    { return s.print("CRIT", m) }
+func (s *fakeSyslog) Alert(m string) error   { return s.print("ALERT", m) }
+
+func TestSyslogPrinter(t *testing.T) {
+	tests := []struct {
+		levelKey string
+		input    string
+		want     string
+	}{
+		{
+			input: "2009/11/10 23:00:00 debug: connecting host=db1",
+			want:  "DEBUG debug: connecting host=db1",
+		},
+		{
+			input: "2009/11/10 23:00:00 error: cannot connect host=db1 msg=\"timed out\"",
+			want:  `ERR error: cannot connect host=db1 msg="timed out"`,
+		},
+		{
+			input: "2009/11/10 23:00:00 warning: slow",
+			want:  "WARNING warning: slow",
+		},
+		{
+			input: "2009/11/10 23:00:00 fatal: exiting",
+			want:  "CRIT fatal: exiting",
+		},
+		{
+			input: "2009/11/10 23:00:00 started a=1",
+			want:  "INFO started a=1",
+		},
+		{
+			input: "2009/11/10 23:00:00 request failed level=error",
+			want:  "ERR request failed level=error",
+		},
+		{
+			levelKey: "severity",
+			input:    "2009/11/10 23:00:00 request slow severity=warn",
+			want:     "WARNING request slow severity=warn",
+		},
+	}
+
+	for tn, tt := range tests {
+		var sw fakeSyslog
+		output := NewWriter(ioutil.Discard)
+		output.SetVerbose(true)
+		output.syslog = &sw
+		if tt.levelKey != "" {
+			output.LevelKey(tt.levelKey)
+		} else {
+			output.setPrinter()
+		}
+		logger := log.New(ioutil.Discard, "", log.LstdFlags)
+		writer := newLogWriter(output, logger)
+		writer.Write([]byte(tt.input))
+		if got, want := sw.messages, []string{tt.want}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package kvlog
+
+import (
+	"io/ioutil"
+	"log/syslog"
+)
+
+// NewSyslogWriter returns a writer that prints messages to syslog. The
+// level of each message determines the syslog severity, so that a message
+// with a "debug" level is printed using sw.Debug, a message with an "error"
+// level is printed using sw.Err, and so on. If the message text does not
+// start with a level, the value of the level key is used (see LevelKey).
+// Messages without a known level are printed using sw.Info.
+//
+// Each message is printed on a single line, with the key/value pairs
+// in logfmt format. The date and time are not printed, because syslog
+// records the time of each message.
+func NewSyslogWriter(sw *syslog.Writer) *Writer {
+	w := NewWriter(ioutil.Discard)
+	w.mutex.Lock()
+	w.syslog = sw
+	w.setPrinter()
+	w.mutex.Unlock()
+	return w
+}
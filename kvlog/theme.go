@@ -0,0 +1,243 @@
+package kvlog
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Theme specifies the display effects used when printing to a terminal.
+// An effect is a color name (eg "red", "bright black"), or a string of
+// ANSI SGR parameters (eg "32;1"). An empty effect means the item is
+// displayed without any effect.
+type Theme struct {
+	Error     string // effect for levels displayed as errors
+	Warning   string // effect for levels displayed as warnings
+	Key       string // effect for keys
+	Value     string // effect for values
+	Timestamp string // effect for the date and time
+	File      string // effect for the file name and line number
+}
+
+// DefaultTheme returns the theme used by a writer unless
+// another theme is specified using the SetTheme method. This is
+// LightTheme if the terminal is known to have a light background,
+// and DarkTheme otherwise.
+func DefaultTheme() Theme {
+	if lightBackground() {
+		return LightTheme()
+	}
+	return DarkTheme()
+}
+
+// DarkTheme returns a theme that is readable on terminals
+// with a dark background.
+func DarkTheme() Theme {
+	return Theme{
+		Error:   "red",
+		Warning: "yellow",
+		Key:     "cyan",
+		Value:   "bright cyan",
+		File:    "bright black",
+	}
+}
+
+// LightTheme returns a theme that is readable on terminals
+// with a light background.
+func LightTheme() Theme {
+	return Theme{
+		Error:   "red",
+		Warning: "magenta",
+		Key:     "blue",
+		Value:   "cyan",
+		File:    "bright black",
+	}
+}
+
+// lightBackground reports whether the terminal has a light background,
+// as indicated by the COLORFGBG environment variable. This is set by
+// rxvt, Konsole and other terminals, and has the form "fg;bg" or
+// "fg;other;bg", where fg and bg are color numbers. Colors 7 (white)
+// and 9-15 (bright colors other than bright black) are light.
+func lightBackground() bool {
+	fgbg := os.Getenv("COLORFGBG")
+	if fgbg == "" {
+		return false
+	}
+	fields := strings.Split(fgbg, ";")
+	bg, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return false
+	}
+	return bg == 7 || (bg >= 9 && bg <= 15)
+}
+
+// ColorProfile describes the colors that a terminal can display.
+type ColorProfile int
+
+// Color profiles, from the least to the most capable.
+const (
+	ProfileNone      ColorProfile = iota + 1 // no color, eg TERM=dumb
+	Profile16                                // the 16 ANSI colors
+	Profile256                               // the 256 xterm colors
+	ProfileTrueColor                         // 24-bit RGB colors
+)
+
+// DetectColorProfile returns the color profile of the terminal, as
+// indicated by the TERM and COLORTERM environment variables. A COLORTERM
+// of "truecolor" or "24bit" indicates 24-bit color, and a TERM such as
+// "xterm-256color" indicates 256 colors. Windows Terminal, which does not
+// set TERM, supports 24-bit color. A TERM of "dumb" indicates no color.
+// Otherwise the terminal is assumed to support the 16 ANSI colors.
+func DetectColorProfile() ColorProfile {
+	term := os.Getenv("TERM")
+	switch colorterm := strings.ToLower(os.Getenv("COLORTERM")); {
+	case term == "dumb":
+		return ProfileNone
+	case colorterm == "truecolor" || colorterm == "24bit":
+		return ProfileTrueColor
+	case strings.HasSuffix(term, "-direct") || strings.Contains(term, "truecolor"):
+		return ProfileTrueColor
+	case strings.Contains(term, "256color"):
+		return Profile256
+	case term == "" && os.Getenv("WT_SESSION") != "":
+		return ProfileTrueColor
+	}
+	return Profile16
+}
+
+// convertEffect returns effect, which is a string of ANSI SGR parameters,
+// with any 256-color or 24-bit color parameters converted to the nearest
+// color that can be displayed with profile.
+func convertEffect(effect string, profile ColorProfile) string {
+	if profile >= ProfileTrueColor {
+		return effect
+	}
+	params := strings.Split(effect, ";")
+	out := make([]string, 0, len(params))
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		extended := p == "38" || p == "48"
+		var r, g, b int
+		switch {
+		case extended && i+2 < len(params) && params[i+1] == "5":
+			if profile == Profile256 {
+				out = append(out, params[i:i+3]...)
+				i += 2
+				continue
+			}
+			n, _ := strconv.Atoi(params[i+2])
+			r, g, b = xtermRGB(n)
+			i += 2
+		case extended && i+4 < len(params) && params[i+1] == "2":
+			r, _ = strconv.Atoi(params[i+2])
+			g, _ = strconv.Atoi(params[i+3])
+			b, _ = strconv.Atoi(params[i+4])
+			i += 4
+			if profile == Profile256 {
+				out = append(out, p, "5", strconv.Itoa(nearestXterm256(r, g, b)))
+				continue
+			}
+		default:
+			out = append(out, p)
+			continue
+		}
+		out = append(out, strconv.Itoa(ansi16Param(nearestANSI16(r, g, b), p == "48")))
+	}
+	return strings.Join(out, ";")
+}
+
+// ansi16Palette contains the RGB values of the 16 ANSI colors,
+// using the xterm defaults.
+var ansi16Palette = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the intensities of the 6x6x6 color
+// cube in the xterm 256-color palette.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// xtermRGB returns the RGB value of color n in the xterm 256-color palette.
+func xtermRGB(n int) (r, g, b int) {
+	switch {
+	case n < 0 || n > 255:
+		return 0, 0, 0
+	case n < 16:
+		c := ansi16Palette[n]
+		return c[0], c[1], c[2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	}
+	gray := 8 + (n-232)*10
+	return gray, gray, gray
+}
+
+// nearestXterm256 returns the color in the xterm 256-color palette,
+// excluding the 16 ANSI colors, that is nearest to r, g, b.
+func nearestXterm256(r, g, b int) int {
+	level := func(v int) int {
+		i := 0
+		for j := range cubeLevels {
+			if abs(cubeLevels[j]-v) < abs(cubeLevels[i]-v) {
+				i = j
+			}
+		}
+		return i
+	}
+	cube := 16 + 36*level(r) + 6*level(g) + level(b)
+	grayIndex := ((r+g+b)/3 - 3) / 10
+	if grayIndex < 0 {
+		grayIndex = 0
+	} else if grayIndex > 23 {
+		grayIndex = 23
+	}
+	gray := 232 + grayIndex
+	cr, cg, cb := xtermRGB(cube)
+	gr, gg, gb := xtermRGB(gray)
+	if distance(r, g, b, gr, gg, gb) < distance(r, g, b, cr, cg, cb) {
+		return gray
+	}
+	return cube
+}
+
+// nearestANSI16 returns the ANSI color, from 0 to 15,
+// that is nearest to r, g, b.
+func nearestANSI16(r, g, b int) int {
+	best := 0
+	for i, c := range ansi16Palette {
+		if distance(r, g, b, c[0], c[1], c[2]) < distance(r, g, b, ansi16Palette[best][0], ansi16Palette[best][1], ansi16Palette[best][2]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// ansi16Param returns the SGR parameter that selects ANSI color n
+// as the foreground color, or as the background color if bg is true.
+func ansi16Param(n int, bg bool) int {
+	base := 30
+	if n >= 8 {
+		base, n = 90, n-8
+	}
+	if bg {
+		base += 10
+	}
+	return base + n
+}
+
+// distance returns the square of the distance between two colors.
+func distance(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
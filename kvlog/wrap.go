@@ -0,0 +1,34 @@
+package kvlog
+
+import (
+	"bytes"
+
+	"github.com/jjeffery/kv/internal/terminal"
+)
+
+// Wrap returns text wrapped so that each line is no wider than width
+// columns, using the same rules as a writer printing to a terminal.
+// Lines are broken at white space, or after a comma or other punctuation
+// that ends a word too long to fit on a line. A word that is wider than
+// width is printed on a line of its own. Continuation lines start with
+// indent. White space between words is collapsed to a single space, and
+// newlines in text are preserved as line breaks. If width is zero or less,
+// lines are not wrapped.
+//
+// Columns are counted using the display width of each character, so
+// wide characters occupy two columns, and ANSI escape sequences occupy
+// none. The returned text does not end with a newline.
+func Wrap(text string, width int, indent string) string {
+	if width <= 0 {
+		width = noWrapWidth
+	}
+	p := &terminalPrinter{
+		nocolor:   true,
+		indentStr: indent,
+		buf:       &bytes.Buffer{},
+		indent:    terminal.StringWidth(indent),
+		bol:       true,
+	}
+	p.printText([]byte(text), width)
+	return p.buf.String()
+}
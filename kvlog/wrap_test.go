@@ -0,0 +1,166 @@
+package kvlog
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/jjeffery/kv"
+)
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		text   string
+		width  int
+		indent string
+		want   string
+	}{
+		{
+			text:  "the quick brown fox jumps over the lazy dog",
+			width: 15,
+			want:  "the quick brown\nfox jumps over\nthe lazy dog",
+		},
+		{
+			text:   "the quick brown fox jumps over the lazy dog",
+			width:  15,
+			indent: "  ",
+			want:   "the quick brown\n  fox jumps\n  over the lazy\n  dog",
+		},
+		{ // white space is collapsed, newlines are preserved
+			text:   "one  two\tthree\nfour five",
+			width:  80,
+			indent: "> ",
+			want:   "one two three\n> four five",
+		},
+		{ // punctuation after a long word stays on the same line
+			text:  "a,very,long,list,of,words,without,spaces",
+			width: 12,
+			want:  "a,very,long,\nlist,of,\nwords,\nwithout,\nspaces",
+		},
+		{ // a run of punctuation stays with the word
+			text:  "aaaa,) next",
+			width: 5,
+			want:  "aaaa,)\nnext",
+		},
+		{
+			text:  "aaaa,,bbbb",
+			width: 5,
+			want:  "aaaa,,\nbbbb",
+		},
+		{ // punctuation followed by more text
+			text:  "aaaa,.bbbb",
+			width: 5,
+			want:  "aaaa,.\nbbbb",
+		},
+		{
+			text:  "aaaa,.bbbb",
+			width: 20,
+			want:  "aaaa,.bbbb",
+		},
+		{ // punctuation ends at the line width
+			text:  "one two,). three",
+			width: 10,
+			want:  "one two,).\nthree",
+		},
+		{ // punctuation is one column past the line width
+			text:  "one two,). three",
+			width: 9,
+			want:  "one\ntwo,).\nthree",
+		},
+		{ // a long word is printed on its own line
+			text:  "short supercalifragilisticexpialidocious word",
+			width: 10,
+			want:  "short\nsupercalifragilisticexpialidocious\nword",
+		},
+		{ // wide characters occupy two columns
+			text:  "日本語 日本語 日本語",
+			width: 13,
+			want:  "日本語 日本語\n日本語",
+		},
+		{ // no wrapping
+			text: "the quick brown fox jumps over the lazy dog",
+			want: "the quick brown fox jumps over the lazy dog",
+		},
+		{
+			text:  "",
+			width: 10,
+			want:  "",
+		},
+	}
+
+	for tn, tt := range tests {
+		if got, want := Wrap(tt.text, tt.width, tt.indent), tt.want; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
+
+func TestListPretty(t *testing.T) {
+	// kv.List.Pretty must print the same as a writer printing to a terminal
+	lists := []kv.List{
+		{"a", 1, "b", "two words", "c", errors.New("failed")},
+		{"id", 1, "name", "alice", "email", "alice@example.com", "role", "admin", "path", "/a/b/c"},
+		{"k", "日本語", "k", "日本語", "k", "日本語"},
+	}
+	for tn, list := range lists {
+		for _, width := range []int{10, 20, 30, 80} {
+			// the writer leaves one column unused at the end of each line
+			c := NewCapture(width + 1)
+			c.MinWidth(0)
+			newLogWriter(c.Writer, log.New(ioutil.Discard, "", 0)).Write([]byte(list.String()))
+			if got, want := list.Pretty(width)+"\n", c.String(); got != want {
+				t.Errorf("%d: width=%d:\n got=%q\nwant=%q", tn, width, got, want)
+			}
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// TestNoTrailingSpace checks wrapped output against a golden file,
+// which must not contain any white space at the end of a line.
+func TestNoTrailingSpace(t *testing.T) {
+	inputs := []string{
+		"prog: 12:34:56 warning: supercalifragilisticexpialidocious a=1",
+		"prog: 12:34:56 text     with    runs   of    spaces   that wraps a=1",
+		"prog: 12:34:56 first line\n\n\tindented line\n",
+		"prog: 12:34:56 error:",
+		"prog: 12:34:56 info: a=1 bbbbbbbbbbbbbbbbbbbbbbbbbbbb=2 c=3",
+		"prog: 12:34:56 a/very/long/file/name/that/fills/the/line.go:123: message",
+	}
+	var got bytes.Buffer
+	for _, color := range []bool{false, true} {
+		for _, input := range inputs {
+			c := NewCapture(30)
+			if color {
+				c.ForceColor()
+			}
+			c.TabWidth(8)
+			newLogWriter(c.Writer, log.New(ioutil.Discard, "prog: ", log.Ltime)).Write([]byte(input))
+			got.WriteString(c.String())
+		}
+	}
+
+	golden := filepath.Join("testdata", "no_trailing_space.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("\n got=%q\nwant=%q", got.Bytes(), want)
+	}
+	trailing := regexp.MustCompile(`(?m)[ \t](\x1b\[[0-9;]*m)*$`)
+	if loc := trailing.FindIndex(want); loc != nil {
+		t.Errorf("trailing white space in golden file at offset %d", loc[0])
+	}
+}
@@ -2,15 +2,23 @@ package kvlog
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/jjeffery/kv"
+	"github.com/jjeffery/kv/internal/logfmt"
 	"github.com/jjeffery/kv/internal/parse"
 )
 
@@ -28,6 +36,37 @@ var (
 		"fatal":   "red",
 	}
 
+	// VerbosePrefixes is the default list of prefixes for messages that
+	// are only displayed when the writer is in verbose mode.
+	VerbosePrefixes = []string{"trace", "debug"}
+
+	// VerbosityLevels is the default verbosity required to display
+	// messages with each verbose prefix. See Writer.Verbosity. Messages
+	// with verbose prefixes that are not listed require a verbosity of one.
+	VerbosityLevels = map[string]int{
+		"debug": 1,
+		"trace": 2,
+	}
+
+	// ErrorPrefixes is the default list of prefixes for messages
+	// that are displayed using the theme's error effect.
+	ErrorPrefixes = []string{"error", "alert", "fatal"}
+
+	// WarningPrefixes is the default list of prefixes for messages
+	// that are displayed using the theme's warning effect.
+	WarningPrefixes = []string{"warning"}
+
+	// ErrClosed is returned when a message is written
+	// to a writer after its Close method has been called.
+	ErrClosed = errors.New("kvlog: writer closed")
+
+	// Discard is an output writer on which all writes succeed without
+	// doing anything. A writer created with NewWriter(Discard) does not
+	// parse or format messages unless they are also passed to handlers,
+	// tee sinks, syslog or OpenTelemetry, so logging can be disabled at
+	// close to zero cost. It is the same as ioutil.Discard.
+	Discard io.Writer = ioutil.Discard
+
 	// Std is the 'standard' writer, which can be attached to the
 	// 'standard' logger using the Attach() function.
 	Std = NewWriter(os.Stderr)
@@ -43,11 +82,15 @@ type logEntry struct {
 	Prefix    string    // Prefix from the logger
 	Date      []byte    // Date from the logger, format YYYY/MM/DD
 	Time      []byte    // Time from the logger, format HH:MM:SS[.999999]
+	Label     string    // Label of the writer (eg service name)
 	File      []byte    // File name and line number from the logger
 	Level     string    // Message level (eg "debug")
 	Effect    string    // Effect associated with level
+	ErrorText bool      // Message text is displayed with the level's effect
 	Text      []byte    // Message text
 	List      [][]byte  // Key/value pairs
+	Quoted    []bool    // Reports whether each item in List was quoted, or nil if not known
+	Null      []byte    // Value printed for nil if values are normalized, or nil
 }
 
 // Message is a structured representation of the text emitted by a standard library logger.
@@ -88,22 +131,110 @@ type levelInfo struct {
 // the output. The message is then formatted and printed to the output writer. If the
 // output writer is a terminal, it formats the message for improved readability.
 type Writer struct {
-	mutex        sync.Mutex          // controls exclusive access
-	printer      printer             // used for printing to the output writer
-	suppress     [][]byte            // levels that should be suppressed
-	suppressMap  map[string]struct{} // Levels that should be suppressed
-	display      []*levelInfo        // levels that should be displayed
-	levels       map[string]string   // copy of original level map
-	handlers     []Handler           // list of handlers to process unsuppressed messages
-	entryHandler func(*logEntry)     // for testing
+	mutex        sync.Mutex                     // controls exclusive access
+	out          io.Writer                      // output writer
+	logfmt       bool                           // print in logfmt format
+	gelf         bool                           // print in GELF format
+	gelfHost     string                         // host for GELF messages
+	opts         printerOptions                 // options for printing to terminals
+	printer      printer                        // used for printing to the output writer
+	suppress     [][]byte                       // levels that should be suppressed
+	suppressMap  map[string]struct{}            // Levels that should be suppressed
+	hidePrefixes [][]byte                       // prefixes of messages that are never displayed
+	display      []*levelInfo                   // levels that should be displayed
+	levels       map[string]string              // copy of original level map
+	handlers     []Handler                      // list of handlers to process unsuppressed messages
+	verbose      [][]byte                       // prefixes only displayed in verbose mode
+	errors       []string                       // levels displayed as errors
+	warnings     []string                       // levels displayed as warnings
+	verbosities  []int                          // verbosity required for each verbose prefix
+	quiet        bool                           // suppress messages with verbose prefixes
+	verbosity    int                            // verbosity when quiet
+	sample       int                            // print one in sample verbose messages
+	sampled      []int                          // count of messages for each verbose prefix
+	sortKeys     bool                           // sort key/value pairs by key
+	noSanitize   bool                           // print control characters unescaped
+	duplicates   DuplicateKeys                  // how to handle duplicate keys
+	redactKeys   map[string]struct{}            // lower case keys with values to redact
+	redactFunc   func(string) bool              // reports whether a key's value is redacted
+	maxValue     int                            // maximum value width in runes, or zero
+	errorKeys    [][]byte                       // keys with error values to expand
+	minLevel     int                            // rank of minimum level, or zero
+	levelKey     []byte                         // key for level in key/value pairs
+	dedup        *deduper                       // collapses repeated messages, or nil
+	joiner       *joiner                        // joins continuation lines, or nil
+	closed       bool                           // writer has been closed
+	sinks        []*Writer                      // writers that share each parsed message
+	entry        logEntry                       // re-used for each message
+	list         [][]byte                       // re-used for copying shared key/value pairs
+	quoted       []bool                         // re-used for the quoted flags of key/value pairs
+	timeFormat   string                         // layout for reformatting date and time
+	noTime       bool                           // do not print date and time
+	label        string                         // label printed with each message, or empty
+	null         []byte                         // value printed for nil if values are normalized, or nil
+	timeBuf      []byte                         // re-used for formatting date and time
+	aliases      []levelAlias                   // level aliases, longest first
+	aliasBuf     []byte                         // re-used for message text with an alias replaced
+	renderer     func(*Message) ([]byte, error) // formats messages, or nil
+	ctxFields    func(context.Context) kv.List  // key/value pairs from a context
+	headerRE     *regexp.Regexp                 // matches a custom header, or nil
+	syslog       syslogger                      // prints to syslog, or nil
+	otel         func(*OTelRecord)              // emits OpenTelemetry records, or nil
+	errOut       io.Writer                      // output for warnings and errors, or nil
+	raw          io.Writer                      // receives the unmodified input, or nil
+	errPrinter   printer                        // prints to errOut
+	rendering    *bytes.Buffer                  // receives messages printed during Render, or nil
+	entryHandler func(*logEntry)                // for testing
 }
 
 // NewWriter creates writer that logs messages to out. If the output writer is a terminal
-// device, the output will be formatted for improved readability.
+// device, the output will be formatted for improved readability, using the colors
+// of DefaultTheme.
+//
+// If out is another writer, or the output of a logger attached to another writer, for
+// example the result of calling log.Writer after Attach, messages are passed to the
+// other writer without being formatted, so that they are only formatted once.
+// If out is Discard, messages are not parsed unless they have handlers.
 func NewWriter(out io.Writer) *Writer {
 	w := &Writer{
-		printer: newPrinter(out),
+		out: out,
+		opts: printerOptions{
+			theme:    DefaultTheme(),
+			tabWidth: defaultTabWidth,
+		},
 	}
+	w.setPrinter()
+	return w
+}
+
+// NewSplitWriter creates a writer that prints warnings and errors to errOut,
+// and all other messages to out. For example, out could be os.Stdout and
+// errOut could be os.Stderr. A message is a warning or an error if it starts
+// with a warning or error prefix (see SetWarningPrefixes and SetErrorPrefixes),
+// or if the value of the level key is "warn" or higher (see LevelKey). Each
+// output writer is formatted for a terminal if it is a terminal device.
+func NewSplitWriter(out, errOut io.Writer) *Writer {
+	w := NewWriter(out)
+	w.mutex.Lock()
+	w.errOut = errOut
+	w.setPrinter()
+	w.mutex.Unlock()
+	return w
+}
+
+// Tee returns a writer that prints each message to all of the sinks.
+// Each message is parsed once, and the result is shared by the sinks,
+// which can have different options. For example, one sink can print to
+// a terminal while another prints in logfmt format to a file. Options
+// and handlers apply to each sink individually, and options set on
+// the returned writer are ignored.
+//
+// If a sink has been closed, messages are still printed to the other
+// sinks and the logger receives ErrClosed. Closing the returned writer
+// closes all of the sinks.
+func Tee(sinks ...*Writer) *Writer {
+	w := NewWriter(ioutil.Discard)
+	w.sinks = append([]*Writer(nil), sinks...)
 	return w
 }
 
@@ -124,13 +255,23 @@ func Suppress(levels ...string) {
 	Std.Suppress(levels...)
 }
 
+// SetVerbose sets whether the Std writer displays messages with verbose prefixes.
+func SetVerbose(verbose bool) {
+	Std.SetVerbose(verbose)
+}
+
+// Verbosity sets the verbosity of the Std writer. See Writer.Verbosity.
+func Verbosity(level int) {
+	Std.Verbosity(level)
+}
+
 // Levels returns a list of levels and their associated actions.
 func (w *Writer) Levels() map[string]string {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
 	if w.levels == nil {
-		w.setLevels(Levels)
+		w.setDefaultLevels()
 	}
 	levels := make(map[string]string)
 	for level, effect := range w.levels {
@@ -156,6 +297,106 @@ func (w *Writer) SetLevel(level string, effect string) {
 	w.SetLevels(levels)
 }
 
+// LevelAliases sets alternative names for levels, so that messages from
+// programs that use inconsistent level names, such as "err:", "ERROR:" and
+// "[error]", are treated the same. Each key in aliases is an alternative
+// name for the level it maps to. When a message starts with an alias, the
+// alias is replaced with the level before the message is displayed,
+// suppressed, or compared with the minimum level.
+//
+// Aliases are matched without regard to case, and must be followed by a
+// colon, in the same way as levels. An alias that ends with a closing
+// bracket, such as "[error]", can be followed by white space instead of
+// a colon. LevelAliases replaces any existing aliases. If aliases is empty,
+// messages are not changed.
+func (w *Writer) LevelAliases(aliases map[string]string) {
+	w.mutex.Lock()
+	w.aliases = w.aliases[:0]
+	for alias, level := range aliases {
+		if alias == "" || level == "" {
+			continue
+		}
+		w.aliases = append(w.aliases, levelAlias{
+			alias: []byte(alias),
+			level: []byte(level),
+		})
+	}
+	// longest first, so that "[err]" is matched before "["
+	sort.Slice(w.aliases, func(i, j int) bool {
+		a, b := w.aliases[i].alias, w.aliases[j].alias
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return bytes.Compare(a, b) < 0
+	})
+	w.mutex.Unlock()
+}
+
+// levelAlias is an alternative name for a level.
+type levelAlias struct {
+	alias []byte
+	level []byte
+}
+
+// replaceAlias returns msg with any level alias at the beginning replaced
+// with the level, and the number of bytes at the beginning of msg that were
+// replaced, which is zero if there is no alias. The writer's mutex must be
+// locked.
+func (w *Writer) replaceAlias(msg []byte) ([]byte, int) {
+	for _, a := range w.aliases {
+		if len(msg) < len(a.alias) || !bytes.EqualFold(msg[:len(a.alias)], a.alias) {
+			continue
+		}
+		rest := msg[len(a.alias):]
+		n := matchColon(rest)
+		if n == 0 {
+			if a.alias[len(a.alias)-1] != ']' || len(rest) > 0 && !isWhiteSpace(rest[0]) {
+				continue
+			}
+			n = scan(rest, isWhiteSpace)
+		}
+		w.aliasBuf = append(w.aliasBuf[:0], a.level...)
+		w.aliasBuf = append(w.aliasBuf, ": "...)
+		w.aliasBuf = append(w.aliasBuf, rest[n:]...)
+		return w.aliasBuf, len(msg) - len(rest) + n
+	}
+	return msg, 0
+}
+
+// replaceSharedAlias returns a copy of shared with the alias at the
+// beginning of its text replaced with level, or nil if its text does not
+// begin with the alias, in which case the message is parsed again.
+func replaceSharedAlias(shared *parse.Message, alias, level []byte) *parse.Message {
+	if !bytes.HasPrefix(shared.Text, alias) {
+		return nil
+	}
+	text := make([]byte, 0, len(level)+len(shared.Text)-len(alias))
+	text = append(text, level...)
+	text = append(text, shared.Text[len(alias):]...)
+	return &parse.Message{
+		Text:   text,
+		List:   shared.List,
+		Quoted: shared.Quoted,
+	}
+}
+
+// setDefaultLevels sets the levels to the default Levels. If the theme
+// is not the dark theme, which the default Levels are designed for, the
+// levels displayed as errors and warnings use the theme's effects.
+func (w *Writer) setDefaultLevels() {
+	if w.opts.theme == DarkTheme() {
+		w.setLevels(Levels)
+		return
+	}
+	levels := make(map[string]string, len(Levels))
+	for level, effect := range Levels {
+		levels[level] = effect
+	}
+	w.setDefaultPrefixes()
+	setThemeLevels(levels, w.errors, w.warnings, w.opts.theme)
+	w.setLevels(levels)
+}
+
 func (w *Writer) setLevels(levels map[string]string) {
 	w.suppress = nil
 	w.suppressMap = make(map[string]struct{})
@@ -183,6 +424,89 @@ func (w *Writer) setLevels(levels map[string]string) {
 	}
 }
 
+// SetErrorPrefixes sets the display effect of each prefix to the theme's
+// error effect, so that messages beginning with any of the prefixes are
+// displayed as errors. Prefixes are matched case-insensitively.
+func (w *Writer) SetErrorPrefixes(prefixes ...string) {
+	levels := w.Levels()
+	w.mutex.Lock()
+	w.setDefaultPrefixes()
+	w.errors = setLevelPrefixes(levels, w.errors, prefixes, w.opts.theme.Error)
+	w.setLevels(levels)
+	w.mutex.Unlock()
+}
+
+// SetWarningPrefixes sets the display effect of each prefix to the theme's
+// warning effect, so that messages beginning with any of the prefixes are
+// displayed as warnings. Prefixes are matched case-insensitively.
+func (w *Writer) SetWarningPrefixes(prefixes ...string) {
+	levels := w.Levels()
+	w.mutex.Lock()
+	w.setDefaultPrefixes()
+	w.warnings = setLevelPrefixes(levels, w.warnings, prefixes, w.opts.theme.Warning)
+	w.setLevels(levels)
+	w.mutex.Unlock()
+}
+
+// SetTheme sets the display effects used when printing to a terminal.
+// Levels displayed as errors and warnings are updated to use the
+// theme's error and warning effects.
+func (w *Writer) SetTheme(theme Theme) {
+	levels := w.Levels()
+	w.mutex.Lock()
+	w.setDefaultPrefixes()
+	w.opts.theme = theme
+	setThemeLevels(levels, w.errors, w.warnings, theme)
+	w.setLevels(levels)
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// setThemeLevels sets the effect of each error and
+// warning level in levels to the theme's effects.
+func setThemeLevels(levels map[string]string, errors, warnings []string, theme Theme) {
+	for _, level := range errors {
+		if _, ok := levels[level]; ok {
+			levels[level] = theme.Error
+		}
+	}
+	for _, level := range warnings {
+		if _, ok := levels[level]; ok {
+			levels[level] = theme.Warning
+		}
+	}
+}
+
+func (w *Writer) setDefaultPrefixes() {
+	if w.errors == nil {
+		w.errors = append([]string(nil), ErrorPrefixes...)
+	}
+	if w.warnings == nil {
+		w.warnings = append([]string(nil), WarningPrefixes...)
+	}
+}
+
+// setLevelPrefixes sets the effect of each prefix in the levels map, and
+// returns list with the prefixes appended as levels. Levels are matched
+// case-insensitively, so any existing level that differs only in case is replaced.
+func setLevelPrefixes(levels map[string]string, list []string, prefixes []string, effect string) []string {
+	for _, prefix := range prefixes {
+		level := strings.TrimSpace(prefix)
+		level = strings.TrimRight(level, ": ")
+		if level == "" {
+			continue
+		}
+		for existing := range levels {
+			if strings.EqualFold(existing, level) {
+				delete(levels, existing)
+			}
+		}
+		levels[level] = effect
+		list = append(list, level)
+	}
+	return list
+}
+
 // Suppress instructs the writer to suppress any message with the specified level.
 func (w *Writer) Suppress(levels ...string) {
 	p := w.Levels()
@@ -194,8 +518,163 @@ func (w *Writer) Suppress(levels ...string) {
 
 // IsSuppressed reports true if level should be suppressed.
 func (w *Writer) IsSuppressed(level string) bool {
-	_, ok := w.suppressMap[level]
-	return ok
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.setDefaults()
+	if _, ok := w.suppressMap[level]; ok {
+		return true
+	}
+	msg := []byte(level + ":")
+	return prefixIndex(w.hidePrefixes, msg) >= 0 || w.isQuiet(msg)
+}
+
+// SetSuppressPrefixes sets the list of prefixes for messages that are
+// never displayed, even when the writer is verbose. This allows one log
+// to carry messages for different audiences, for example "metric:" messages
+// that are collected by a handler or from the raw input (see Raw), and are
+// not meant to be read by people. Prefixes are matched in the same way as
+// verbose prefixes: case-insensitively at the beginning of the message text,
+// followed by a colon. Matching messages are dropped before the verbose
+// prefixes are checked, and they are not passed to handlers. Calling
+// SetSuppressPrefixes with no prefixes displays all messages again.
+//
+// Unlike Suppress, which hides messages with a known level, any prefix
+// can be suppressed, and it does not need to be one of the writer's levels.
+func (w *Writer) SetSuppressPrefixes(prefixes ...string) {
+	w.mutex.Lock()
+	w.hidePrefixes = trimPrefixes(prefixes)
+	w.mutex.Unlock()
+}
+
+// SetVerbose sets whether the writer displays messages with verbose
+// prefixes. Writers are verbose by default. It is safe to call SetVerbose
+// while messages are being written, for example to toggle verbosity when
+// the program receives a signal.
+func (w *Writer) SetVerbose(verbose bool) {
+	w.mutex.Lock()
+	w.quiet = !verbose
+	w.verbosity = 0
+	w.mutex.Unlock()
+}
+
+// Verbosity sets the writer to display messages with verbose prefixes
+// that require a verbosity of level or less, which allows for -v, -vv
+// style command line options. With the default verbose prefixes, a level
+// of one displays "debug:" messages, and a level of two also displays
+// "trace:" messages. A level of zero or less displays no messages with
+// verbose prefixes, which is the same as calling SetVerbose(false).
+//
+// The verbosity required for each verbose prefix is given by VerbosityLevels.
+// Calling SetVerbose(true) displays all messages, regardless of verbosity.
+func (w *Writer) Verbosity(level int) {
+	w.mutex.Lock()
+	w.quiet = true
+	w.verbosity = level
+	w.mutex.Unlock()
+}
+
+// SetVerbosePrefixes replaces the list of prefixes for messages that
+// are only displayed when the writer is verbose. Prefixes are matched
+// case-insensitively at the beginning of the message text, and must
+// be followed by a colon. If this method is not called, the writer
+// uses the prefixes in VerbosePrefixes.
+func (w *Writer) SetVerbosePrefixes(prefixes ...string) {
+	w.mutex.Lock()
+	w.setVerbosePrefixes(prefixes)
+	w.mutex.Unlock()
+}
+
+func (w *Writer) setVerbosePrefixes(prefixes []string) {
+	w.verbose = trimPrefixes(prefixes)
+	w.verbosities = make([]int, len(w.verbose))
+	w.sampled = nil
+	for i, prefix := range w.verbose {
+		w.verbosities[i] = verbosityLevel(string(prefix))
+	}
+}
+
+// trimPrefixes returns the prefixes without surrounding white space
+// or a trailing colon. Prefixes that are empty after trimming are omitted.
+func trimPrefixes(prefixes []string) [][]byte {
+	trimmed := make([][]byte, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSpace(prefix)
+		prefix = strings.TrimRight(prefix, ": ")
+		if prefix != "" {
+			trimmed = append(trimmed, []byte(prefix))
+		}
+	}
+	return trimmed
+}
+
+// verbosityLevel returns the verbosity required to display
+// messages with the verbose prefix.
+func verbosityLevel(prefix string) int {
+	for p, level := range VerbosityLevels {
+		if strings.EqualFold(p, prefix) {
+			return level
+		}
+	}
+	return 1
+}
+
+// isQuiet reports whether msg has a verbose prefix, and
+// is not displayed at the writer's current verbosity.
+func (w *Writer) isQuiet(msg []byte) bool {
+	if !w.quiet {
+		return false
+	}
+	i := w.verboseIndex(msg)
+	return i >= 0 && w.verbosities[i] > w.verbosity
+}
+
+// verboseIndex returns the index of the verbose prefix
+// of msg, or -1 if msg does not have a verbose prefix.
+func (w *Writer) verboseIndex(msg []byte) int {
+	return prefixIndex(w.verbose, msg)
+}
+
+// prefixIndex returns the index of the prefix that msg starts with,
+// followed by a colon, or -1 if msg does not start with any of the
+// prefixes. Prefixes are matched case-insensitively.
+func prefixIndex(prefixes [][]byte, msg []byte) int {
+	for i, prefix := range prefixes {
+		if len(msg) > len(prefix) && bytes.EqualFold(msg[:len(prefix)], prefix) {
+			if matchColon(msg[len(prefix):]) > 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Sample instructs the writer to print only one in every n messages
+// with a verbose prefix, such as "debug:". Messages are counted
+// separately for each verbose prefix, so that many messages with
+// one prefix do not prevent messages with another prefix from being
+// printed. The first message with each prefix is printed. Messages
+// without a verbose prefix, including errors and warnings, are not
+// sampled. If n is one or less, all messages are printed, which
+// is the default.
+func (w *Writer) Sample(n int) {
+	w.mutex.Lock()
+	w.sample = n
+	w.mutex.Unlock()
+}
+
+// skipSample reports whether msg should not be printed
+// because it has a verbose prefix and is not sampled.
+func (w *Writer) skipSample(msg []byte) bool {
+	i := w.verboseIndex(msg)
+	if i < 0 {
+		return false
+	}
+	if len(w.sampled) != len(w.verbose) {
+		w.sampled = make([]int, len(w.verbose))
+	}
+	count := w.sampled[i]
+	w.sampled[i] = (count + 1) % w.sample
+	return count != 0
 }
 
 // Handle registers a handler that will be called for every logging
@@ -233,145 +712,1555 @@ func (w *Writer) Attach(logger ...*log.Logger) {
 	}
 }
 
-// SetOutput sets the output destination for log messages.
+// SetOutput sets the output destination for log messages. If the output
+// is buffered by a BufferedWriter, any buffered messages are written to
+// the previous output writer, and messages continue to be buffered.
 func (w *Writer) SetOutput(out io.Writer) {
 	w.mutex.Lock()
-	w.printer = newPrinter(out)
+	if b, ok := w.out.(*lineBuffer); ok {
+		b.setOutput(out)
+	} else {
+		w.out = out
+	}
+	w.setPrinter()
 	w.mutex.Unlock()
 }
 
-func (w *Writer) shouldSuppress(msg []byte) bool {
-	for _, levelb := range w.suppress {
-		if bytes.HasPrefix(msg, levelb) {
-			if colonRE.Match(msg[len(levelb):]) {
-				return true
-			}
-		}
+// Raw instructs the writer to write the unmodified bytes of each message to
+// dst, as well as printing the message to the output writer. For messages
+// written by a logger, this includes the logger's prefix, date and time.
+// The bytes are written before the message is parsed, so messages that are
+// suppressed, below the minimum level, sampled or collapsed by Dedup are
+// still written to dst, which makes dst a complete record of the input.
+// Close flushes and closes dst in the same way as the output writer.
+//
+// If writing to dst fails, the message is still printed to the output
+// writer, and the error is returned to the logger. If dst is nil, the
+// unmodified bytes are not written, which is the default.
+func (w *Writer) Raw(dst io.Writer) {
+	w.mutex.Lock()
+	w.raw = dst
+	w.mutex.Unlock()
+}
+
+// writeRaw writes p to the raw writer, if there is one and the
+// writer is not closed. The writer's mutex must be locked.
+func (w *Writer) writeRaw(p []byte) error {
+	if w.raw == nil || w.closed {
+		return nil
 	}
-	return false
+	_, err := w.raw.Write(p)
+	return err
 }
 
-func (w *Writer) getLevel(msg []byte) (level string, effect string, skip int) {
-	for _, levelInfo := range w.display {
-		if len(msg) < len(levelInfo.levelb)+1 {
-			continue
+// Close flushes and closes the output writer. If the output writer has
+// a Flush method, it is called first, so that messages buffered in
+// (for example) a *bufio.Writer are not lost. If the output writer
+// implements io.Closer, it is then closed. After Close is called, any
+// message written to the writer is discarded and the logger receives
+// ErrClosed.
+//
+// Call Close during program shutdown to ensure that buffered log messages
+// are written. Note that if the output writer is os.Stderr, it will be closed.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.stopJoiner()
+	w.closed = true
+	if w.dedup != nil {
+		w.dedup.stop(w.handler)
+	}
+	var err error
+	for _, sink := range w.sinks {
+		if serr := sink.Close(); err == nil {
+			err = serr
 		}
-		if bytes.EqualFold(msg[:len(levelInfo.levelb)], levelInfo.levelb) {
-			// match the level but we need a following colon for a match
-			suffix := colonRE.Find(msg[len(levelInfo.levelb):])
-			if suffix != nil {
-				level = levelInfo.levelstr
-				effect = levelInfo.effect
-				skip = len(levelInfo.levelb) + len(suffix)
-				break
-			}
+	}
+	if cerr := closeOutput(w.out); err == nil {
+		err = cerr
+	}
+	if w.errOut != nil {
+		if cerr := closeOutput(w.errOut); err == nil {
+			err = cerr
 		}
 	}
-	return level, effect, skip
+	if w.raw != nil {
+		if cerr := closeOutput(w.raw); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
-func (w *Writer) handler(entry *logEntry) {
-	if w.entryHandler != nil {
-		w.entryHandler(entry)
+// closeOutput flushes and closes out, if it supports these operations.
+func closeOutput(out io.Writer) error {
+	var err error
+	if f, ok := out.(interface{ Flush() error }); ok {
+		err = f.Flush()
 	}
-	if w.handlers != nil {
-		var msg *Message
-		for _, h := range w.handlers {
-			if h.Handles(entry.Prefix, entry.Level) {
-				if msg == nil {
-					msg = &Message{
-						Timestamp: entry.Timestamp,
-						Prefix:    entry.Prefix,
-						Level:     entry.Level,
-						Text:      string(entry.Text),
-					}
-					if entry.File != nil {
-						msg.File = string(entry.File)
-					}
-					if len(entry.List) > 0 {
-						msg.List = make(kv.List, len(entry.List))
-						for i, v := range entry.List {
-							msg.List[i] = string(v)
-						}
-					}
-				}
-				h.Handle(msg)
-			}
+	if c, ok := out.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
 		}
 	}
-	w.printer.Print(entry)
+	return err
 }
 
-// logWriter is a writer tailored for a specific logger.
-type logWriter struct {
-	prefixb []byte         // logger prefix bytes
-	prefixs string         // logger prefix string
-	utc     bool           // is time in UTC
-	dateRE  *regexp.Regexp // regexp for extracting date
-	timeRE  *regexp.Regexp // regexp for extracting time
-	fileRE  *regexp.Regexp // regexp for extracting file (???:0 D:/go/src/github.com/jjeffery/kv/kv.go:123)
-	output  *Writer
-	logger  *log.Logger
-	changed bool
+// Dedup instructs the writer to collapse identical messages written
+// within window of each other. The first message is printed, and any
+// identical messages that follow within the window are discarded. After
+// the window closes, the most recent of the discarded messages is printed
+// with an additional "repeated" key/value pair containing the number of
+// messages discarded. Messages are identical if they have the same logger
+// prefix, level, message text and key/value pairs: the date and time are
+// ignored.
+//
+// The summary message is printed when the window closes, even if no
+// more messages are written, or when the writer is closed. If window is
+// zero or less, messages are not collapsed, which is the default.
+func (w *Writer) Dedup(window time.Duration) {
+	w.mutex.Lock()
+	if w.dedup != nil {
+		w.dedup.stop(w.handler)
+		w.dedup = nil
+	}
+	if window > 0 {
+		w.dedup = newDeduper(window, w.dedupTimeout)
+	}
+	w.mutex.Unlock()
 }
 
-var (
-	dateRE  = regexp.MustCompile(`^\d{4}/\d\d/\d\d`)
-	timeRE  = regexp.MustCompile(`^\d\d:\d\d:\d\d(\.\d+)?`)
-	fileRE  = regexp.MustCompile(`^([a-zA-Z]:)?[^:]+:\d+`)
-	colonRE = regexp.MustCompile(`^\s*:\s*`)
-)
+// dedupTimeout is called by the deduper's timer when the window
+// of the oldest message closes. It prints a summary for each message
+// whose window has closed, and starts the timer for the next window.
+func (w *Writer) dedupTimeout() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	d := w.dedup
+	if d == nil || w.closed {
+		return
+	}
+	d.expire(time.Now(), w.handler)
+	d.schedule()
+}
 
-func newLogWriter(output *Writer, logger *log.Logger) *logWriter {
-	w := &logWriter{
-		output: output,
-		logger: logger,
+// JoinLines instructs the writer to append continuation lines to the
+// message before them, so that a multi-line message such as a stack trace
+// is printed as a single message, instead of each line being printed with
+// its own header and indent. A line is a continuation if it starts with
+// white space, or if the logger prints a header (prefix, date, time or
+// file) and the line does not have one, such as lines written directly to
+// the logger's writer. Continuation lines are printed as hard line breaks
+// in the message text.
+//
+// Each message is held until a line that is not a continuation is written,
+// until wait has elapsed without a continuation line, or until the writer
+// is closed. If wait is zero or less, continuation lines are printed as
+// separate messages, which is the default.
+func (w *Writer) JoinLines(wait time.Duration) {
+	w.mutex.Lock()
+	w.stopJoiner()
+	if wait > 0 {
+		w.joiner = &joiner{wait: wait}
 	}
-	w.setup()
-	return w
+	w.mutex.Unlock()
 }
 
-func isspace(ch rune) bool {
-	return ch == ' ' || ch == '\t'
+// SetRenderer sets a function that formats each message for printing,
+// which replaces the formatting done by the writer. The writer still
+// removes the logger's header, applies levels and suppression, and
+// prepares the key/value pairs before calling fn. The bytes returned
+// by fn are written to the output writer unchanged, so they should
+// include any line terminator. If fn returns an error, the message is
+// formatted by the writer as usual. If fn is nil, the writer's own
+// formatting is restored.
+func (w *Writer) SetRenderer(fn func(msg *Message) ([]byte, error)) {
+	w.mutex.Lock()
+	w.renderer = fn
+	w.setPrinter()
+	w.mutex.Unlock()
 }
 
-func (w *logWriter) setup() {
-	var (
-		prefix string
-		flags  int
-	)
-	if w.logger == nil {
-		prefix = log.Prefix()
-		flags = log.Flags()
+// SetHeaderRegexp sets a regular expression that matches a header at the
+// beginning of each message, for messages from programs and libraries that
+// do not use the header format of the standard library logger. For example,
+// `^\[[^\]]*\] ` matches a bracketed timestamp such as
+// "[2024-01-02T15:04:05Z] ". The regular expression must be anchored with ^.
+//
+// The text matched by the regular expression is removed from the message
+// and printed as the prefix, after the logger's own header has been
+// removed. Messages that do not match are printed unchanged. If re is nil,
+// no custom header is matched, which is the default.
+func (w *Writer) SetHeaderRegexp(re *regexp.Regexp) {
+	w.mutex.Lock()
+	w.headerRE = re
+	w.mutex.Unlock()
+}
+
+// WriteContext prints the message text p, which can contain key/value
+// pairs, with key/value pairs from ctx appended. The key/value pairs are
+// obtained from the function passed to ContextFields, or if ContextFields
+// has not been called, they are the key/value pairs attached to ctx using
+// kv.From(ctx).With(...). Unlike messages written by a logger, p does not
+// start with a prefix, date or time.
+//
+// Messages written by a logger attached to the writer do not have key/value
+// pairs added from a context.
+func (w *Writer) WriteContext(ctx context.Context, p []byte) (int, error) {
+	w.mutex.Lock()
+	fn := w.ctxFields
+	w.mutex.Unlock()
+
+	var list kv.List
+	if fn != nil {
+		list = kv.With(fn(ctx)...)
 	} else {
-		prefix = w.logger.Prefix()
-		flags = w.logger.Flags()
+		list = kv.FromContext(ctx)
 	}
-	if prefix != "" {
-		w.prefixs = prefix
-		w.prefixb = []byte(prefix)
+	hdr := &logEntry{Timestamp: time.Now()}
+	if len(list) > 0 {
+		// the key/value pairs appear the same as if they were in the message
+		hdr.List = make([][]byte, 0, len(list))
+		for i := 0; i < len(list); i += 2 {
+			hdr.List = logfmt.AppendKeyValue(hdr.List, list[i], list[i+1])
+		}
 	}
-	if flags&log.Ldate != 0 {
-		w.dateRE = dateRE
+
+	w.mutex.Lock()
+	rawErr := w.writeRaw(p)
+	err := w.writeEntry(hdr, p, nil)
+	if err == nil {
+		err = rawErr
 	}
-	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
-		w.timeRE = timeRE
+	w.mutex.Unlock()
+	if err != nil {
+		return 0, err
 	}
-	if flags&(log.Lshortfile|log.Llongfile) != 0 {
-		w.fileRE = fileRE
+	return len(p), nil
+}
+
+// Write implements the io.Writer interface. It prints the message text p,
+// which has the same format as a message printed by a logger without a
+// prefix or flags. The message is formatted in the same way as Render,
+// and is also passed to any handlers. Write returns ErrClosed if the writer
+// is closed.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	if w.discards() {
+		closed := w.closed
+		w.mutex.Unlock()
+		if closed {
+			return 0, ErrClosed
+		}
+		return len(p), nil
 	}
-	if flags&log.LUTC != 0 {
-		w.utc = true
+	rawErr := w.writeRaw(p)
+	err := w.writeEntry(&logEntry{Timestamp: time.Now()}, p, nil)
+	if err == nil {
+		err = rawErr
 	}
+	w.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-// Write implements the io.Writer interface. This method is
-// called from the logger. Because the logger's mutex is
-// locked, and because we want to read the logger's prefix and
-// its flags, we copy the details and let the actual logging
-// be done by a goroutine.
+// Render returns the bytes that Write would print for the message text p,
+// without printing them. The message passes through the same steps as it
+// does for Write, using the writer's current options, and only the final
+// step differs: instead of being printed, the message is formatted into
+// the returned bytes, including line wrapping and color if the output
+// writer is a terminal. If the writer prints to syslog, Render returns the
+// text that would be sent to syslog, and if the writer emits OpenTelemetry
+// records, Render returns the text and key/value pairs on a single line.
+// Messages for the sinks of a Tee writer, and for a writer that this
+// writer prints to, are rendered by those writers, one after the other.
+//
+// Because the message is processed in the same way as by Write, it counts
+// towards sampling (see Sample) and it is remembered by Dedup, as if it had
+// been written. If the message would not be printed, because it is
+// suppressed, sampled, collapsed by Dedup or below the minimum level,
+// Render returns nil. Render does not call handlers, and it does not
+// write to the Raw writer. It returns ErrClosed if the writer is closed.
+func (w *Writer) Render(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w.mutex.Lock()
+	w.rendering = &buf
+	err := w.writeEntry(&logEntry{Timestamp: time.Now()}, p, nil)
+	w.rendering = nil
+	w.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// renderEntry formats entry into the writer's render buffer in the same
+// way as it would be printed. The writer's mutex must be locked.
+func (w *Writer) renderEntry(entry *logEntry) {
+	if w.syslog != nil || w.otel != nil {
+		// syslog and OpenTelemetry record the time separately
+		ent := *entry
+		ent.Date, ent.Time = nil, nil
+		(&simplePrinter{w: w.rendering}).Print(&ent)
+		return
+	}
+	out := w.out
+	if w.errOut != nil && w.isErrorOrWarning(entry) {
+		out = w.errOut
+	}
+	w.outputPrinter(&renderBuffer{Buffer: w.rendering, out: out}).Print(entry)
+}
+
+// renderBuffer accumulates the output of a printer used by Render.
+// It unwraps to the output writer, so that the message is formatted
+// as it would be for the output writer, eg wrapped to the width of
+// the terminal.
+type renderBuffer struct {
+	*bytes.Buffer
+	out io.Writer
+}
+
+// nextWriter returns the writer that out passes messages to,
+// or nil if out is not a kvlog writer.
+func nextWriter(out io.Writer) *Writer {
+	switch next := out.(type) {
+	case *logWriter:
+		return next.output
+	case *Writer:
+		return next
+	case *renderBuffer:
+		return nextWriter(next.out)
+	}
+	return nil
+}
+
+func (b *renderBuffer) Unwrap() io.Writer {
+	return b.out
+}
+
+// ContextFields sets a function that returns key/value pairs from a
+// context, such as trace and span IDs. The key/value pairs are added to
+// messages written using WriteContext.
+func (w *Writer) ContextFields(fn func(ctx context.Context) kv.List) {
+	w.mutex.Lock()
+	w.ctxFields = fn
+	w.mutex.Unlock()
+}
+
+// Logfmt instructs the writer to print each message on a single line
+// in logfmt format, even if the output writer is a terminal. The message
+// text is printed with the key "msg", the date and time from the
+// logger are printed with the key "ts", and any file name and line
+// number are printed with the key "caller". The output can be parsed
+// with the kv.Parse function.
+func (w *Writer) Logfmt() {
+	w.mutex.Lock()
+	w.logfmt = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// SortKeys sets whether key/value pairs are sorted by key before the
+// message is printed and passed to any handlers. The sort is stable,
+// so pairs with duplicate keys remain in their original order.
+func (w *Writer) SortKeys(sort bool) {
+	w.mutex.Lock()
+	w.sortKeys = sort
+	w.mutex.Unlock()
+}
+
+// SanitizeControl sets whether control characters in the message text
+// and values are replaced with an escape sequence, such as \x07 for the
+// bell character, before the message is printed and passed to any handlers.
+// This prevents text from an untrusted source from moving the cursor or
+// otherwise corrupting a terminal. Tabs and newlines are not replaced.
+// Control characters are sanitized by default.
+func (w *Writer) SanitizeControl(sanitize bool) {
+	w.mutex.Lock()
+	w.noSanitize = !sanitize
+	w.mutex.Unlock()
+}
+
+// DuplicateKeys determines how key/value pairs with the same key
+// are handled. See Writer.MergeDuplicateKeys.
+type DuplicateKeys int
+
+const (
+	// KeepDuplicateKeys prints all key/value pairs, even if
+	// the same key appears more than once. This is the default.
+	KeepDuplicateKeys DuplicateKeys = iota
+
+	// LastKeyWins prints a single pair for each key, with the
+	// value of the last pair with that key.
+	LastKeyWins
+
+	// FirstKeyWins prints a single pair for each key, with the
+	// value of the first pair with that key.
+	FirstKeyWins
+)
+
+// MergeDuplicateKeys sets how key/value pairs with the same key are handled,
+// which can happen when key/value pairs are merged from more than one source,
+// such as a context and the message. Unless policy is KeepDuplicateKeys,
+// the pairs for each key are collapsed into a single pair at the position
+// where the key first appears. Keys are compared case-sensitively. Duplicate
+// keys are merged before the message is printed and passed to any handlers.
+func (w *Writer) MergeDuplicateKeys(policy DuplicateKeys) {
+	w.mutex.Lock()
+	w.duplicates = policy
+	w.mutex.Unlock()
+}
+
+// Redact instructs the writer to replace the value of any key/value pair
+// with a key matching one of keys with "****". Keys are matched
+// case-insensitively. Values are redacted before the message is printed
+// and before it is passed to any handlers.
+func (w *Writer) Redact(keys ...string) {
+	w.mutex.Lock()
+	if w.redactKeys == nil {
+		w.redactKeys = make(map[string]struct{})
+	}
+	for _, key := range keys {
+		w.redactKeys[strings.ToLower(key)] = struct{}{}
+	}
+	w.mutex.Unlock()
+}
+
+// RedactFunc instructs the writer to replace the value of any key/value
+// pair with "****" if fn reports true for its key. This is useful for
+// redacting keys that match a pattern, such as any key ending in "_secret".
+func (w *Writer) RedactFunc(fn func(key string) bool) {
+	w.mutex.Lock()
+	w.redactFunc = fn
+	w.mutex.Unlock()
+}
+
+// ExpandErrors instructs the writer to expand the value of any key/value
+// pair with a key matching one of keys, if the value contains key/value
+// pairs of its own. This is the case for errors created by the kv package,
+// which print their key/value pairs after the error text, merged with the
+// pairs of any errors they wrap (see the Keyvals method of kv.Error). The
+// value is replaced with the error text, and the error's key/value pairs
+// follow it in the message. This avoids the error's key/value pairs being
+// printed inside a quoted value. Keys are matched case-insensitively. If no
+// keys are specified, the keys "err" and "error" are used.
+//
+// Error values are expanded before any values are redacted, so
+// redaction applies to the error's key/value pairs.
+func (w *Writer) ExpandErrors(keys ...string) {
+	if len(keys) == 0 {
+		keys = []string{"err", "error"}
+	}
+	w.mutex.Lock()
+	for _, key := range keys {
+		w.errorKeys = append(w.errorKeys, []byte(key))
+	}
+	w.mutex.Unlock()
+}
+
+// MaxValueWidth sets the maximum width of values in runes. Any value
+// longer than n runes is truncated to n-1 runes followed by an ellipsis.
+// Message text is not truncated. If n is zero or less, values are
+// not truncated, which is the default.
+func (w *Writer) MaxValueWidth(n int) {
+	w.mutex.Lock()
+	w.maxValue = n
+	w.mutex.Unlock()
+}
+
+// MinLevel instructs the writer to suppress any message with a level
+// that ranks below level. Levels rank in the order trace, debug, info,
+// warn (or warning), error. The level of a message is the value of the
+// level key in its key/value pairs (see LevelKey). If there is no level
+// key, the level at the beginning of the message text is used. Messages
+// without a known level are not suppressed. If level is empty or unknown,
+// messages are not filtered by level, which is the default.
+func (w *Writer) MinLevel(level string) {
+	w.mutex.Lock()
+	w.minLevel = levelRank([]byte(level))
+	w.mutex.Unlock()
+}
+
+// LevelKey sets the key whose value is used as the message level by
+// MinLevel, and by writers that print to syslog or GELF, or emit
+// OpenTelemetry records. Keys are matched case-insensitively. The
+// default level key is "level".
+func (w *Writer) LevelKey(key string) {
+	w.mutex.Lock()
+	w.levelKey = []byte(key)
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// NoColor instructs the writer not to display color, even if the
+// output writer is a terminal.
+func (w *Writer) NoColor() {
+	w.mutex.Lock()
+	w.opts.color = colorNever
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// ForceColor instructs the writer to display color when the output
+// writer is a terminal, even if the NO_COLOR environment variable is set.
+//
+// By default color is displayed on terminals unless the NO_COLOR
+// environment variable is set to a non-empty value. See https://no-color.org.
+func (w *Writer) ForceColor() {
+	w.mutex.Lock()
+	w.opts.color = colorAlways
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// SetColorProfile sets the colors that the terminal can display. Effects in
+// the theme or set for a level that use 256-color or 24-bit color parameters,
+// such as "38;5;208" or "38;2;255;135;0", are converted to the nearest color
+// in the profile. If the profile is ProfileNone, color is not displayed unless
+// forced with ForceColor, in which case only the 16 ANSI colors are used.
+// By default the profile is determined by DetectColorProfile.
+func (w *Writer) SetColorProfile(profile ColorProfile) {
+	w.mutex.Lock()
+	w.opts.profile = profile
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// TabWidth sets the distance between tab stops when printing to a
+// terminal. Tabs in the message text are expanded to spaces up to the
+// next tab stop. If n is zero, each tab is treated like any other white
+// space, and collapsed to a single space. The default tab width is 8.
+func (w *Writer) TabWidth(n int) {
+	w.mutex.Lock()
+	w.opts.tabWidth = n
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// NoWrap instructs the writer to print each message on a single line
+// without color, even if the output writer is a terminal. This is the
+// format used when the output writer is not a terminal, and is more
+// efficient than formatting for a terminal.
+func (w *Writer) NoWrap() {
+	w.mutex.Lock()
+	w.opts.noWrap = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// Unlimited instructs the writer never to wrap or truncate messages when the
+// output writer is a terminal. Each message is printed on a single line,
+// except for any line breaks in the message text, which is useful when the
+// output is viewed with horizontal scrolling. Unlike NoWrap, color is still
+// displayed. The MinWidth and WidthPadding options have no effect.
+func (w *Writer) Unlimited() {
+	w.mutex.Lock()
+	w.opts.unlimited = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// NoTime instructs the writer not to print the date and time from the
+// logger. This is useful when the output is captured by a program that
+// adds its own timestamp to each line, such as journald or docker. Any
+// logger prefix and file name and line number are still printed, and
+// the date and time are still passed to handlers in the message timestamp.
+func (w *Writer) NoTime() {
+	w.mutex.Lock()
+	w.noTime = true
+	w.mutex.Unlock()
+}
+
+// TimeFormat instructs the writer to reformat the date and time printed
+// by the logger using layout, which is a layout for the time.Time Format
+// method, such as time.RFC3339. If the logger only prints the time, the
+// date is taken from the time that the message was written. If the date
+// and time cannot be parsed, they are printed unchanged. If layout is
+// empty, the date and time are printed as received from the logger,
+// which is the default.
+func (w *Writer) TimeFormat(layout string) {
+	w.mutex.Lock()
+	w.timeFormat = layout
+	w.mutex.Unlock()
+}
+
+// NormalizeValues instructs the writer to print nil and boolean values in
+// the same way regardless of how they were written, so that queries that
+// filter on them, such as done=true, are predictable. Values that represent
+// nil, which are null, nil and <nil> in any case, are printed as token, or
+// as null if token is empty. Boolean values in any case, such as True or
+// FALSE, are printed as true or false. Handlers and renderers receive nil
+// and boolean values with their own type instead of strings, so that
+// Message.MarshalJSON writes them as JSON null, true and false.
+func (w *Writer) NormalizeValues(token string) {
+	if token == "" {
+		token = "null"
+	}
+	w.mutex.Lock()
+	w.null = []byte(token)
+	w.mutex.Unlock()
+}
+
+// normalizeValue returns v, or the normalized value if v
+// represents nil or a boolean. See NormalizeValues.
+func (w *Writer) normalizeValue(v []byte) []byte {
+	switch {
+	case len(v) > 5:
+		return v
+	case bytes.EqualFold(v, valueNull), bytes.EqualFold(v, valueNil), bytes.EqualFold(v, valueNilFmt):
+		return w.null
+	case bytes.EqualFold(v, valueTrue):
+		return valueTrue
+	case bytes.EqualFold(v, valueFalse):
+		return valueFalse
+	}
+	return v
+}
+
+// values recognized by NormalizeValues
+var (
+	valueNull   = []byte("null")
+	valueNil    = []byte("nil")
+	valueNilFmt = []byte("<nil>")
+	valueTrue   = []byte("true")
+	valueFalse  = []byte("false")
+)
+
+// Label sets a label that identifies the messages printed by the writer,
+// such as the name of a service, which is useful when the output of several
+// programs is combined. The label is printed in square brackets after the
+// date and time, and continuation lines of wrapped messages are indented to
+// line up with the text after the label. In logfmt and other structured
+// formats, and for handlers, the label is the value of the "service" key.
+// If s is empty, no label is printed, which is the default.
+func (w *Writer) Label(s string) {
+	w.mutex.Lock()
+	w.label = s
+	w.mutex.Unlock()
+}
+
+// Indent sets the string printed at the beginning of each continuation
+// line when a message is wrapped on a terminal. By default continuation
+// lines are indented to line up with the text following the date and
+// time, or by four spaces if there is no date or time. The string can
+// include non-space characters, for example "  | ", to mark wrapped
+// lines. If s is empty, the default indent is used.
+func (w *Writer) Indent(s string) {
+	w.mutex.Lock()
+	w.opts.indent = s
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// MaxLineBytes sets the maximum number of bytes printed for a message,
+// including any line breaks added when wrapping on a terminal. A longer
+// message is truncated, and ends with a marker to show that it has been
+// truncated. This limits the damage done by very large values, which can
+// otherwise overwhelm programs that read the output one line at a time.
+// If n is zero or less, messages are not truncated, which is the default.
+//
+// See also MaxValueWidth, which limits the width of individual values.
+func (w *Writer) MaxLineBytes(n int) {
+	w.mutex.Lock()
+	w.opts.maxLine = n
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// MaxLines sets the maximum number of continuation lines printed for a
+// message that wraps over several lines on a terminal. If a message needs
+// more than n continuation lines, the remaining lines are replaced with a
+// single line that shows how many lines were not printed. This bounds the
+// damage done by a very large value, such as a JSON document, without
+// changing how other messages are printed. If n is zero or less, the number
+// of lines is not limited, which is the default. This only applies when
+// printing to a terminal.
+//
+// See also MaxLineBytes, which limits the number of bytes in a message.
+func (w *Writer) MaxLines(n int) {
+	w.mutex.Lock()
+	w.opts.maxLines = n
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// Highlight instructs the writer to highlight any text in the message
+// text or values that matches re, using inverse video. This only applies
+// when the output writer is a terminal that displays color. Because the
+// message text is wrapped one word at a time, a match cannot span the
+// white space between words. If re is nil, no text is highlighted.
+func (w *Writer) Highlight(re *regexp.Regexp) {
+	w.mutex.Lock()
+	w.opts.highlight = re
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// CRLF instructs the writer to terminate each line of output with a
+// carriage return and line feed, instead of a single line feed. This is
+// useful when the output is processed by programs that expect Windows
+// line endings.
+func (w *Writer) CRLF() {
+	w.mutex.Lock()
+	w.opts.crlf = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// AlignKeys instructs the writer to print each key/value pair on its
+// own line, with the equals signs aligned, whenever the key/value pairs
+// do not fit on the line with the message text. This only applies
+// when the output writer is a terminal.
+func (w *Writer) AlignKeys() {
+	w.mutex.Lock()
+	w.opts.alignKeys = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// KeyvalsFirst instructs the writer to print the key/value pairs before
+// the message text, so that they appear in the leftmost columns. The pairs
+// follow the logger prefix, date, time, file and level, and are separated
+// from the message text by " | ", unless another separator is set using
+// KeyvalSeparator. When the output writer is a terminal, the message text
+// wraps in the space remaining after the key/value pairs, and AlignKeys
+// has no effect.
+func (w *Writer) KeyvalsFirst() {
+	w.mutex.Lock()
+	w.opts.kvFirst = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// KeyvalSeparator sets the separator printed between the message text
+// and the key/value pairs, such as " | " or " » ", which can make it
+// easier to distinguish the text from the structured data. The separator
+// is only printed when a message has both text and key/value pairs. When
+// the output writer is a terminal, the separator is wrapped along with
+// the message text, white space in the separator is collapsed, and the
+// key/value pairs always follow a single space. If s is empty, the default
+// separator is restored, which is a single space, or " | " if the key/value
+// pairs are printed first (see KeyvalsFirst).
+func (w *Writer) KeyvalSeparator(s string) {
+	w.mutex.Lock()
+	w.opts.kvSep = s
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// KeyvalColumn sets the column, counting from zero at the start of the line,
+// where the key/value pairs start when the output writer is a terminal. If
+// the message text is shorter, it is padded with spaces, so that the pairs
+// of consecutive messages line up. If the text reaches column n, the pairs
+// start on the next line at the indent. Messages without text, and messages
+// whose key/value pairs are printed first or aligned, are not affected. If n
+// is zero or less, the key/value pairs follow the text, which is the default.
+func (w *Writer) KeyvalColumn(n int) {
+	if n < 0 {
+		n = 0
+	}
+	w.mutex.Lock()
+	w.opts.kvColumn = n
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// ColorWholeErrorLine instructs the writer to display the whole message
+// text of an error using the theme's error effect, instead of only the
+// error prefix, so that errors stand out in a busy log. A message is an
+// error if it starts with an error prefix (see SetErrorPrefixes). Key/value
+// pairs are displayed as usual. This only applies when the output writer
+// is a terminal.
+func (w *Writer) ColorWholeErrorLine() {
+	w.mutex.Lock()
+	w.opts.errorText = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// ExpandJSON instructs the writer to print values that are JSON objects
+// or arrays indented on continuation lines, which makes them easier to
+// read than a single escaped string. Other values are printed as usual.
+// This only applies when the output writer is a terminal.
+func (w *Writer) ExpandJSON() {
+	w.mutex.Lock()
+	w.opts.expandJSON = true
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// DefaultWidth sets the width used for wrapping messages when the output
+// writer is a terminal, but the size of the terminal cannot be determined.
+// If n is zero or less, messages are not wrapped in this case. The size of
+// the terminal is always used when it is available, followed by the value of
+// the COLUMNS environment variable if it is set. The default width is 120.
+func (w *Writer) DefaultWidth(n int) {
+	if n <= 0 {
+		n = -1
+	}
+	w.mutex.Lock()
+	w.opts.fallback = n
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// WidthPadding sets the number of columns left unused at the end of each
+// line when wrapping messages for a terminal. The default is one column,
+// because some terminals, such as git bash on Windows, print an extra line
+// feed when text is printed in the last column. Terminals that do not have
+// this problem, such as Windows Terminal, can use the full width by setting
+// n to zero.
+func (w *Writer) WidthPadding(n int) {
+	if n <= 0 {
+		n = -1
+	}
+	w.mutex.Lock()
+	w.opts.padding = n
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// MinWidth sets the minimum width of a terminal for wrapping messages. On a
+// narrower terminal, wrapping leaves so little room on each line that the
+// output is hard to read, so messages are not wrapped. Instead each line,
+// including lines separated by hard line breaks in the message, is truncated
+// to n-1 columns followed by an ellipsis. If n is zero or less, messages are
+// always wrapped. The default minimum width is 20.
+func (w *Writer) MinWidth(n int) {
+	if n <= 0 {
+		n = -1
+	}
+	w.mutex.Lock()
+	w.opts.minWidth = n
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// CacheWidth instructs the writer to cache the width of the terminal
+// for duration d, instead of querying the terminal size for every message.
+// If d is zero or less, the width is not cached, which is the default.
+func (w *Writer) CacheWidth(d time.Duration) {
+	w.mutex.Lock()
+	w.opts.widthCache = d
+	w.setPrinter()
+	w.mutex.Unlock()
+}
+
+// discards reports whether messages written to w have no effect, because
+// the output writer is Discard and nothing else receives the messages.
+// The writer's mutex must be locked.
+func (w *Writer) discards() bool {
+	return w.out == Discard &&
+		(w.errOut == nil || w.errOut == Discard) &&
+		w.raw == nil &&
+		len(w.handlers) == 0 &&
+		len(w.sinks) == 0 &&
+		w.syslog == nil &&
+		w.otel == nil &&
+		w.entryHandler == nil
+}
+
+func (w *Writer) setPrinter() {
+	w.printer = w.outputPrinter(w.out)
+	if w.errOut != nil {
+		w.errPrinter = w.outputPrinter(w.errOut)
+	}
+}
+
+// outputPrinter returns a printer that prints to out
+// using the writer's current options.
+func (w *Writer) outputPrinter(out io.Writer) printer {
+	if next := nextWriter(out); next != nil {
+		// Output to another kvlog writer, such as when a library creates
+		// a writer for the output of the standard logger. Formatting the
+		// message here would result in it being parsed and formatted twice.
+		// When rendering, the other writer renders the message.
+		p := &chainPrinter{next: next}
+		if b, ok := out.(*renderBuffer); ok {
+			p.render = b.Buffer
+		}
+		return p
+	}
+	var p printer
+	if w.syslog != nil {
+		p = &syslogPrinter{w: w.syslog, levelKey: w.levelKey}
+	} else if w.otel != nil {
+		p = &otelPrinter{emit: w.otel, levelKey: w.levelKey}
+	} else if w.gelf {
+		p = newGELFPrinter(out, w.gelfHost, w.levelKey)
+	} else if w.logfmt {
+		p = &logfmtPrinter{w: out, crlf: w.opts.crlf, maxLine: w.opts.maxLine}
+	} else {
+		p = newPrinter(out, w.opts)
+	}
+	if w.renderer != nil {
+		p = &renderPrinter{
+			w:        out,
+			render:   w.renderer,
+			fallback: p,
+		}
+	}
+	return p
+}
+
+func (w *Writer) shouldSuppress(msg []byte) bool {
+	if prefixIndex(w.hidePrefixes, msg) >= 0 {
+		return true
+	}
+	if w.isQuiet(msg) {
+		return true
+	}
+	for _, levelb := range w.suppress {
+		if bytes.HasPrefix(msg, levelb) {
+			if matchColon(msg[len(levelb):]) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// belowMinLevel reports whether the message with the given level
+// and key/value pairs ranks below the minimum level.
+func (w *Writer) belowMinLevel(level string, list [][]byte) bool {
+	if w.minLevel == 0 {
+		return false
+	}
+	rank := w.rank(level, list)
+	return rank > 0 && rank < w.minLevel
+}
+
+// rank returns the rank of the message level, which is the value of the
+// level key if present, otherwise the level at the beginning of the text.
+// It returns zero if the level is not known. See levelRank.
+func (w *Writer) rank(level string, list [][]byte) int {
+	key := w.levelKey
+	if key == nil {
+		key = defaultLevelKey
+	}
+	var rank int
+	for i := 0; i < len(list); i += 2 {
+		if bytes.EqualFold(list[i], key) {
+			rank = levelRank(list[i+1])
+			break
+		}
+	}
+	if rank == 0 {
+		rank = levelRank([]byte(level))
+	}
+	return rank
+}
+
+// defaultLevelKey is the key used by MinLevel if LevelKey has not been called.
+var defaultLevelKey = []byte("level")
+
+// labelKey is the key for the writer's label in structured formats.
+const labelKey = "service"
+
+// levelRank returns the rank of level in the order trace, debug, info,
+// warn, error. It returns zero if level is not known.
+func levelRank(level []byte) int {
+	switch strings.ToLower(string(level)) {
+	case "trace":
+		return 1
+	case "debug":
+		return 2
+	case "info":
+		return 3
+	case "warn", "warning":
+		return 4
+	case "error", "alert", "fatal":
+		return 5
+	}
+	return 0
+}
+
+func (w *Writer) getLevel(msg []byte) (level string, effect string, skip int) {
+	for _, levelInfo := range w.display {
+		if len(msg) < len(levelInfo.levelb)+1 {
+			continue
+		}
+		if bytes.EqualFold(msg[:len(levelInfo.levelb)], levelInfo.levelb) {
+			// match the level but we need a following colon for a match
+			if n := matchColon(msg[len(levelInfo.levelb):]); n > 0 {
+				level = levelInfo.levelstr
+				effect = levelInfo.effect
+				skip = len(levelInfo.levelb) + n
+				break
+			}
+		}
+	}
+	return level, effect, skip
+}
+
+// formatTime replaces the date and time in ent with the
+// date and time formatted using the writer's time format.
+func (w *Writer) formatTime(ent *logEntry) {
+	if len(ent.Date) == 0 && len(ent.Time) == 0 {
+		return
+	}
+	tm, ok := parseLogTime(ent.Date, ent.Time, ent.Timestamp)
+	if !ok {
+		return
+	}
+	w.timeBuf = tm.AppendFormat(w.timeBuf[:0], w.timeFormat)
+	ent.Date = w.timeBuf
+	ent.Time = nil
+}
+
+// parseLogTime parses the date and time printed by a logger. If date is
+// empty, the date is taken from now, which also supplies the location.
+func parseLogTime(date, tod []byte, now time.Time) (time.Time, bool) {
+	loc := now.Location()
+	year, month, day := now.Date()
+	if len(date) > 0 {
+		d, err := time.ParseInLocation("2006/01/02", string(date), loc)
+		if err != nil {
+			return time.Time{}, false
+		}
+		year, month, day = d.Date()
+	}
+	var t time.Time
+	if len(tod) > 0 {
+		// fractional seconds are accepted even though
+		// they are not specified in the layout
+		var err error
+		t, err = time.Parse("15:04:05", string(tod))
+		if err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc), true
+}
+
+// redacted replaces the value of any redacted key.
+var redacted = []byte("****")
+
+// prepare modifies the key/value pairs in list prior to the message
+// being printed and passed to handlers. If quoted is not nil, it reports
+// whether each item in list was quoted, and it is kept in step with the
+// list. It returns the modified list and quoted flags.
+func (w *Writer) prepare(list [][]byte, quoted []bool) ([][]byte, []bool) {
+	if !w.noSanitize {
+		for i := 1; i < len(list); i += 2 {
+			list[i] = escapeControl(list[i])
+		}
+	}
+	if w.errorKeys != nil {
+		list, quoted = w.expandErrors(list, quoted)
+	}
+	if w.duplicates != KeepDuplicateKeys {
+		list, quoted = mergeDuplicateKeys(list, quoted, w.duplicates)
+	}
+	if w.redactKeys != nil || w.redactFunc != nil {
+		for i := 0; i < len(list); i += 2 {
+			if w.isRedacted(string(list[i])) {
+				list[i+1] = redacted
+			}
+		}
+	}
+	if w.null != nil {
+		for i := 1; i < len(list); i += 2 {
+			list[i] = w.normalizeValue(list[i])
+		}
+	}
+	if w.maxValue > 0 {
+		for i := 1; i < len(list); i += 2 {
+			list[i] = truncate(list[i], w.maxValue)
+		}
+	}
+	if w.sortKeys {
+		sort.Stable(keyvalPairs{list: list, quoted: quoted})
+	}
+	return list, quoted
+}
+
+// mergeDuplicateKeys returns list with the pairs for each key collapsed
+// into a single pair according to policy. The list and its quoted flags,
+// if not nil, are modified in place.
+func mergeDuplicateKeys(list [][]byte, quoted []bool, policy DuplicateKeys) ([][]byte, []bool) {
+	merged := list[:0]
+	var mergedQuoted []bool
+	if quoted != nil {
+		mergedQuoted = quoted[:0]
+	}
+loop:
+	for i := 0; i < len(list); i += 2 {
+		for j := 0; j < len(merged); j += 2 {
+			if bytes.Equal(merged[j], list[i]) {
+				if policy == LastKeyWins {
+					merged[j+1] = list[i+1]
+					if quoted != nil {
+						mergedQuoted[j+1] = quoted[i+1]
+					}
+				}
+				continue loop
+			}
+		}
+		merged = append(merged, list[i], list[i+1])
+		if quoted != nil {
+			mergedQuoted = append(mergedQuoted, quoted[i], quoted[i+1])
+		}
+	}
+	return merged, mergedQuoted
+}
+
+// expandErrors returns list with the value of each error key that
+// contains key/value pairs replaced by its text and key/value pairs.
+// The list and its quoted flags, if not nil, are only copied if an
+// error value is expanded.
+func (w *Writer) expandErrors(list [][]byte, quoted []bool) ([][]byte, []bool) {
+	var expanded [][]byte
+	var expandedQuoted []bool
+	for i := 0; i < len(list); i += 2 {
+		if w.isErrorKey(list[i]) {
+			msg := parse.Bytes(list[i+1])
+			if len(msg.List) > 0 {
+				if expanded == nil {
+					expanded = make([][]byte, 0, len(list)+len(msg.List))
+					expanded = append(expanded, list[:i]...)
+					if quoted != nil {
+						expandedQuoted = make([]bool, 0, len(list)+len(msg.List))
+						expandedQuoted = append(expandedQuoted, quoted[:i]...)
+					}
+				}
+				// values can refer to the message's buffer
+				// for unquoting, so they are copied
+				expanded = append(expanded, list[i], copyBytes(msg.Text))
+				for _, v := range msg.List {
+					expanded = append(expanded, copyBytes(v))
+				}
+				if quoted != nil {
+					// the error text is always a string
+					expandedQuoted = append(expandedQuoted, quoted[i], true)
+					expandedQuoted = append(expandedQuoted, msg.Quoted...)
+				}
+				msg.Release()
+				continue
+			}
+			msg.Release()
+		}
+		if expanded != nil {
+			expanded = append(expanded, list[i], list[i+1])
+			if quoted != nil {
+				expandedQuoted = append(expandedQuoted, quoted[i], quoted[i+1])
+			}
+		}
+	}
+	if expanded == nil {
+		return list, quoted
+	}
+	return expanded, expandedQuoted
+}
+
+func (w *Writer) isErrorKey(key []byte) bool {
+	for _, k := range w.errorKeys {
+		if bytes.EqualFold(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate returns v if it is no longer than n runes. Otherwise
+// it returns the first n-1 runes of v followed by an ellipsis.
+func truncate(v []byte, n int) []byte {
+	if utf8.RuneCount(v) <= n {
+		return v
+	}
+	var size int
+	for i := 0; i < n-1; i++ {
+		_, runeSize := utf8.DecodeRune(v[size:])
+		size += runeSize
+	}
+	return append(v[:size:size], "…"...)
+}
+
+// escapeControl returns v with each control character replaced with
+// an escape sequence, except for tabs, newlines, carriage returns that
+// precede a newline, and color escape sequences such as "\x1b[31m".
+// It returns v unchanged if it contains no characters to replace.
+func escapeControl(v []byte) []byte {
+	i := indexControl(v)
+	if i < 0 {
+		return v
+	}
+	buf := make([]byte, 0, len(v)+8)
+	buf = append(buf, v[:i]...)
+	for i < len(v) {
+		if n := controlLen(v[i:]); n > 0 {
+			// all control characters are in the Latin-1 range
+			r, _ := utf8.DecodeRune(v[i:])
+			buf = append(buf, fmt.Sprintf("\\x%02x", r)...)
+			i += n
+			continue
+		}
+		n := colorLen(v[i:])
+		if n == 0 {
+			_, n = utf8.DecodeRune(v[i:])
+		}
+		buf = append(buf, v[i:i+n]...)
+		i += n
+	}
+	return buf
+}
+
+// indexControl returns the index of the first control character
+// in v that is replaced by escapeControl, or -1 if there is none.
+func indexControl(v []byte) int {
+	for i := 0; i < len(v); {
+		if controlLen(v[i:]) > 0 {
+			return i
+		}
+		n := colorLen(v[i:])
+		if n == 0 {
+			_, n = utf8.DecodeRune(v[i:])
+		}
+		i += n
+	}
+	return -1
+}
+
+// controlLen returns the size in bytes of the control character at the
+// start of v, or zero if v does not start with a control character that
+// is replaced by escapeControl.
+func controlLen(v []byte) int {
+	if len(v) == 0 {
+		return 0
+	}
+	if c := v[0]; c < utf8.RuneSelf {
+		switch {
+		case c == '\t' || c == '\n':
+			return 0
+		case c == '\r' && len(v) > 1 && v[1] == '\n':
+			return 0
+		case c == 0x1b && colorLen(v) > 0:
+			return 0
+		case c < 0x20 || c == 0x7f:
+			return 1
+		}
+		return 0
+	}
+	r, size := utf8.DecodeRune(v)
+	if unicode.IsControl(r) {
+		return size
+	}
+	return 0
+}
+
+// colorLen returns the size in bytes of the color escape sequence
+// at the start of v, such as "\x1b[1;31m", or zero if v does not
+// start with a color escape sequence.
+func colorLen(v []byte) int {
+	if len(v) < 3 || v[0] != 0x1b || v[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == 'm':
+			return i + 1
+		case c != ';' && (c < '0' || c > '9'):
+			return 0
+		}
+	}
+	return 0
+}
+
+func (w *Writer) isRedacted(key string) bool {
+	if _, ok := w.redactKeys[strings.ToLower(key)]; ok {
+		return true
+	}
+	return w.redactFunc != nil && w.redactFunc(key)
+}
+
+func (w *Writer) handler(entry *logEntry) {
+	if w.rendering != nil {
+		w.renderEntry(entry)
+		return
+	}
+	if w.entryHandler != nil {
+		w.entryHandler(entry)
+	}
+	if w.handlers != nil {
+		var msg *Message
+		for _, h := range w.handlers {
+			if h.Handles(entry.Prefix, entry.Level) {
+				if msg == nil {
+					msg = newMessage(entry)
+				}
+				h.Handle(msg)
+			}
+		}
+	}
+	if w.errPrinter != nil && w.isErrorOrWarning(entry) {
+		w.errPrinter.Print(entry)
+	} else {
+		w.printer.Print(entry)
+	}
+}
+
+// isErrorLevel reports whether level is displayed as an error.
+func (w *Writer) isErrorLevel(level string) bool {
+	if level == "" {
+		return false
+	}
+	w.setDefaultPrefixes()
+	for _, prefix := range w.errors {
+		if strings.EqualFold(prefix, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// isErrorOrWarning reports whether entry is printed
+// to the error output of a split writer.
+func (w *Writer) isErrorOrWarning(entry *logEntry) bool {
+	if entry.Level != "" {
+		w.setDefaultPrefixes()
+		for _, prefixes := range [][]string{w.errors, w.warnings} {
+			for _, prefix := range prefixes {
+				if strings.EqualFold(prefix, entry.Level) {
+					return true
+				}
+			}
+		}
+	}
+	return w.rank(entry.Level, entry.List) >= levelRank([]byte("warn"))
+}
+
+// newMessage returns a message containing the details in entry.
+func newMessage(entry *logEntry) *Message {
+	msg := &Message{
+		Timestamp: entry.Timestamp,
+		Prefix:    entry.Prefix,
+		Level:     entry.Level,
+		Text:      string(entry.Text),
+	}
+	if entry.File != nil {
+		msg.File = string(entry.File)
+	}
+	if entry.Label != "" || len(entry.List) > 0 {
+		msg.List = make(kv.List, 0, len(entry.List)+2)
+	}
+	if entry.Label != "" {
+		msg.List = append(msg.List, labelKey, entry.Label)
+	}
+	for i, v := range entry.List {
+		msg.List = append(msg.List, messageValue(entry, i, v))
+	}
+	return msg
+}
+
+// messageValue returns the i'th item in the entry's key/value list as
+// a message list item. If values are normalized, nil and boolean values
+// keep their type, so that they are marshaled to JSON as null and booleans.
+func messageValue(entry *logEntry, i int, v []byte) interface{} {
+	if entry.Null != nil && i%2 == 1 {
+		switch {
+		case bytes.Equal(v, entry.Null):
+			return nil
+		case bytes.Equal(v, valueTrue):
+			return true
+		case bytes.Equal(v, valueFalse):
+			return false
+		}
+	}
+	return string(v)
+}
+
+// keyvalPairs implements sort.Interface for sorting
+// a list of key/value pairs by key.
+type keyvalPairs struct {
+	list   [][]byte
+	quoted []bool // quoted flags of the list, or nil
+}
+
+func (p keyvalPairs) Len() int           { return len(p.list) / 2 }
+func (p keyvalPairs) Less(i, j int) bool { return bytes.Compare(p.list[i*2], p.list[j*2]) < 0 }
+func (p keyvalPairs) Swap(i, j int) {
+	i, j = i*2, j*2
+	p.list[i], p.list[j] = p.list[j], p.list[i]
+	p.list[i+1], p.list[j+1] = p.list[j+1], p.list[i+1]
+	if p.quoted != nil {
+		p.quoted[i], p.quoted[j] = p.quoted[j], p.quoted[i]
+		p.quoted[i+1], p.quoted[j+1] = p.quoted[j+1], p.quoted[i+1]
+	}
+}
+
+// logWriter is a writer tailored for a specific logger.
+type logWriter struct {
+	prefixb []byte // logger prefix bytes
+	prefixs string // logger prefix string
+	utc     bool   // is time in UTC
+	hasDate bool   // logger prints date
+	hasTime bool   // logger prints time
+	hasFile bool   // logger prints file (???:0 D:/go/src/github.com/jjeffery/kv/kv.go:123)
+	output  *Writer
+	logger  *log.Logger
+	changed bool // logger details changed, guarded by output.mutex
+}
+
+// matchDate returns the length of the date at the beginning
+// of b in the format YYYY/MM/DD, or zero if there is no date.
+func matchDate(b []byte) int {
+	const layout = "dddd/dd/dd"
+	if len(b) < len(layout) {
+		return 0
+	}
+	for i := 0; i < len(layout); i++ {
+		if layout[i] == 'd' {
+			if !isDigit(b[i]) {
+				return 0
+			}
+		} else if b[i] != layout[i] {
+			return 0
+		}
+	}
+	return len(layout)
+}
+
+// matchTime returns the length of the time at the beginning of b in
+// the format HH:MM:SS with optional fractional seconds, or zero if
+// there is no time.
+func matchTime(b []byte) int {
+	const layout = "dd:dd:dd"
+	if len(b) < len(layout) {
+		return 0
+	}
+	for i := 0; i < len(layout); i++ {
+		if layout[i] == 'd' {
+			if !isDigit(b[i]) {
+				return 0
+			}
+		} else if b[i] != layout[i] {
+			return 0
+		}
+	}
+	n := len(layout)
+	if n < len(b) && b[n] == '.' {
+		if digits := scan(b[n+1:], isDigit); digits > 0 {
+			n += 1 + digits
+		}
+	}
+	return n
+}
+
+// matchFile returns the length of the file name and line number at
+// the beginning of b, or zero if there is none. The file name can
+// start with a Windows drive letter, eg "D:/src/kv.go:123".
+func matchFile(b []byte) int {
+	if len(b) >= 2 && isLetter(b[0]) && b[1] == ':' {
+		if n := matchFileLine(b[2:]); n > 0 {
+			return n + 2
+		}
+	}
+	return matchFileLine(b)
+}
+
+// matchFileLine returns the length of the file name without a
+// colon followed by a colon and line number, or zero if there is none.
+func matchFileLine(b []byte) int {
+	n := scan(b, isNotColon)
+	if n == 0 || n == len(b) {
+		return 0
+	}
+	digits := scan(b[n+1:], isDigit)
+	if digits == 0 {
+		return 0
+	}
+	return n + 1 + digits
+}
+
+// matchColon returns the length of a colon at the beginning of b,
+// including any surrounding white space, or zero if there is none.
+func matchColon(b []byte) int {
+	n := scan(b, isWhiteSpace)
+	if n == len(b) || b[n] != ':' {
+		return 0
+	}
+	n++
+	return n + scan(b[n:], isWhiteSpace)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNotColon(c byte) bool {
+	return c != ':'
+}
+
+func newLogWriter(output *Writer, logger *log.Logger) *logWriter {
+	w := &logWriter{
+		output: output,
+		logger: logger,
+	}
+	w.setup()
+	return w
+}
+
+func isspace(ch rune) bool {
+	return ch == ' ' || ch == '\t'
+}
+
+func (w *logWriter) setup() {
+	var (
+		prefix string
+		flags  int
+	)
+	if w.logger == nil {
+		prefix = log.Prefix()
+		flags = log.Flags()
+	} else {
+		prefix = w.logger.Prefix()
+		flags = w.logger.Flags()
+	}
+	if prefix != "" {
+		w.prefixs = prefix
+		w.prefixb = []byte(prefix)
+	}
+	if flags&log.Ldate != 0 {
+		w.hasDate = true
+	}
+	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		w.hasTime = true
+	}
+	if flags&(log.Lshortfile|log.Llongfile) != 0 {
+		w.hasFile = true
+	}
+	if flags&log.LUTC != 0 {
+		w.utc = true
+	}
+}
+
+// Write implements the io.Writer interface. This method is
+// called from the logger while the logger's mutex is locked, so
+// it relies on the prefix and flags copied from the logger when
+// the writer was attached. If they no longer match the message,
+// the writer is attached again by a goroutine.
+//
+// Write returns len(p) and a nil error if the message is printed, and
+// also if the message is suppressed, so that the logger does not see
+// a short write. It returns ErrClosed if the writer (or a sink added
+// with Tee) is closed, and otherwise returns the error, if any, from
+// writing the message to the writer passed to Raw.
 func (w *logWriter) Write(p []byte) (n int, err error) {
 	var (
+		line    = p
+		size    = len(p)
 		now     = time.Now() // do this early
 		prefix  string
 		logdate []byte
@@ -380,6 +2269,17 @@ func (w *logWriter) Write(p []byte) (n int, err error) {
 		changed bool
 	)
 
+	w.output.mutex.Lock()
+	if w.output.discards() {
+		closed := w.output.closed
+		w.output.mutex.Unlock()
+		if closed {
+			return 0, ErrClosed
+		}
+		return size, nil
+	}
+	w.output.mutex.Unlock()
+
 	if w.utc {
 		now = now.UTC()
 	}
@@ -392,68 +2292,70 @@ func (w *logWriter) Write(p []byte) (n int, err error) {
 		}
 	}
 	p = bytes.TrimLeftFunc(p, isspace)
-	if w.dateRE != nil {
-		dateb := w.dateRE.Find(p)
-		if dateb != nil {
-			logdate = dateb
-			p = p[len(dateb):]
+	if w.hasDate {
+		if n := matchDate(p); n > 0 {
+			logdate = p[:n]
+			p = p[n:]
 			p = bytes.TrimLeftFunc(p, isspace)
 		} else {
 			changed = true
 		}
 	}
-	if w.timeRE != nil {
-		timeb := w.timeRE.Find(p)
-		if timeb != nil {
-			logtime = timeb
-			p = p[len(timeb):]
+	if w.hasTime {
+		if n := matchTime(p); n > 0 {
+			logtime = p[:n]
+			p = p[n:]
 			p = bytes.TrimLeftFunc(p, isspace)
 		} else {
 			changed = true
 		}
 	}
-	if w.fileRE != nil {
-		fileb := w.fileRE.Find(p)
-		if fileb != nil {
-			file = fileb
-			p = p[len(fileb):]
+	if w.hasFile {
+		if n := matchFile(p); n > 0 {
+			file = p[:n]
+			p = p[n:]
 			p = bytes.TrimLeftFunc(p, isspace)
-			skip := colonRE.Find(p)
-			p = p[len(skip):]
+			p = p[matchColon(p):]
 		} else {
 			changed = true
 		}
 	}
 
 	w.output.mutex.Lock()
-	if w.output.levels == nil {
-		// apply the default levels as late as possible,
-		// which gives the calling program an opportunity
-		// to change default levels at program initialization
-		w.output.setLevels(Levels)
-	}
-	if !w.output.shouldSuppress(p) {
-		level, effect, skip := w.output.getLevel(p)
-		p = p[skip:]
-		msg := parse.Bytes(p)
-		ent := logEntry{
-			Timestamp: now,
-			Prefix:    prefix,
-			Date:      logdate,
-			Time:      logtime,
-			File:      file,
-			Level:     level,
-			Effect:    effect,
-			Text:      msg.Text,
-			List:      msg.List,
-		}
-		w.output.handler(&ent)
-		msg.Release()
+	if re := w.output.headerRE; re != nil {
+		if loc := re.FindIndex(p); loc != nil && loc[0] == 0 && loc[1] > 0 {
+			prefix += string(p[:loc[1]])
+			p = p[loc[1]:]
+		}
+	}
+	hdr := &logEntry{
+		Timestamp: now,
+		Prefix:    prefix,
+		Date:      logdate,
+		Time:      logtime,
+		File:      file,
+	}
+	rawErr := w.output.writeRaw(line)
+	if w.output.joiner != nil {
+		continuation := w.isContinuation(line, hdr)
+		if continuation {
+			// a missing header is expected
+			changed = false
+		}
+		err = w.output.writeJoined(hdr, p, line, continuation)
+	} else {
+		err = w.output.writeEntry(hdr, p, nil)
+	}
+	if err == nil {
+		err = rawErr
+	}
+	warn := changed && !w.changed
+	if warn {
+		w.changed = true
 	}
 	w.output.mutex.Unlock()
 
-	if changed && !w.changed {
-		w.changed = true
+	if warn {
 		go func() {
 			w.output.Attach(w.logger)
 			if w.logger == nil {
@@ -464,5 +2366,151 @@ func (w *logWriter) Write(p []byte) (n int, err error) {
 		}()
 	}
 
-	return len(p), nil
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// writeEntry prints the message text p, which has had the logger's
+// header removed. The header details are in hdr. If shared is not nil,
+// it contains the text and key/value pairs of p, so that p is not parsed.
+// It is shared with other writers and is not modified. The writer's mutex
+// must be locked.
+func (w *Writer) writeEntry(hdr *logEntry, p []byte, shared *parse.Message) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if q, n := w.replaceAlias(p); n > 0 {
+		if shared != nil {
+			shared = replaceSharedAlias(shared, p[:n], q[:len(q)-len(p)+n])
+		}
+		p = q
+	}
+	if len(w.sinks) > 0 {
+		return w.tee(hdr, p, shared)
+	}
+	w.setDefaults()
+	if w.dedup != nil {
+		w.dedup.expire(hdr.Timestamp, w.handler)
+	}
+	if w.shouldSuppress(p) {
+		return nil
+	}
+	if w.sample > 1 && w.skipSample(p) {
+		return nil
+	}
+	ent, msg := w.parseEntry(hdr, p, shared)
+	defer msg.Release()
+	if ent == nil {
+		return nil
+	}
+	if w.dedup == nil || !w.dedup.repeat(hdr.Prefix+string(p), ent, w.handler) {
+		w.handler(ent)
+	}
+	*ent = logEntry{}
+	return nil
+}
+
+// setDefaults applies the default levels and verbose prefixes if they
+// have not been set. This is done as late as possible, which gives the
+// calling program an opportunity to change the defaults at program
+// initialization. The writer's mutex must be locked.
+func (w *Writer) setDefaults() {
+	if w.levels == nil {
+		w.setDefaultLevels()
+	}
+	if w.verbose == nil {
+		w.setVerbosePrefixes(VerbosePrefixes)
+	}
+}
+
+// parseEntry returns the writer's entry populated with the header details
+// in hdr and the level, text and key/value pairs in p, or nil if the message
+// ranks below the minimum level. If shared is not nil, it is the result of
+// parsing p. If the returned message is not nil, it contains the memory used
+// by the entry, and the caller must release it after printing the entry.
+// The writer's mutex must be locked.
+func (w *Writer) parseEntry(hdr *logEntry, p []byte, shared *parse.Message) (*logEntry, *parse.Message) {
+	level, effect, skip := w.getLevel(p)
+	var msg *parse.Message
+	var text []byte
+	var list [][]byte
+	var quoted []bool
+	if shared == nil {
+		msg = parse.Bytes(p[skip:])
+		text, list, quoted = msg.Text, msg.List, msg.Quoted
+	} else {
+		// the level is at the beginning of the shared message text,
+		// and the key/value pairs are copied because prepare modifies them
+		if skip < len(shared.Text) {
+			text = bytes.TrimSpace(shared.Text[skip:])
+		}
+		w.list = append(w.list[:0], shared.List...)
+		list = w.list
+		w.quoted = append(w.quoted[:0], shared.Quoted...)
+		quoted = w.quoted
+	}
+	if w.otel == nil {
+		// only the OpenTelemetry printer needs to know which values were
+		// quoted, so the flags are not kept in step with the list otherwise
+		quoted = nil
+	} else if quoted == nil {
+		quoted = make([]bool, len(list))
+	}
+	if len(hdr.List) > 0 {
+		list = append(list, hdr.List...)
+		if quoted != nil {
+			for range hdr.List {
+				quoted = append(quoted, false)
+			}
+		}
+	}
+	if w.belowMinLevel(level, list) {
+		return nil, msg
+	}
+	if !w.noSanitize {
+		text = escapeControl(text)
+	}
+	list, quoted = w.prepare(list, quoted)
+	// the writer's entry is re-used to avoid memory
+	// allocation, which is safe while the mutex is locked
+	ent := &w.entry
+	*ent = *hdr
+	ent.Level = level
+	ent.Effect = effect
+	ent.ErrorText = w.opts.errorText && w.isErrorLevel(level)
+	ent.Text = text
+	ent.List = list
+	ent.Quoted = quoted
+	ent.Label = w.label
+	ent.Null = w.null
+	if w.noTime {
+		ent.Date = nil
+		ent.Time = nil
+	} else if w.timeFormat != "" {
+		w.formatTime(ent)
+	}
+	return ent, msg
+}
+
+// tee parses p once, unless shared is not nil, and prints the message
+// to each of the sinks.
+func (w *Writer) tee(hdr *logEntry, p []byte, shared *parse.Message) error {
+	msg := shared
+	if msg == nil {
+		msg = parse.Bytes(p)
+		defer msg.Release()
+	}
+	var err error
+	for _, sink := range w.sinks {
+		sink.mutex.Lock()
+		sink.rendering = w.rendering
+		if serr := sink.writeEntry(hdr, p, msg); serr != nil && err == nil {
+			err = serr
+		}
+		sink.rendering = nil
+		sink.mutex.Unlock()
+	}
+	return err
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/jjeffery/kv/internal/logfmt"
 	"github.com/jjeffery/kv/internal/parse"
@@ -11,6 +12,14 @@ import (
 )
 
 // List is a slice of alternating keys and values.
+//
+// The lists returned by With and the List methods have their capacity
+// limited to their length, so appending to a list always allocates a
+// new backing array. This means that a base list can be shared between
+// goroutines, and each goroutine can extend it with With, or with the
+// builtin append function, without seeing the pairs added by the others.
+// A list may share memory with the list or slice it was created from,
+// so the items of a shared list must not be modified.
 type List []interface{}
 
 // Parse parses the input and reports the message text,
@@ -31,10 +40,110 @@ func Parse(input []byte) (text []byte, list List) {
 	return text, list
 }
 
+// ParseTyped is like Parse, except that unquoted values that look
+// like integers, floating point numbers or booleans are returned as
+// int, float64 and bool values respectively. All other values, and
+// all quoted values, are returned as strings.
+//
+// The inference is conservative so that values such as identifiers
+// and version numbers remain strings:
+//
+//   - an int is an optional minus sign followed by decimal digits,
+//     without leading zeros, that fits in an int ("42", "-7", but not "007");
+//   - a float64 is an int followed by a decimal point, one or more digits,
+//     and an optional exponent ("1.5", "-0.25", "6.02e23", but not
+//     "1.", ".5", "1.2.3", "1e5" or "NaN");
+//   - a bool is exactly "true" or "false".
+func ParseTyped(input []byte) (text []byte, list List) {
+	m := parse.Bytes(input)
+	text = m.Text
+	if len(m.List) > 0 {
+		list = make(List, len(m.List))
+		for i, v := range m.List {
+			if i%2 == 1 && !m.Quoted[i] {
+				list[i] = parse.Typed(v)
+			} else {
+				list[i] = string(v)
+			}
+		}
+	}
+	m.Release()
+	return text, list
+}
+
+// ParseInto is like Parse, except that the results are stored in dst, and
+// the memory used for the key/value pairs is re-used. The memory is kept
+// by dst, so its capacity is retained from one call to the next, and the
+// keys and values of a message share a single string. This avoids most of
+// the memory allocation done by Parse when many messages are parsed, one
+// after the other.
+//
+// The backing array of dst.List is overwritten by the next call to
+// ParseInto with the same dst, but the capacity of dst.List is limited to
+// its length, so appending to it never modifies the pairs of another message.
+// The keys and values are strings, which can be kept. The text, if non-nil,
+// points to the same backing array as input.
+func ParseInto(dst *Message, input []byte) {
+	m := parse.Bytes(input)
+	dst.Text = m.Text
+	dst.List = nil
+	if len(m.List) > 0 {
+		dst.list = appendStrings(dst.list[:0], m.List)
+		dst.List = dst.list[:len(dst.list):len(dst.list)]
+	}
+	m.Release()
+}
+
+// appendStrings appends each item of list to dst as a string. The strings
+// share the memory of a single string, so only one string is allocated.
+func appendStrings(dst List, list [][]byte) List {
+	if len(list) == 0 {
+		return dst
+	}
+	buf := pool.AllocBuffer()
+	for _, v := range list {
+		buf.Write(v)
+	}
+	str := buf.String()
+	pool.ReleaseBuffer(buf)
+	for _, v := range list {
+		dst = append(dst, str[:len(v)])
+		str = str[len(v):]
+	}
+	return dst
+}
+
 // With returns a list populated with keyvals as the key/value pairs.
+// If keyvals does not need to be flattened, the list shares memory with
+// keyvals, but its capacity is limited so that appending to the list does
+// not modify keyvals.
 func With(keyvals ...interface{}) List {
 	keyvals = flattenFix(keyvals)
-	return List(keyvals)
+	return List(keyvals[:len(keyvals):len(keyvals)])
+}
+
+// Filter returns a list containing the key/value pairs in l for which keep
+// returns true. If keep returns true for all pairs, the list returned shares
+// the same backing array as l, so no memory is allocated. Nested lists are
+// flattened and any missing keys supplied before keep is called.
+func (l List) Filter(keep func(key string, value interface{}) bool) List {
+	fl := flattenFix(l)
+	var filtered List
+	for i := 0; i < len(fl); i += 2 {
+		key, _ := fl[i].(string)
+		if keep(key, fl[i+1]) {
+			if filtered != nil {
+				filtered = append(filtered, fl[i], fl[i+1])
+			}
+		} else if filtered == nil {
+			filtered = make(List, i, len(fl)-2)
+			copy(filtered, fl[:i])
+		}
+	}
+	if filtered == nil {
+		filtered = fl
+	}
+	return filtered[:len(filtered):len(filtered)]
 }
 
 // From returns a new context with key/value pairs copied both from
@@ -64,6 +173,30 @@ func (l List) NewError(text string) Error {
 	return e
 }
 
+// SortedString is like String, except that the key/value pairs are sorted
+// by key, which gives the same result for lists that contain the same pairs
+// in a different order. The sort is stable, so pairs with the same key remain
+// in their original order. The list is not modified.
+func (l List) SortedString() string {
+	fl := flattenFix(l)
+	keys := make([]int, len(fl)/2) // index of each key in fl
+	for i := range keys {
+		keys[i] = i * 2
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return fl[keys[i]].(string) < fl[keys[j]].(string)
+	})
+	buf := pool.AllocBuffer()
+	defer pool.ReleaseBuffer(buf)
+	for n, i := range keys {
+		if n > 0 {
+			buf.WriteRune(' ')
+		}
+		logfmt.WriteKeyValue(buf, fl[i], fl[i+1])
+	}
+	return buf.String()
+}
+
 // String returns a string representation of the key/value pairs in
 // logfmt format: "key1=value1 key2=value2  ...".
 func (l List) String() string {
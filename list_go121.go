@@ -0,0 +1,85 @@
+//go:build go1.21
+// +build go1.21
+
+package kv
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/jjeffery/kv/internal/logfmt"
+)
+
+// Attrs returns the key/value pairs as slog attributes, so that they can
+// be passed to a slog.Logger, for example using its LogAttrs method.
+//
+// Values keep their type where slog has a matching kind: strings, integers,
+// unsigned integers, floating point numbers, booleans, time.Time and
+// time.Duration values. Values of a type with a function registered by
+// RegisterFormatter are strings that are formatted by the function, and
+// byte slices are strings formatted as described in SetBytesFormat. Other
+// values, such as errors, are converted by slog.AnyValue.
+//
+// The list is flattened in the same way as for String, so nested lists
+// are expanded, and keys are added where they are missing or invalid.
+func (l List) Attrs() []slog.Attr {
+	fl := flattenFix(l)
+	attrs := make([]slog.Attr, 0, len(fl)/2)
+	for i := 0; i < len(fl); i += 2 {
+		attrs = append(attrs, slog.Attr{
+			Key:   fl[i].(string),
+			Value: attrValue(fl[i+1]),
+		})
+	}
+	return attrs
+}
+
+func attrValue(value interface{}) slog.Value {
+	if format := logfmt.Formatter(value); format != nil {
+		return slog.StringValue(logfmt.Format(format, value))
+	}
+	switch v := value.(type) {
+	case string:
+		return slog.StringValue(v)
+	case int:
+		return slog.IntValue(v)
+	case int8:
+		return slog.Int64Value(int64(v))
+	case int16:
+		return slog.Int64Value(int64(v))
+	case int32:
+		return slog.Int64Value(int64(v))
+	case int64:
+		return slog.Int64Value(v)
+	case uint:
+		return slog.Uint64Value(uint64(v))
+	case uint8:
+		return slog.Uint64Value(uint64(v))
+	case uint16:
+		return slog.Uint64Value(uint64(v))
+	case uint32:
+		return slog.Uint64Value(uint64(v))
+	case uint64:
+		return slog.Uint64Value(v)
+	case float32:
+		return slog.Float64Value(float64(v))
+	case float64:
+		return slog.Float64Value(v)
+	case bool:
+		return slog.BoolValue(v)
+	case time.Time:
+		return slog.TimeValue(v)
+	case time.Duration:
+		return slog.DurationValue(v)
+	case []byte:
+		if v == nil {
+			return slog.AnyValue(nil)
+		}
+		if logfmt.IsHexBytes(v) {
+			return slog.StringValue(hex.EncodeToString(v))
+		}
+		return slog.StringValue(string(v))
+	}
+	return slog.AnyValue(value)
+}
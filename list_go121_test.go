@@ -0,0 +1,81 @@
+//go:build go1.21
+// +build go1.21
+
+package kv
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListAttrs(t *testing.T) {
+	typ := reflect.TypeOf(testMoney{})
+	RegisterFormatter(typ, func(v interface{}) string {
+		m := v.(testMoney)
+		return m.Currency + "300"
+	})
+	defer RegisterFormatter(typ, nil)
+
+	tm := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+	err := errors.New("not found")
+	tests := []struct {
+		list List
+		want []slog.Attr
+	}{
+		{
+			list: List{"s", "value", "i", 42, "i8", int8(-8), "i64", int64(-64)},
+			want: []slog.Attr{slog.String("s", "value"), slog.Int("i", 42), slog.Int64("i8", -8), slog.Int64("i64", -64)},
+		},
+		{
+			list: List{"u", uint(1), "u8", uint8(8), "u64", uint64(64)},
+			want: []slog.Attr{slog.Uint64("u", 1), slog.Uint64("u8", 8), slog.Uint64("u64", 64)},
+		},
+		{
+			list: List{"f32", float32(1.5), "f64", 2.25, "b", true},
+			want: []slog.Attr{slog.Float64("f32", 1.5), slog.Float64("f64", 2.25), slog.Bool("b", true)},
+		},
+		{
+			list: List{"time", tm, "elapsed", 1500 * time.Millisecond},
+			want: []slog.Attr{slog.Time("time", tm), slog.Duration("elapsed", 1500*time.Millisecond)},
+		},
+		{
+			list: List{"text", []byte("hi"), "data", []byte{0xca, 0xfe}, "nil", []byte(nil)},
+			want: []slog.Attr{slog.String("text", "hi"), slog.String("data", "cafe"), slog.Any("nil", nil)},
+		},
+		{
+			list: List{"price", testMoney{Currency: "AUD", Cents: 300}},
+			want: []slog.Attr{slog.String("price", "AUD300")},
+		},
+		{
+			list: List{"err", err, "nil", nil},
+			want: []slog.Attr{slog.Any("err", err), slog.Any("nil", nil)},
+		},
+		{
+			list: List{"nested", With("a", 1), 1, "one"},
+			want: []slog.Attr{slog.String("msg", "nested"), slog.Int("a", 1), slog.Int("_p1", 1), slog.String("_p2", "one")},
+		},
+		{
+			list: List{"odd"},
+			want: []slog.Attr{slog.String("msg", "odd")},
+		},
+		{
+			list: nil,
+			want: []slog.Attr{},
+		},
+	}
+	for tn, tt := range tests {
+		got := tt.list.Attrs()
+		if len(got) != len(tt.want) {
+			t.Errorf("%d:\n got=%v\nwant=%v", tn, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(tt.want[i]) || got[i].Value.Kind() != tt.want[i].Value.Kind() {
+				t.Errorf("%d: %d:\n got=%v (%v)\nwant=%v (%v)", tn, i, got[i], got[i].Value.Kind(), tt.want[i], tt.want[i].Value.Kind())
+			}
+		}
+	}
+}
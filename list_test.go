@@ -1,7 +1,11 @@
 package kv
 
 import (
+	"context"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/jjeffery/kv/internal/pool"
@@ -106,3 +110,287 @@ func benchmarkListString(list List, b *testing.B) {
 		pool.ReleaseBuffer(buf)
 	}
 }
+
+func TestParseTyped(t *testing.T) {
+	tests := []struct {
+		input string
+		text  string
+		list  List
+	}{
+		{
+			input: `message count=42 neg=-7 ok=true bad=false`,
+			text:  "message",
+			list:  List{"count", 42, "neg", -7, "ok", true, "bad", false},
+		},
+		{
+			input: `ratio=1.5 small=-0.25 big=6.02e23 zero=0 zerof=0.0`,
+			list:  List{"ratio", 1.5, "small", -0.25, "big", 6.02e23, "zero", 0, "zerof", 0.0},
+		},
+		{ // quoted values remain strings
+			input: `count="42" ok="true" ratio="1.5"`,
+			list:  List{"count", "42", "ok", "true", "ratio", "1.5"},
+		},
+		{ // values that only look like numbers or booleans remain strings
+			input: `v=1.2.3 zip=007 a=1. b=.5 c=1e5 d=NaN e=Inf f=True g=0x10 h=- i=1_000 j=99999999999999999999 k=1.5e`,
+			list: List{
+				"v", "1.2.3",
+				"zip", "007",
+				"a", "1.",
+				"b", ".5",
+				"c", "1e5",
+				"d", "NaN",
+				"e", "Inf",
+				"f", "True",
+				"g", "0x10",
+				"h", "-",
+				"i", "1_000",
+				"j", "99999999999999999999",
+				"k", "1.5e",
+			},
+		},
+		{
+			input: `no key value pairs 42`,
+			text:  "no key value pairs 42",
+		},
+	}
+	for tn, tt := range tests {
+		text, list := ParseTyped([]byte(tt.input))
+		if got, want := string(text), tt.text; got != want {
+			t.Errorf("%d: text:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got, want := list, tt.list; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d:\n got=%#v\nwant=%#v", tn, got, want)
+		}
+		// default behavior is unchanged
+		_, list = Parse([]byte(tt.input))
+		for i, v := range list {
+			if _, ok := v.(string); !ok {
+				t.Errorf("%d: Parse: item %d is %T, want string", tn, i, v)
+			}
+		}
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	inputs := []string{
+		`message text a=1 b="value 2"`,
+		`no key value pairs`,
+		`a=1 b=2 c=3 d=4`,
+		``,
+		`last x=y`,
+	}
+	var msg Message
+	for tn, input := range inputs {
+		ParseInto(&msg, []byte(input))
+		text, list := Parse([]byte(input))
+		if got, want := string(msg.Text), string(text); got != want {
+			t.Errorf("%d: text:\n got=%q\nwant=%q", tn, got, want)
+		}
+		if got, want := len(msg.List), len(list); got != want {
+			t.Errorf("%d: got len=%d, want=%d", tn, got, want)
+			continue
+		}
+		for i := range list {
+			if got, want := msg.List[i], list[i]; got != want {
+				t.Errorf("%d: item %d: got=%v, want=%v", tn, i, got, want)
+			}
+		}
+	}
+	if got, want := cap(msg.list), 8; got < want {
+		t.Errorf("got cap=%d, want at least %d", got, want)
+	}
+
+	// appending to the list does not modify the next message
+	ParseInto(&msg, []byte("first a=1 b=2"))
+	first := append(msg.List, "x", "y")
+	ParseInto(&msg, []byte("second c=3 d=4"))
+	if got, want := first, (List{"a", "1", "b", "2", "x", "y"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+	if got, want := msg.List, (List{"c", "3", "d", "4"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	input := []byte(`request complete id=42 method=GET path="/api/items" status=200 elapsed=1.5ms`)
+	b.Run("Parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			Parse(input)
+		}
+	})
+	b.Run("ParseInto", func(b *testing.B) {
+		b.ReportAllocs()
+		var msg Message
+		for n := 0; n < b.N; n++ {
+			ParseInto(&msg, input)
+		}
+	})
+}
+
+func TestWithNested(t *testing.T) {
+	tests := []struct {
+		list List
+		want List
+	}{
+		{
+			list: With("a", 1).With(With("b", 2, "c", 3)),
+			want: List{"a", 1, "b", 2, "c", 3},
+		},
+		{
+			list: With("a", 1).With([]interface{}{"b", 2, With("c", 3)}),
+			want: List{"a", 1, "b", 2, "c", 3},
+		},
+		{
+			list: With("a", 1, []interface{}{"b", 2}),
+			want: List{"a", 1, "b", 2},
+		},
+	}
+	for tn, tt := range tests {
+		if got, want := tt.list, tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d:\n got=%#v\nwant=%#v", tn, got, want)
+		}
+	}
+}
+
+func TestListFilter(t *testing.T) {
+	notInternal := func(key string, value interface{}) bool {
+		return !strings.HasPrefix(key, "_")
+	}
+	tests := []struct {
+		list List
+		keep func(string, interface{}) bool
+		want List
+	}{
+		{
+			list: List{"a", 1, "_b", 2, "c", 3, "_d", 4},
+			keep: notInternal,
+			want: List{"a", 1, "c", 3},
+		},
+		{
+			list: List{"_a", 1, "b", 2},
+			keep: notInternal,
+			want: List{"b", 2},
+		},
+		{
+			list: List{"a", 1, "b", nil},
+			keep: func(key string, value interface{}) bool { return value != nil },
+			want: List{"a", 1},
+		},
+		{
+			list: List{"a", 1, List{"_b", 2, "c", 3}},
+			keep: notInternal,
+			want: List{"a", 1, "c", 3},
+		},
+		{
+			list: List{"_a", 1},
+			keep: notInternal,
+			want: List{},
+		},
+		{
+			list: nil,
+			keep: notInternal,
+			want: nil,
+		},
+	}
+	for tn, tt := range tests {
+		if got, want := tt.list.Filter(tt.keep), tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d:\n got=%#v\nwant=%#v", tn, got, want)
+		}
+	}
+
+	// nothing dropped, so the same backing array is returned
+	list := List{"a", 1, "b", 2}
+	filtered := list.Filter(notInternal)
+	if &filtered[0] != &list[0] {
+		t.Errorf("list was copied")
+	}
+	if n := testing.AllocsPerRun(100, func() { list.Filter(notInternal) }); n != 0 {
+		t.Errorf("got=%v allocs, want=0", n)
+	}
+}
+
+func TestListShared(t *testing.T) {
+	keyvals := make([]interface{}, 0, 16) // spare capacity
+	keyvals = append(keyvals, "base", 1)
+	bases := []List{
+		With(keyvals...),
+		With("base", 1).With(),
+		List{"base", 1, "_x", 2}.Filter(func(key string, value interface{}) bool { return key != "_x" }),
+		append(make(List, 0, 16), "base", 1).Filter(func(string, interface{}) bool { return true }),
+		FromContext(NewContext(context.Background(), "base", 1)),
+	}
+	for tn, base := range bases {
+		// two goroutines extend the same base list, using both
+		// With and the builtin append function
+		const n = 100
+		var with, appended [n][2]List
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < n; j++ {
+					with[j][i] = base.With("goroutine", i, "n", j)
+					appended[j][i] = append(base, "goroutine", i, "n", j)
+				}
+			}(i)
+		}
+		wg.Wait()
+		for j := 0; j < n; j++ {
+			for i := 0; i < 2; i++ {
+				want := fmt.Sprintf("base=1 goroutine=%d n=%d", i, j)
+				if got := with[j][i].String(); got != want {
+					t.Fatalf("%d: got=%q, want=%q", tn, got, want)
+				}
+				if got := appended[j][i].String(); got != want {
+					t.Fatalf("%d: got=%q, want=%q", tn, got, want)
+				}
+			}
+		}
+		if got, want := base.String(), "base=1"; got != want {
+			t.Errorf("%d: got=%q, want=%q", tn, got, want)
+		}
+	}
+}
+
+func TestListSortedString(t *testing.T) {
+	tests := []struct {
+		list List
+		want string
+	}{
+		{
+			list: List{"c", 3, "a", 1, "b", 2},
+			want: "a=1 b=2 c=3",
+		},
+		{ // duplicate keys remain in order
+			list: List{"b", 1, "a", 2, "b", 3, "a", 4},
+			want: "a=2 a=4 b=1 b=3",
+		},
+		{
+			list: List{"z", "two words", List{"y", "", "x", `"quoted"`}},
+			want: `x="\"quoted\"" y="" z="two words"`,
+		},
+		{ // missing keys are supplied before sorting
+			list: List{"z", 1, "not found"},
+			want: `msg="not found" z=1`,
+		},
+		{
+			list: nil,
+			want: "",
+		},
+	}
+	for tn, tt := range tests {
+		if got, want := tt.list.SortedString(), tt.want; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+
+	// the list is not modified
+	list := List{"b", 1, "a", 2}
+	list.SortedString()
+	if got, want := list.String(), "b=1 a=2"; got != want {
+		t.Errorf("\n got=%q\nwant=%q", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package kv
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"message",
+		"message a=1 b=2",
+		`message a="1 2" b=3`,
+		`message a="unterminated`,
+		`message a="unterminated\`,
+		`"key"="value"`,
+		`"unterminated key=value`,
+		"=",
+		"a=",
+		"=a",
+		"a==",
+		"a= b",
+		"a=b=c",
+		`a=""`,
+		`a="\"`,
+		`a="\u12"`,
+		"a=1: text b=2",
+		"text \xfe a=\xff",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		text, list := Parse(input)
+		if len(list)%2 != 0 {
+			t.Fatalf("odd number of items in list: %q", list)
+		}
+		for i, v := range list {
+			if _, ok := v.(string); !ok {
+				t.Fatalf("item %d is %T, want string", i, v)
+			}
+		}
+		if utf8.Valid(input) && !utf8.Valid(text) {
+			t.Fatalf("invalid text %q for valid input", text)
+		}
+	})
+}
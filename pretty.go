@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"bytes"
+
+	"github.com/jjeffery/kv/internal/parse"
+	"github.com/jjeffery/kv/internal/pool"
+	"github.com/jjeffery/kv/internal/terminal"
+	"github.com/jjeffery/kv/internal/wrap"
+)
+
+// prettyIndent is the indent for continuation lines,
+// which is the same as a kvlog writer uses for messages
+// without a logger prefix, date or time.
+const prettyIndent = "    "
+
+// Pretty returns the key/value pairs formatted in the same way as a kvlog
+// writer prints them to a terminal, with lines wrapped so that each line
+// is no wider than width columns. Values are not quoted, and a key/value
+// pair is never split across lines. Continuation lines are indented by four
+// spaces. If width is zero or less, lines are not wrapped. The returned
+// text does not end with a newline.
+func (l List) Pretty(width int) string {
+	if width <= 0 {
+		width = int(^uint(0) >> 1)
+	}
+	buf := pool.AllocBuffer()
+	defer pool.ReleaseBuffer(buf)
+	l.writeToBuffer(buf)
+
+	// parsing the logfmt text gives the same keys and
+	// values that a kvlog writer would print
+	m := parse.Bytes(buf.Bytes())
+	defer m.Release()
+	p := &prettyPrinter{bol: true}
+	wrap.Pairs(p, m.List, width)
+	return p.buf.String()
+}
+
+// prettyPrinter implements wrap.Printer, printing without color.
+type prettyPrinter struct {
+	buf bytes.Buffer
+	col int
+	bol bool
+}
+
+func (p *prettyPrinter) Column() int     { return p.col }
+func (p *prettyPrinter) LineStart() bool { return p.bol }
+func (p *prettyPrinter) TabWidth() int   { return 0 }
+func (p *prettyPrinter) Escape(b []byte) { p.buf.Write(b) }
+
+func (p *prettyPrinter) CanWrap() bool {
+	return !p.bol || p.col > len(prettyIndent)
+}
+
+func (p *prettyPrinter) Newline() {
+	p.buf.WriteString("\n" + prettyIndent)
+	p.col = len(prettyIndent)
+	p.bol = true
+}
+
+func (p *prettyPrinter) Space(n int) {
+	for i := 0; i < n; i++ {
+		p.buf.WriteByte(' ')
+	}
+	p.col += n
+}
+
+func (p *prettyPrinter) Word(b []byte) {
+	p.buf.Write(b)
+	p.col += terminal.Width(b)
+	p.bol = false
+}
+
+func (p *prettyPrinter) Punct(r rune) {
+	p.buf.WriteRune(r)
+	p.col += terminal.RuneWidth(r)
+	p.bol = false
+}
+
+func (p *prettyPrinter) PairWidth(key, value []byte) int {
+	return terminal.Width(key) + 1 + terminal.Width(value)
+}
+
+func (p *prettyPrinter) Pair(key, value []byte) {
+	p.Word(key)
+	p.Punct('=')
+	p.Word(value)
+}
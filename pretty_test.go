@@ -0,0 +1,50 @@
+package kv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestListPretty(t *testing.T) {
+	tests := []struct {
+		list  List
+		width int
+		want  string
+	}{
+		{
+			list:  List{"a", 1, "b", "two words", "c", errors.New("failed")},
+			width: 80,
+			want:  "a=1 b=two words c=failed",
+		},
+		{
+			list:  List{"id", 1, "name", "alice", "email", "alice@example.com", "role", "admin"},
+			width: 20,
+			want:  "id=1 name=alice\n    email=alice@example.com\n    role=admin",
+		},
+		{ // no wrapping
+			list: List{"id", 1, "name", "alice", "email", "alice@example.com", "role", "admin"},
+			want: "id=1 name=alice email=alice@example.com role=admin",
+		},
+		{ // wide characters occupy two columns
+			list:  List{"k", "日本語", "k", "日本語", "k", "日本語"},
+			width: 18,
+			want:  "k=日本語 k=日本語\n    k=日本語",
+		},
+		{
+			list:  List{"missing"},
+			width: 80,
+			want:  "msg=missing",
+		},
+		{
+			list:  nil,
+			width: 80,
+			want:  "",
+		},
+	}
+
+	for tn, tt := range tests {
+		if got, want := tt.list.Pretty(tt.width), tt.want; got != want {
+			t.Errorf("%d:\n got=%q\nwant=%q", tn, got, want)
+		}
+	}
+}
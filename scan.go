@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"bufio"
+	"io"
+)
+
+// Message is a line of input that has been parsed by a Scanner or ParseInto.
+type Message struct {
+	Text []byte // message text
+	List List   // key/value pairs
+
+	list List // re-used for the key/value pairs of each message
+}
+
+// Scanner reads lines of input and parses each line in the same way as Parse.
+// It is intended for reading large inputs, such as log files, one line at a
+// time. The memory used for each message is re-used for the next message, so
+// there are fewer memory allocations than when calling Parse for each line.
+//
+//	scanner := kv.NewScanner(os.Stdin)
+//	for scanner.Scan() {
+//		msg := scanner.Message()
+//		fmt.Println(string(msg.Text), msg.List)
+//	}
+//	if err := scanner.Err(); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// Lines are split in the same way as bufio.ScanLines, so lines that are
+// longer than bufio.MaxScanTokenSize cannot be read unless a larger buffer
+// is provided with the Buffer method.
+type Scanner struct {
+	scanner *bufio.Scanner
+	msg     Message
+}
+
+// NewScanner returns a scanner that reads lines from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{
+		scanner: bufio.NewScanner(r),
+	}
+}
+
+// Buffer sets the initial buffer for reading lines, and the maximum
+// length of a line. It has the same meaning as the Buffer method of
+// bufio.Scanner, and it panics if it is called after scanning has started.
+func (s *Scanner) Buffer(buf []byte, max int) {
+	s.scanner.Buffer(buf, max)
+}
+
+// Scan reads the next line of input and parses it, after which the result
+// is available from the Message method. It returns false when there are no
+// more lines, either because the end of the input has been reached or
+// because of an error. After Scan returns false, the Err method returns
+// any error that occurred, except that it returns nil if it was io.EOF.
+func (s *Scanner) Scan() bool {
+	if !s.scanner.Scan() {
+		s.msg.Text, s.msg.List = nil, nil
+		return false
+	}
+	ParseInto(&s.msg, s.scanner.Bytes())
+	return true
+}
+
+// Message returns the message parsed by the most recent call to Scan.
+// The message, its text and the backing array of its list are
+// overwritten by the next call to Scan, so they must be copied if they
+// are needed after that. The keys and values in the list are strings,
+// which can be kept.
+func (s *Scanner) Message() *Message {
+	return &s.msg
+}
+
+// Err returns the first error that was encountered by the scanner,
+// except that it returns nil if the error was io.EOF.
+func (s *Scanner) Err() error {
+	return s.scanner.Err()
+}
@@ -0,0 +1,112 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	input := strings.Join([]string{
+		`message text a=1 b="value 2"`,
+		``,
+		`no pairs`,
+		`a=1`,
+		"crlf c=3\r",
+		`last line d="\"quoted\""`,
+	}, "\n")
+	want := []struct {
+		text string
+		list List
+	}{
+		{text: "message text", list: List{"a", "1", "b", "value 2"}},
+		{},
+		{text: "no pairs"},
+		{list: List{"a", "1"}},
+		{text: "crlf", list: List{"c", "3"}},
+		{text: "last line", list: List{"d", `"quoted"`}},
+	}
+
+	scanner := NewScanner(strings.NewReader(input))
+	var n int
+	var prev List
+	for scanner.Scan() {
+		if n >= len(want) {
+			t.Fatalf("got more than %d messages", len(want))
+		}
+		msg := scanner.Message()
+		if len(prev) > 0 {
+			// appending to the previous list does not overwrite this one
+			_ = append(prev, "x", "y")
+		}
+		if got, want := string(msg.Text), want[n].text; got != want {
+			t.Errorf("%d: text got=%q, want=%q", n, got, want)
+		}
+		if got, want := msg.List, want[n].list; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: list got=%v, want=%v", n, got, want)
+		}
+		prev = msg.List
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Errorf("got=%v, want=nil", err)
+	}
+	if n != len(want) {
+		t.Errorf("got=%d messages, want=%d", n, len(want))
+	}
+}
+
+func TestScannerBuffer(t *testing.T) {
+	line := "message a=" + strings.Repeat("x", 100)
+
+	scanner := NewScanner(strings.NewReader(line))
+	scanner.Buffer(nil, 50)
+	if scanner.Scan() {
+		t.Errorf("got=true, want=false")
+	}
+	if got, want := scanner.Err(), bufio.ErrTooLong; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+
+	scanner = NewScanner(strings.NewReader(line))
+	scanner.Buffer(nil, 200)
+	if !scanner.Scan() {
+		t.Fatalf("got=false, want=true: %v", scanner.Err())
+	}
+	if got, want := scanner.Message().List.String(), line[len("message "):]; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func benchmarkInput() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "request complete id=%d method=GET path=\"/api/items\" status=200 elapsed=1.5ms\n", i)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkScanner(b *testing.B) {
+	input := benchmarkInput()
+	b.Run("Parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			scanner := bufio.NewScanner(bytes.NewReader(input))
+			for scanner.Scan() {
+				Parse(scanner.Bytes())
+			}
+		}
+	})
+	b.Run("Scanner", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			scanner := NewScanner(bytes.NewReader(input))
+			for scanner.Scan() {
+				scanner.Message()
+			}
+		}
+	})
+}